@@ -2,6 +2,7 @@ package datadog
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/sniperkit/stats"
 )
@@ -43,3 +44,98 @@ func appendTags(b []byte, tags []stats.Tag) []byte {
 	}
 	return b
 }
+
+func appendEvent(b []byte, e Event) []byte {
+	b = append(b, "_e{"...)
+	b = strconv.AppendInt(b, int64(escapedLen(e.Title)), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(escapedLen(e.Text)), 10)
+	b = append(b, '}', ':')
+	b = appendEscaped(b, e.Title)
+	b = append(b, '|')
+	b = appendEscaped(b, e.Text)
+
+	if !e.Timestamp.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, e.Timestamp.Unix(), 10)
+	}
+
+	if len(e.Hostname) != 0 {
+		b = append(b, "|h:"...)
+		b = append(b, e.Hostname...)
+	}
+
+	if len(e.AggregationKey) != 0 {
+		b = append(b, "|k:"...)
+		b = append(b, e.AggregationKey...)
+	}
+
+	if len(e.Priority) != 0 {
+		b = append(b, "|p:"...)
+		b = append(b, e.Priority...)
+	}
+
+	if len(e.SourceTypeName) != 0 {
+		b = append(b, "|s:"...)
+		b = append(b, e.SourceTypeName...)
+	}
+
+	if len(e.AlertType) != 0 {
+		b = append(b, "|t:"...)
+		b = append(b, e.AlertType...)
+	}
+
+	if len(e.Tags) != 0 {
+		b = append(b, '|', '#')
+		b = appendTags(b, e.Tags)
+	}
+
+	return append(b, '\n')
+}
+
+func appendServiceCheck(b []byte, sc ServiceCheck) []byte {
+	b = append(b, "_sc|"...)
+	b = append(b, sc.Name...)
+	b = append(b, '|')
+	b = strconv.AppendInt(b, int64(sc.Status), 10)
+
+	if !sc.Timestamp.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, sc.Timestamp.Unix(), 10)
+	}
+
+	if len(sc.Hostname) != 0 {
+		b = append(b, "|h:"...)
+		b = append(b, sc.Hostname...)
+	}
+
+	if len(sc.Tags) != 0 {
+		b = append(b, '|', '#')
+		b = appendTags(b, sc.Tags)
+	}
+
+	// The message must be the last field since it may contain '|' characters.
+	if len(sc.Message) != 0 {
+		b = append(b, "|m:"...)
+		b = appendEscaped(b, sc.Message)
+	}
+
+	return append(b, '\n')
+}
+
+// appendEscaped appends s to b, escaping new lines so s fits on a single line
+// of the dogstatsd protocol.
+func appendEscaped(b []byte, s string) []byte {
+	for i := 0; i != len(s); i++ {
+		if c := s[i]; c == '\n' {
+			b = append(b, '\\', 'n')
+		} else {
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+func escapedLen(s string) int {
+	return len(s) + strings.Count(s, "\n")
+}
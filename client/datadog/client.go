@@ -2,10 +2,15 @@ package datadog
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,29 +26,69 @@ const (
 	// datadog.
 	DefaultBufferSize = 1024
 
-	// MaxBufferSize is a hard-limit on the max size of the datagram buffer.
+	// DefaultUnixBufferSize is the default size for batches of metrics sent to
+	// datadog over unix sockets, it matches the default size of the buffer
+	// that the agent reads messages into.
+	DefaultUnixBufferSize = 8192
+
+	// MaxBufferSize is a hard-limit on the max size of the UDP datagram buffer.
 	MaxBufferSize = 65507
+
+	// DefaultFlushInterval is the default maximum amount of time that metrics
+	// are buffered before being sent to datadog.
+	DefaultFlushInterval = 1 * time.Second
 )
 
 // DefaultFilter is the default tag to filter before sending to
 // datadog. Using the request path as a tag can overwhelm datadog's
 // servers if there are too many unique routes due to unique IDs being a
 // part of the path. Only change the default filter if there is a static
-// number of routes.
+// number of routes, or if the client is placed behind a stats.CardinalityGuard
+// which bounds the number of distinct tag values.
 var (
 	DefaultFilters = []string{"http_req_path"}
 )
 
+var errUnsupportedByProtocol = errors.New("stats/datadog: events and service checks are only supported by the dogstatsd protocol")
+
 // The ClientConfig type is used to configure datadog clients.
 type ClientConfig struct {
 	// Address of the datadog database to send metrics to.
+	//
+	// The address may be prefixed with "unix://" or "unixgram://" to send
+	// metrics to the agent over a unix stream or datagram socket, instead of
+	// the default UDP transport.
 	Address string
 
 	// Maximum size of batch of events sent to datadog.
 	BufferSize int
 
+	// Maximum amount of time that metrics are buffered before being sent to
+	// datadog. If zero, DefaultFlushInterval is used, a negative value
+	// disables time-based flushing.
+	FlushInterval time.Duration
+
 	// List of tags to filter. If left nil is set to DefaultFilters.
 	Filters []string
+
+	// Protocol used to send metrics, the default is DogStatsD. Setting it to
+	// StatsD allows the client to send metrics to servers that don't support
+	// the dogstatsd extensions.
+	Protocol Protocol
+
+	// TagStrategy configures how tags are encoded in metric names when the
+	// protocol is StatsD, the default is to drop them.
+	TagStrategy TagStrategy
+
+	// ErrorHandler is called with the errors that occur while sending metrics
+	// (like connection errors or metrics too large to be sent). If nil, the
+	// errors are logged. See stats.ClientReporter for details.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the client reports metrics about
+	// itself, tagged with "client:datadog". See stats.ClientReporter for
+	// details.
+	StatsEngine *stats.Engine
 }
 
 // Client represents an datadog client that implements the stats.Handler
@@ -70,7 +115,15 @@ func NewClientWith(config ClientConfig) *Client {
 	}
 
 	if config.BufferSize == 0 {
-		config.BufferSize = DefaultBufferSize
+		if network, _ := parseAddress(config.Address); network == "udp" {
+			config.BufferSize = DefaultBufferSize
+		} else {
+			config.BufferSize = DefaultUnixBufferSize
+		}
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultFlushInterval
 	}
 
 	if config.Filters == nil {
@@ -83,20 +136,37 @@ func NewClientWith(config ClientConfig) *Client {
 		filterMap[f] = struct{}{}
 	}
 
+	network, address := parseAddress(config.Address)
+
 	c := &Client{
 		serializer: serializer{
-			filters: filterMap,
+			network:  network,
+			address:  address,
+			filters:  filterMap,
+			protocol: config.Protocol,
+			tags:     config.TagStrategy,
+			report:   stats.NewClientReporter("datadog", config.ErrorHandler, config.StatsEngine),
 		},
 	}
 
-	conn, bufferSize, err := dial(config.Address, config.BufferSize)
+	conn, bufferSize, err := dial(c.network, c.address, config.BufferSize)
 	if err != nil {
-		log.Printf("stats/datadog: %s", err)
+		c.report.HandleError(err)
+	}
+
+	if bufferSize == 0 {
+		// The connection will be established when sending metrics, the size
+		// hint is the best guess we have of the buffer size until then.
+		bufferSize = config.BufferSize
 	}
 
 	c.conn, c.err, c.bufferSize = conn, err, bufferSize
 	c.buffer.BufferSize = bufferSize
 	c.buffer.Serializer = &c.serializer
+	c.buffer.FlushInterval = config.FlushInterval
+	c.buffer.ErrorHandler = c.report.HandleError
+	c.buffer.StatsEngine = c.report.Engine()
+
 	log.Printf("stats/datadog: sending metrics with a buffer of size %d B", bufferSize)
 	return c
 }
@@ -116,33 +186,61 @@ func (c *Client) Write(b []byte) (int, error) {
 	return c.serializer.Write(b)
 }
 
+// SendEvent sends e to the dogstatsd server right away, events are not
+// buffered like metrics are.
+func (c *Client) SendEvent(e Event) error {
+	return c.send(appendEvent(make([]byte, 0, 256), e))
+}
+
+// SendServiceCheck sends sc to the dogstatsd server right away, service checks
+// are not buffered like metrics are.
+func (c *Client) SendServiceCheck(sc ServiceCheck) error {
+	return c.send(appendServiceCheck(make([]byte, 0, 256), sc))
+}
+
+func (c *Client) send(b []byte) error {
+	if c.protocol != DogStatsD {
+		return errUnsupportedByProtocol
+	}
+	_, err := c.serializer.Write(b)
+	return err
+}
+
 // Close flushes and closes the client, satisfies the io.Closer interface.
 func (c *Client) Close() error {
-	c.Flush()
+	c.buffer.Close()
 	c.close()
 	return c.err
 }
 
 type serializer struct {
-	conn       net.Conn
+	network    string
+	address    string
 	bufferSize int
 	filters    map[string]struct{}
+	protocol   Protocol
+	tags       TagStrategy
+	report     *stats.ClientReporter
+
+	mutex  sync.RWMutex
+	conn   net.Conn
+	closed bool
 }
 
 func (s *serializer) AppendMeasures(b []byte, _ time.Time, measures ...stats.Measure) []byte {
 	for _, m := range measures {
-		b = AppendMeasureFiltered(b, m, s.filters)
+		if s.protocol == StatsD {
+			b = appendStatsdMeasure(b, m, s.tags, s.filters)
+		} else {
+			b = AppendMeasureFiltered(b, m, s.filters)
+		}
 	}
 	return b
 }
 
 func (s *serializer) Write(b []byte) (int, error) {
-	if s.conn == nil {
-		return 0, io.ErrClosedPipe
-	}
-
 	if len(b) <= s.bufferSize {
-		return s.conn.Write(b)
+		return s.write(b)
 	}
 
 	// When the serialized metrics are larger than the configured socket buffer
@@ -159,7 +257,7 @@ func (s *serializer) Write(b []byte) (int, error) {
 			}
 			if (i + splitIndex) >= s.bufferSize {
 				if splitIndex == 0 {
-					log.Printf("stats/datadog: metric of length %d B doesn't fit in the socket buffer of size %d B: %s", i+1, s.bufferSize, string(b))
+					s.report.HandleError(fmt.Errorf("metric of length %d B doesn't fit in the socket buffer of size %d B: %s", i+1, s.bufferSize, string(b[:i])))
 					b = b[i+1:]
 					continue
 				}
@@ -168,7 +266,13 @@ func (s *serializer) Write(b []byte) (int, error) {
 			splitIndex += i + 1
 		}
 
-		c, err := s.conn.Write(b[:splitIndex])
+		if splitIndex == 0 {
+			// The remaining metrics didn't fit in the socket buffer and were
+			// all dropped.
+			break
+		}
+
+		c, err := s.write(b[:splitIndex])
 		if err != nil {
 			return n + c, err
 		}
@@ -180,27 +284,142 @@ func (s *serializer) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// write sends b to the server, reconnecting once if the connection was lost
+// (which happens with unix sockets when the agent restarts). The errors are
+// returned and not reported, the buffer passes them to the error handler.
+func (s *serializer) write(b []byte) (int, error) {
+	s.mutex.RLock()
+	conn, closed := s.conn, s.closed
+	s.mutex.RUnlock()
+
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if conn != nil {
+		n, err := writeTo(conn, s.network, b)
+		if err == nil || !s.broken(err) {
+			return n, err
+		}
+	}
+
+	conn, err := s.reconnect(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	return writeTo(conn, s.network, b)
+}
+
+// broken returns true if err, returned by a write, means that the connection
+// has to be established again.
+func (s *serializer) broken(err error) bool {
+	switch s.network {
+	case "udp":
+		// Datagrams are sent regardless of the state of the server.
+		return false
+	case "unix":
+		// A failed write to a stream socket may have sent a partial frame,
+		// the messages that follow cannot be decoded anymore.
+		return true
+	}
+
+	// Other errors on datagram sockets, like ENOBUFS or EMSGSIZE, are about
+	// the message that couldn't be sent and leave the connection usable.
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENOTCONN) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, net.ErrClosed)
+}
+
+func (s *serializer) reconnect(broken net.Conn) (net.Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, io.ErrClosedPipe
+	}
+
+	if s.conn != broken {
+		// Another goroutine already established a new connection.
+		return s.conn, nil
+	}
+
+	if broken != nil {
+		broken.Close()
+	}
+
+	conn, _, err := dial(s.network, s.address, s.bufferSize)
+	s.conn = conn
+	return conn, err
+}
+
+func writeTo(conn net.Conn, network string, b []byte) (int, error) {
+	if network != "unix" {
+		return conn.Write(b)
+	}
+
+	// Messages sent over unix stream sockets are prefixed with their length,
+	// since there are no datagram boundaries to separate them.
+	frame := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+
+	n, err := conn.Write(frame)
+	if n -= 4; n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
 func (s *serializer) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.conn != nil {
 		s.conn.Close()
 	}
+
+	s.closed = true
+}
+
+// parseAddress splits address into a network and an address on that network,
+// it supports the "udp://", "unix://" and "unixgram://" schemes, and defaults
+// to udp when address has no scheme.
+func parseAddress(address string) (network string, addr string) {
+	for _, scheme := range [...]string{"udp", "unix", "unixgram"} {
+		if strings.HasPrefix(address, scheme+"://") {
+			return scheme, address[len(scheme)+3:]
+		}
+	}
+	return "udp", address
 }
 
-func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error) {
+func dial(network string, address string, sizehint int) (conn net.Conn, bufsize int, err error) {
 	var f *os.File
 
-	if conn, err = net.Dial("udp", address); err != nil {
+	if conn, err = net.Dial(network, address); err != nil {
+		return
+	}
+
+	if network == "unix" {
+		// Stream sockets have no limit on the size of the messages, the size
+		// hint is the size of the buffer that the agent reads messages into.
+		bufsize = sizehint
 		return
 	}
 
-	if f, err = conn.(*net.UDPConn).File(); err != nil {
+	if f, err = conn.(interface {
+		File() (*os.File, error)
+	}).File(); err != nil {
 		conn.Close()
 		return
 	}
 	defer f.Close()
 	fd := int(f.Fd())
 
-	// The kernel refuses to send UDP datagrams that are larger than the size of
+	// The kernel refuses to send datagrams that are larger than the size of
 	// the size of the socket send buffer. To maximize the number of metrics
 	// sent in one batch we attempt to attempt to adjust the kernel buffer size
 	// to accept larger datagrams, or fallback to the default socket buffer size
@@ -225,8 +444,9 @@ func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error)
 
 	// Even tho the buffer agrees to support a bigger size it shouldn't be
 	// possible to send datagrams larger than 65 KB on an IPv4 socket, so let's
-	// enforce the max size.
-	if bufsize > MaxBufferSize {
+	// enforce the max size. Unix datagram sockets are only limited by the
+	// size of the socket buffer.
+	if network == "udp" && bufsize > MaxBufferSize {
 		bufsize = MaxBufferSize
 	}
 
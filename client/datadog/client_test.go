@@ -1,10 +1,14 @@
 package datadog
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -102,3 +106,174 @@ func BenchmarkClient(b *testing.B) {
 		})
 	}
 }
+
+func TestClientUnixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.sock")
+	count := int32(0)
+
+	serve := func() net.PacketConn {
+		os.Remove(path)
+		conn, err := net.ListenPacket("unixgram", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go Serve(conn, HandlerFunc(func(m Metric, _ net.Addr) {
+			atomic.AddInt32(&count, int32(m.Value))
+		}))
+		return conn
+	}
+
+	conn := serve()
+
+	client := NewClient("unixgram://" + path)
+	defer client.Close()
+
+	if client.bufferSize != DefaultUnixBufferSize {
+		t.Error("bad buffer size:", client.bufferSize)
+	}
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	// Simulate a restart of the agent, the client must reconnect to the new
+	// socket.
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	conn = serve()
+	defer conn.Close()
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 2, stats.Counter)},
+	})
+	client.Flush()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&count); n != 3 {
+		t.Error("bad metric count:", n)
+	}
+}
+
+func TestClientUnixgramMessageTooLarge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.sock")
+
+	server, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client := NewClient("unixgram://" + path)
+	defer client.Close()
+
+	conn := client.conn
+
+	// The datagram is larger than the socket buffer, the write fails but the
+	// connection is still usable and must not be established again.
+	if _, err := client.write(make([]byte, 4*1024*1024)); err == nil {
+		t.Fatal("no error returned for a datagram larger than the socket buffer")
+	}
+
+	if client.conn != conn {
+		t.Error("the client reconnected after a message was too large")
+	}
+}
+
+func TestClientDialErrorReportedOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var errs []error
+
+	client := NewClientWith(ClientConfig{
+		Address:      "unixgram://" + filepath.Join(dir, "missing.sock"),
+		ErrorHandler: func(err error) { errs = append(errs, err) },
+	})
+	defer client.Close()
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	// One error when the client is created, and one when it's flushed.
+	if len(errs) != 2 {
+		t.Error("bad number of errors reported:", errs)
+	}
+}
+
+func TestClientUnixStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	frames := make(chan string, 10)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(conn, size[:]); err != nil {
+				return
+			}
+			b := make([]byte, binary.LittleEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(conn, b); err != nil {
+				return
+			}
+			frames <- string(b)
+		}
+	}()
+
+	client := NewClient("unix://" + path)
+	defer client.Close()
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	select {
+	case f := <-frames:
+		if f != "request.count:1|c\n" {
+			t.Errorf("bad frame: %q", f)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for metrics")
+	}
+}
@@ -0,0 +1,90 @@
+package datadog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+// EventPriority is an enumeration providing symbols to represent the
+// priorities of datadog events.
+type EventPriority string
+
+const (
+	EventPriorityNormal EventPriority = "normal"
+	EventPriorityLow    EventPriority = "low"
+)
+
+// EventAlertType is an enumeration providing symbols to represent the alert
+// types of datadog events.
+type EventAlertType string
+
+const (
+	EventAlertTypeError   EventAlertType = "error"
+	EventAlertTypeWarning EventAlertType = "warning"
+	EventAlertTypeInfo    EventAlertType = "info"
+	EventAlertTypeSuccess EventAlertType = "success"
+)
+
+// The Event type is a representation of the events supported by datadog,
+// which can be used to mark deploys or incidents on dashboards.
+type Event struct {
+	Title          string         // the event title
+	Text           string         // the event text, may contain new lines
+	Timestamp      time.Time      // the time of the event (optional)
+	Hostname       string         // the host that the event is about (optional)
+	AggregationKey string         // key used to group events (optional)
+	Priority       EventPriority  // the event priority (optional)
+	SourceTypeName string         // the source of the event (optional)
+	AlertType      EventAlertType // the event alert type (optional)
+	Tags           []stats.Tag    // the list of tags set on the event
+}
+
+// String satisfies the fmt.Stringer interface.
+func (e Event) String() string {
+	return fmt.Sprint(e)
+}
+
+// Format satisfies the fmt.Formatter interface.
+func (e Event) Format(f fmt.State, _ rune) {
+	buf := bufferPool.Get().(*buffer)
+	buf.b = appendEvent(buf.b[:0], e)
+	f.Write(buf.b)
+	bufferPool.Put(buf)
+}
+
+// ServiceCheckStatus is an enumeration providing symbols to represent the
+// status of datadog service checks.
+type ServiceCheckStatus int
+
+const (
+	ServiceCheckOK       ServiceCheckStatus = 0
+	ServiceCheckWarning  ServiceCheckStatus = 1
+	ServiceCheckCritical ServiceCheckStatus = 2
+	ServiceCheckUnknown  ServiceCheckStatus = 3
+)
+
+// The ServiceCheck type is a representation of the service checks supported
+// by datadog, which report the health of a service.
+type ServiceCheck struct {
+	Name      string             // the service check name
+	Status    ServiceCheckStatus // the status of the service
+	Timestamp time.Time          // the time of the check (optional)
+	Hostname  string             // the host that the check is about (optional)
+	Message   string             // a description of the status (optional)
+	Tags      []stats.Tag        // the list of tags set on the service check
+}
+
+// String satisfies the fmt.Stringer interface.
+func (sc ServiceCheck) String() string {
+	return fmt.Sprint(sc)
+}
+
+// Format satisfies the fmt.Formatter interface.
+func (sc ServiceCheck) Format(f fmt.State, _ rune) {
+	buf := bufferPool.Get().(*buffer)
+	buf.b = appendServiceCheck(buf.b[:0], sc)
+	f.Write(buf.b)
+	bufferPool.Put(buf)
+}
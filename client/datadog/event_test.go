@@ -0,0 +1,150 @@
+package datadog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+var testEvents = []struct {
+	s string
+	e Event
+}{
+	{
+		s: "_e{6,4}:deploy|done\n",
+		e: Event{
+			Title: "deploy",
+			Text:  "done",
+		},
+	},
+
+	{
+		s: "_e{14,24}:deploy: api|v2|version 1.2.3\\nby: alice|d:1500780960|h:web-1|k:deploys|p:low|s:go|t:success|#env:prod,service:api\n",
+		e: Event{
+			Title:          "deploy: api|v2",
+			Text:           "version 1.2.3\nby: alice",
+			Timestamp:      time.Unix(1500780960, 0),
+			Hostname:       "web-1",
+			AggregationKey: "deploys",
+			Priority:       EventPriorityLow,
+			SourceTypeName: "go",
+			AlertType:      EventAlertTypeSuccess,
+			Tags:           []stats.Tag{{"env", "prod"}, {"service", "api"}},
+		},
+	},
+}
+
+var testServiceChecks = []struct {
+	s  string
+	sc ServiceCheck
+}{
+	{
+		s: "_sc|api.health|0\n",
+		sc: ServiceCheck{
+			Name:   "api.health",
+			Status: ServiceCheckOK,
+		},
+	},
+
+	{
+		s: "_sc|api.health|2|d:1500780960|h:web-1|#env:prod|m:connection refused|retrying\\nin 5s\n",
+		sc: ServiceCheck{
+			Name:      "api.health",
+			Status:    ServiceCheckCritical,
+			Timestamp: time.Unix(1500780960, 0),
+			Hostname:  "web-1",
+			Message:   "connection refused|retrying\nin 5s",
+			Tags:      []stats.Tag{{"env", "prod"}},
+		},
+	},
+}
+
+func TestAppendEvent(t *testing.T) {
+	for _, test := range testEvents {
+		t.Run(test.s, func(t *testing.T) {
+			if s := test.e.String(); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func TestParseEventSuccess(t *testing.T) {
+	for _, test := range testEvents {
+		t.Run(test.s, func(t *testing.T) {
+			if e, err := parseEvent(test.s); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(e, test.e) {
+				t.Errorf("%#v:\n- %#v\n- %#v", test.s, test.e, e)
+			}
+		})
+	}
+}
+
+func TestParseEventFailure(t *testing.T) {
+	tests := []string{
+		"",
+		"_e{",
+		"_e{4,4:test|test",      // malformed header
+		"_e{x,4}:test|test",     // malformed title length
+		"_e{4,x}:test|test",     // malformed text length
+		"_e{0,4}:|test",         // empty title
+		"_e{4,8}:test|test",     // text shorter than its length
+		"_e{4,2}:test|test",     // text longer than its length
+		"_e{4,4}:test|test|d:x", // malformed timestamp
+		"_e{4,4}:test|test|x:y", // unknown field
+	}
+
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if _, err := parseEvent(test); err == nil {
+				t.Errorf("%#v: expected parsing error", test)
+			}
+		})
+	}
+}
+
+func TestAppendServiceCheck(t *testing.T) {
+	for _, test := range testServiceChecks {
+		t.Run(test.s, func(t *testing.T) {
+			if s := test.sc.String(); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func TestParseServiceCheckSuccess(t *testing.T) {
+	for _, test := range testServiceChecks {
+		t.Run(test.s, func(t *testing.T) {
+			if sc, err := parseServiceCheck(test.s); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(sc, test.sc) {
+				t.Errorf("%#v:\n- %#v\n- %#v", test.s, test.sc, sc)
+			}
+		})
+	}
+}
+
+func TestParseServiceCheckFailure(t *testing.T) {
+	tests := []string{
+		"",
+		"_sc|",
+		"_sc||0",             // missing name
+		"_sc|name",           // missing status
+		"_sc|name|4",         // invalid status
+		"_sc|name|x",         // malformed status
+		"_sc|name|0|d:x",     // malformed timestamp
+		"_sc|name|0|x:y|m:z", // unknown field
+	}
+
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if _, err := parseServiceCheck(test); err == nil {
+				t.Errorf("%#v: expected parsing error", test)
+			}
+		})
+	}
+}
@@ -16,6 +16,9 @@ func AppendMeasure(b []byte, m stats.Measure) []byte {
 // AppendMeasureFiltered is a formatting routine to append the dogstatsd protocol
 // representation of a measure to a memory buffer. Tags listed in the filters map
 // are removed. (some tags may not be suitable for submission to DataDog)
+//
+// Fields that were sampled are written with their sample rate, the datadog agent
+// scales the values it receives accordingly.
 func AppendMeasureFiltered(b []byte, m stats.Measure, filters map[string]struct{}) []byte {
 	for _, field := range m.Fields {
 		b = append(b, m.Name...)
@@ -49,10 +52,19 @@ func AppendMeasureFiltered(b []byte, m stats.Measure, filters map[string]struct{
 			b = append(b, '|', 'c')
 		case stats.Gauge:
 			b = append(b, '|', 'g')
+		case stats.Distribution:
+			b = append(b, '|', 'd')
+		case stats.UniqueSet:
+			b = append(b, '|', 's')
 		default:
 			b = append(b, '|', 'h')
 		}
 
+		if rate := field.Rate(); rate != 1 {
+			b = append(b, '|', '@')
+			b = strconv.AppendFloat(b, rate, 'g', -1, 64)
+		}
+
 		if n := len(m.Tags); n != 0 {
 			b = append(b, '|', '#')
 
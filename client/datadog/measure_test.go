@@ -37,6 +37,35 @@ var (
 			},
 			s: `request.count:5|c|#answer:42,hello:world
 request.rtt:0.1|h|#answer:42,hello:world
+`,
+		},
+
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("size", 512, stats.Distribution),
+					stats.MakeField("users", 42, stats.UniqueSet),
+				},
+			},
+			s: `request.size:512|d
+request.users:42|s
+`,
+		},
+
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("count", 1, stats.Counter).WithRate(0.1),
+					stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram).WithRate(0.5),
+				},
+				Tags: []stats.Tag{
+					{"answer", "42"},
+				},
+			},
+			s: `request.count:1|c|@0.1|#answer:42
+request.rtt:0.1|h|@0.5|#answer:42
 `,
 		},
 	}
@@ -12,13 +12,19 @@ import (
 type MetricType string
 
 const (
-	Counter   MetricType = "c"
-	Gauge     MetricType = "g"
-	Histogram MetricType = "h"
-	Unknown   MetricType = "?"
+	Counter      MetricType = "c"
+	Gauge        MetricType = "g"
+	Histogram    MetricType = "h"
+	Distribution MetricType = "d"
+	Set          MetricType = "s"
+	Unknown      MetricType = "?"
 )
 
 // The Metric type is a representation of the metrics supported by datadog.
+//
+// The members of sets may be arbitrary strings in the dogstatsd protocol, when
+// parsing a set with a value that isn't a number the Value field is set to a
+// hash of the string, so distinct members still have distinct values.
 type Metric struct {
 	Type      MetricType  // the metric type
 	Namespace string      // the metric namespace (never populated by parsing operations)
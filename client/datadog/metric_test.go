@@ -98,6 +98,28 @@ var testMetrics = []struct {
 		},
 	},
 
+	{
+		s: "request.size:512|d|#service:api\n",
+		m: Metric{
+			Type:  Distribution,
+			Name:  "request.size",
+			Value: 512,
+			Rate:  1,
+			Tags:  []stats.Tag{{"service", "api"}},
+		},
+	},
+
+	{
+		s: "users.uniques:1234|s\n",
+		m: Metric{
+			Type:  Set,
+			Name:  "users.uniques",
+			Value: 1234,
+			Rate:  1,
+			Tags:  nil,
+		},
+	},
+
 	{
 		s: "users.online:1|c|#country:china\n",
 		m: Metric{
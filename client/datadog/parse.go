@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sniperkit/stats"
 )
@@ -62,8 +63,14 @@ func parseMetric(s string) (m Metric, err error) {
 	var sampleRate float64
 
 	if value, err = strconv.ParseFloat(val, 64); err != nil {
-		err = fmt.Errorf("datadog: %#v has a malformed value", s)
-		return
+		if MetricType(typ) != Set {
+			err = fmt.Errorf("datadog: %#v has a malformed value", s)
+			return
+		}
+		// Set members can be any string, they are identified by their hash.
+		// The hash is truncated to 53 bits so it's exactly representable as a
+		// float64.
+		value, err = float64(stats.HashString(val)>>11), nil
 	}
 
 	if len(rate) != 0 {
@@ -85,21 +92,171 @@ func parseMetric(s string) (m Metric, err error) {
 	}
 
 	if len(tags) != 0 {
-		m.Tags = make([]stats.Tag, 0, count(tags, ',')+1)
+		m.Tags = parseTags(tags)
+	}
+
+	return
+}
+
+func parseEvent(s string) (e Event, err error) {
+	var next = strings.TrimSpace(s)
+	var head string
+	var titleLen int
+	var textLen int
+
+	if !strings.HasPrefix(next, "_e{") {
+		err = fmt.Errorf("datadog: %#v is not an event", s)
+		return
+	}
+
+	if head, next = nextToken(next[3:], ':'); !strings.HasSuffix(head, "}") {
+		err = fmt.Errorf("datadog: %#v has a malformed event header", s)
+		return
+	}
+
+	title, text := split(head[:len(head)-1], ',')
+
+	if titleLen, err = strconv.Atoi(title); err != nil || titleLen <= 0 {
+		err = fmt.Errorf("datadog: %#v has a malformed event title length", s)
+		return
+	}
 
-		for len(tags) != 0 {
-			var tag string
+	if textLen, err = strconv.Atoi(text); err != nil || textLen < 0 {
+		err = fmt.Errorf("datadog: %#v has a malformed event text length", s)
+		return
+	}
+
+	if len(next) < titleLen+1+textLen || next[titleLen] != '|' {
+		err = fmt.Errorf("datadog: %#v has an event title or text that doesn't match its length", s)
+		return
+	}
+
+	e.Title = unescape(next[:titleLen])
+	e.Text = unescape(next[titleLen+1 : titleLen+1+textLen])
+	next = next[titleLen+1+textLen:]
+
+	if len(next) != 0 {
+		if next[0] != '|' {
+			err = fmt.Errorf("datadog: %#v has an event title or text that doesn't match its length", s)
+			return
+		}
+		next = next[1:]
+	}
 
-			if tag, tags = nextToken(tags, ','); len(tag) != 0 {
-				name, value := split(tag, ':')
-				m.Tags = append(m.Tags, stats.Tag{name, value})
+	for len(next) != 0 {
+		var field string
+		field, next = nextToken(next, '|')
+
+		switch {
+		case strings.HasPrefix(field, "d:"):
+			if e.Timestamp, err = parseTimestamp(field[2:]); err != nil {
+				err = fmt.Errorf("datadog: %#v has a malformed event timestamp", s)
+				return
 			}
+		case strings.HasPrefix(field, "h:"):
+			e.Hostname = field[2:]
+		case strings.HasPrefix(field, "k:"):
+			e.AggregationKey = field[2:]
+		case strings.HasPrefix(field, "p:"):
+			e.Priority = EventPriority(field[2:])
+		case strings.HasPrefix(field, "s:"):
+			e.SourceTypeName = field[2:]
+		case strings.HasPrefix(field, "t:"):
+			e.AlertType = EventAlertType(field[2:])
+		case strings.HasPrefix(field, "#"):
+			e.Tags = parseTags(field[1:])
+		default:
+			err = fmt.Errorf("datadog: %#v has an unknown event field", s)
+			return
 		}
 	}
 
 	return
 }
 
+func parseServiceCheck(s string) (sc ServiceCheck, err error) {
+	var next = strings.TrimSpace(s)
+	var name string
+	var status string
+	var code int
+
+	if !strings.HasPrefix(next, "_sc|") {
+		err = fmt.Errorf("datadog: %#v is not a service check", s)
+		return
+	}
+
+	name, next = nextToken(next[4:], '|')
+	status, next = nextToken(next, '|')
+
+	if len(name) == 0 {
+		err = fmt.Errorf("datadog: %#v is missing a service check name", s)
+		return
+	}
+
+	if code, err = strconv.Atoi(status); err != nil || code < 0 || code > 3 {
+		err = fmt.Errorf("datadog: %#v has a malformed service check status", s)
+		return
+	}
+
+	sc.Name, sc.Status = name, ServiceCheckStatus(code)
+
+	for len(next) != 0 {
+		var field string
+
+		if strings.HasPrefix(next, "m:") {
+			// The message is always the last field and may contain '|'.
+			sc.Message, next = unescape(next[2:]), ""
+			break
+		}
+
+		field, next = nextToken(next, '|')
+
+		switch {
+		case strings.HasPrefix(field, "d:"):
+			if sc.Timestamp, err = parseTimestamp(field[2:]); err != nil {
+				err = fmt.Errorf("datadog: %#v has a malformed service check timestamp", s)
+				return
+			}
+		case strings.HasPrefix(field, "h:"):
+			sc.Hostname = field[2:]
+		case strings.HasPrefix(field, "#"):
+			sc.Tags = parseTags(field[1:])
+		default:
+			err = fmt.Errorf("datadog: %#v has an unknown service check field", s)
+			return
+		}
+	}
+
+	return
+}
+
+func parseTimestamp(s string) (t time.Time, err error) {
+	var sec int64
+	if sec, err = strconv.ParseInt(s, 10, 64); err == nil {
+		t = time.Unix(sec, 0)
+	}
+	return
+}
+
+func parseTags(s string) (tags []stats.Tag) {
+	tags = make([]stats.Tag, 0, count(s, ',')+1)
+
+	for len(s) != 0 {
+		var tag string
+
+		if tag, s = nextToken(s, ','); len(tag) != 0 {
+			name, value := split(tag, ':')
+			tags = append(tags, stats.Tag{name, value})
+		}
+	}
+
+	return
+}
+
+func unescape(s string) string {
+	return strings.Replace(s, "\\n", "\n", -1)
+}
+
 func nextToken(s string, b byte) (token string, next string) {
 	if off := strings.IndexByte(s, b); off >= 0 {
 		token, next = s[:off], s[off+1:]
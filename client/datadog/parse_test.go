@@ -17,12 +17,33 @@ func TestParseMetricSuccess(t *testing.T) {
 	}
 }
 
+func TestParseMetricSetString(t *testing.T) {
+	m1, err := parseMetric("users.uniques:alice|s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := parseMetric("users.uniques:bob|s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m1.Type != Set || m1.Name != "users.uniques" {
+		t.Errorf("bad metric: %#v", m1)
+	}
+
+	if m1.Value == m2.Value {
+		t.Error("distinct set members were parsed to the same value:", m1.Value)
+	}
+}
+
 func TestParseMetricFailure(t *testing.T) {
 	tests := []string{
 		"",
 		":10|c",             // missing name
 		"name:|c",           // missing value
 		"name:abc|c",        // malformed value
+		"name:abc|d",        // malformed value
 		"name:1",            // missing type
 		"name:1|",           // missing type
 		"name:1|c|???",      // malformed sample rate
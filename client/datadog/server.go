@@ -2,6 +2,7 @@ package datadog
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net"
 	"runtime"
@@ -25,12 +26,42 @@ func (f HandlerFunc) HandleMetric(m Metric, a net.Addr) {
 	f(m, a)
 }
 
+// EventHandler is an interface that handlers passed to dogstatsd servers may
+// implement to receive the events sent to the server, events are discarded if
+// the handler doesn't implement it.
+type EventHandler interface {
+	// HandleEvent is called when a dogstatsd server receives an event.
+	// The method receives the event and the address from which it was sent.
+	HandleEvent(Event, net.Addr)
+}
+
+// ServiceCheckHandler is an interface that handlers passed to dogstatsd
+// servers may implement to receive the service checks sent to the server,
+// service checks are discarded if the handler doesn't implement it.
+type ServiceCheckHandler interface {
+	// HandleServiceCheck is called when a dogstatsd server receives a service
+	// check. The method receives the service check and the address from which
+	// it was sent.
+	HandleServiceCheck(ServiceCheck, net.Addr)
+}
+
 // ListenAndServe starts a new dogstatsd server, listening for UDP datagrams on
 // addr and forwarding the metrics to handler.
+//
+// The address may be prefixed with "unixgram://" to listen on a unix datagram
+// socket instead, which is useful to test programs configured to send metrics
+// to the agent over a unix socket.
 func ListenAndServe(addr string, handler Handler) (err error) {
 	var conn net.PacketConn
+	var network string
 
-	if conn, err = net.ListenPacket("udp", addr); err != nil {
+	switch network, addr = parseAddress(addr); network {
+	case "udp", "unixgram":
+	default:
+		return fmt.Errorf("stats/datadog: cannot serve on %s sockets", network)
+	}
+
+	if conn, err = net.ListenPacket(network, addr); err != nil {
 		return
 	}
 
@@ -69,6 +100,8 @@ func Serve(conn net.PacketConn, handler Handler) (err error) {
 
 func serve(conn net.PacketConn, handler Handler, done chan<- error) {
 	b := make([]byte, 65536)
+	eventHandler, _ := handler.(EventHandler)
+	serviceCheckHandler, _ := handler.(ServiceCheckHandler)
 
 	for {
 		n, a, err := conn.ReadFrom(b)
@@ -89,6 +122,24 @@ func serve(conn net.PacketConn, handler Handler, done chan<- error) {
 
 			ln, s = s[:off], s[off:]
 
+			switch {
+			case bytes.HasPrefix(ln, eventPrefix):
+				if eventHandler != nil {
+					if e, err := parseEvent(string(ln)); err == nil {
+						eventHandler.HandleEvent(e, a)
+					}
+				}
+				continue
+
+			case bytes.HasPrefix(ln, serviceCheckPrefix):
+				if serviceCheckHandler != nil {
+					if sc, err := parseServiceCheck(string(ln)); err == nil {
+						serviceCheckHandler.HandleServiceCheck(sc, a)
+					}
+				}
+				continue
+			}
+
 			m, err := parseMetric(string(ln))
 			if err != nil {
 				continue
@@ -98,3 +149,8 @@ func serve(conn net.PacketConn, handler Handler, done chan<- error) {
 		}
 	}
 }
+
+var (
+	eventPrefix        = []byte("_e{")
+	serviceCheckPrefix = []byte("_sc|")
+)
@@ -2,7 +2,11 @@ package datadog
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -80,3 +84,107 @@ func startTestServer(t *testing.T, handler Handler) (addr string, closer io.Clos
 
 	return conn.LocalAddr().String(), conn
 }
+
+type testEventHandler struct {
+	events        chan Event
+	serviceChecks chan ServiceCheck
+}
+
+func (h *testEventHandler) HandleMetric(Metric, net.Addr) {}
+
+func (h *testEventHandler) HandleEvent(e Event, _ net.Addr) { h.events <- e }
+
+func (h *testEventHandler) HandleServiceCheck(sc ServiceCheck, _ net.Addr) { h.serviceChecks <- sc }
+
+func TestServerEventsAndServiceChecks(t *testing.T) {
+	handler := &testEventHandler{
+		events:        make(chan Event, 1),
+		serviceChecks: make(chan ServiceCheck, 1),
+	}
+
+	addr, closer := startTestServer(t, handler)
+	defer closer.Close()
+
+	client := NewClient(addr)
+	defer client.Close()
+
+	event := Event{
+		Title:     "deploy",
+		Text:      "version 1.2.3\nby alice",
+		Timestamp: time.Unix(1500780960, 0),
+		AlertType: EventAlertTypeInfo,
+		Tags:      []stats.Tag{{"service", "api"}},
+	}
+
+	serviceCheck := ServiceCheck{
+		Name:    "api.health",
+		Status:  ServiceCheckWarning,
+		Message: "slow responses",
+	}
+
+	if err := client.SendEvent(event); err != nil {
+		t.Error(err)
+	}
+
+	if err := client.SendServiceCheck(serviceCheck); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case e := <-handler.events:
+		if !reflect.DeepEqual(e, event) {
+			t.Errorf("bad event:\n- %#v\n- %#v", event, e)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for the event")
+	}
+
+	select {
+	case sc := <-handler.serviceChecks:
+		if !reflect.DeepEqual(sc, serviceCheck) {
+			t.Errorf("bad service check:\n- %#v\n- %#v", serviceCheck, sc)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for the service check")
+	}
+}
+
+func TestListenAndServeUnixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.sock")
+	metrics := make(chan Metric, 1)
+
+	go ListenAndServe("unixgram://"+path, HandlerFunc(func(m Metric, _ net.Addr) {
+		metrics <- m
+	}))
+
+	for i := 0; i != 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	client := NewClient("unixgram://" + path)
+	defer client.Close()
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	select {
+	case m := <-metrics:
+		if m.Name != "request.count" || m.Value != 1 {
+			t.Error("bad metric:", m)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for metrics")
+	}
+}
@@ -0,0 +1,176 @@
+package datadog
+
+import (
+	"strconv"
+
+	"github.com/sniperkit/stats"
+)
+
+// Protocol is an enumeration of the protocols that the client can use to send
+// metrics.
+type Protocol int
+
+const (
+	// DogStatsD is the protocol of the datadog agent, it extends the statsd
+	// protocol with tags.
+	DogStatsD Protocol = iota
+
+	// StatsD is the protocol of the original statsd server (and compatible
+	// implementations like statsite or brubeck), which doesn't support tags.
+	StatsD
+)
+
+// TagStrategy is an enumeration of the ways tags are encoded in metric names
+// when using the plain statsd protocol.
+type TagStrategy int
+
+const (
+	// DropTags discards the tags of the metrics.
+	DropTags TagStrategy = iota
+
+	// TagsAsPathSegments appends the tag names and values as segments of the
+	// metric name, for example "request.count.answer.42".
+	TagsAsPathSegments
+
+	// TagsAsGraphiteSuffix appends the tags to the metric name the way tagged
+	// series are written in graphite, for example "request.count;answer=42".
+	TagsAsGraphiteSuffix
+)
+
+// AppendStatsdMeasure is a formatting routine to append the plain statsd
+// protocol representation of a measure to a memory buffer, tags are encoded
+// according to the given strategy.
+//
+// Histograms and distributions are written as timers, durations are expressed
+// in milliseconds since it's the unit that statsd expects for timers. Sets are
+// written as statsd sets.
+func AppendStatsdMeasure(b []byte, m stats.Measure, tags TagStrategy) []byte {
+	return appendStatsdMeasure(b, m, tags, nil)
+}
+
+func appendStatsdMeasure(b []byte, m stats.Measure, tags TagStrategy, filters map[string]struct{}) []byte {
+	for _, field := range m.Fields {
+		start := len(b)
+		b = appendStatsdName(b, m.Name)
+		if len(field.Name) != 0 {
+			b = append(b, '.')
+			b = appendStatsdName(b, field.Name)
+		}
+
+		for _, t := range m.Tags {
+			if _, ok := filters[t.Name]; ok {
+				continue
+			}
+
+			switch tags {
+			case TagsAsPathSegments:
+				b = append(b, '.')
+				b = appendStatsdSegment(b, t.Name)
+				b = append(b, '.')
+				b = appendStatsdSegment(b, t.Value)
+
+			case TagsAsGraphiteSuffix:
+				b = append(b, ';')
+				b = appendStatsdName(b, t.Name)
+				b = append(b, '=')
+				b = appendStatsdName(b, t.Value)
+			}
+		}
+
+		b = append(b, ':')
+
+		// Statsd interprets gauge values starting with a sign as a change to
+		// the current value, negative gauges are set by resetting the gauge to
+		// zero first and then applying the negative value as a decrement.
+		if field.Type() == stats.Gauge && isNegative(field.Value) {
+			n := len(b)
+			b = append(b, '0', '|', 'g', '\n')
+			b = append(b, b[start:n]...)
+		}
+
+		switch v := field.Value; v.Type() {
+		case stats.Bool:
+			if v.Bool() {
+				b = append(b, '1')
+			} else {
+				b = append(b, '0')
+			}
+		case stats.Int:
+			b = strconv.AppendInt(b, v.Int(), 10)
+		case stats.Uint:
+			b = strconv.AppendUint(b, v.Uint(), 10)
+		case stats.Float:
+			b = strconv.AppendFloat(b, normalizeFloat(v.Float()), 'g', -1, 64)
+		case stats.Duration:
+			if t := field.Type(); t == stats.Histogram || t == stats.Distribution {
+				b = strconv.AppendFloat(b, v.Duration().Seconds()*1000, 'g', -1, 64)
+			} else {
+				b = strconv.AppendFloat(b, v.Duration().Seconds(), 'g', -1, 64)
+			}
+		default:
+			b = append(b, '0')
+		}
+
+		switch field.Type() {
+		case stats.Counter:
+			b = append(b, '|', 'c')
+		case stats.Gauge:
+			b = append(b, '|', 'g')
+		case stats.UniqueSet:
+			b = append(b, '|', 's')
+		default:
+			b = append(b, '|', 'm', 's')
+		}
+
+		if rate := field.Rate(); rate != 1 {
+			b = append(b, '|', '@')
+			b = strconv.AppendFloat(b, rate, 'g', -1, 64)
+		}
+
+		b = append(b, '\n')
+	}
+
+	return b
+}
+
+func isNegative(v stats.Value) bool {
+	switch v.Type() {
+	case stats.Int:
+		return v.Int() < 0
+	case stats.Float:
+		return v.Float() < 0
+	case stats.Duration:
+		return v.Duration() < 0
+	}
+	return false
+}
+
+// appendStatsdName appends s to b, replacing the bytes that have a special
+// meaning in the statsd protocol with underscores.
+func appendStatsdName(b []byte, s string) []byte {
+	return appendStatsdReplace(b, s, false)
+}
+
+// appendStatsdSegment is like appendStatsdName but also replaces the dots,
+// so s is a single segment of the metric name.
+func appendStatsdSegment(b []byte, s string) []byte {
+	return appendStatsdReplace(b, s, true)
+}
+
+func appendStatsdReplace(b []byte, s string, dots bool) []byte {
+	i := len(b)
+	b = append(b, s...)
+
+	for j := i; j != len(b); j++ {
+		switch c := b[j]; c {
+		case ':', '|', '@', '#', ';', '=', ' ', '\t', '\n', '\r':
+			b[j] = '_'
+		case '.':
+			if dots {
+				b[j] = '_'
+			}
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,183 @@
+package datadog
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+var testStatsdMeasure = stats.Measure{
+	Name: "request",
+	Fields: []stats.Field{
+		stats.MakeField("count", 5, stats.Counter),
+		stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram),
+		stats.MakeField("size", 42, stats.Histogram),
+		stats.MakeField("inflight", 2, stats.Gauge),
+	},
+	Tags: []stats.Tag{
+		{"host", "www.example.com"},
+		{"path", "/a:b|c"},
+	},
+}
+
+func TestAppendStatsdMeasure(t *testing.T) {
+	tests := []struct {
+		tags TagStrategy
+		s    string
+	}{
+		{
+			tags: DropTags,
+			s: `request.count:5|c
+request.rtt:100|ms
+request.size:42|ms
+request.inflight:2|g
+`,
+		},
+		{
+			tags: TagsAsPathSegments,
+			s: `request.count.host.www_example_com.path./a_b_c:5|c
+request.rtt.host.www_example_com.path./a_b_c:100|ms
+request.size.host.www_example_com.path./a_b_c:42|ms
+request.inflight.host.www_example_com.path./a_b_c:2|g
+`,
+		},
+		{
+			tags: TagsAsGraphiteSuffix,
+			s: `request.count;host=www.example.com;path=/a_b_c:5|c
+request.rtt;host=www.example.com;path=/a_b_c:100|ms
+request.size;host=www.example.com;path=/a_b_c:42|ms
+request.inflight;host=www.example.com;path=/a_b_c:2|g
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := string(AppendStatsdMeasure(nil, testStatsdMeasure, test.tags)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
+
+func TestAppendStatsdMeasureTypesAndRates(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("rtt", 100*time.Millisecond, stats.Distribution),
+			stats.MakeField("users", 42, stats.UniqueSet),
+			stats.MakeField("count", 1, stats.Counter).WithRate(0.25),
+		},
+	}
+
+	expect := `request.rtt:100|ms
+request.users:42|s
+request.count:1|c|@0.25
+`
+
+	if s := string(AppendStatsdMeasure(nil, m, DropTags)); s != expect {
+		t.Error("bad metric representation:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+}
+
+func TestAppendStatsdMeasureNegativeGauge(t *testing.T) {
+	m := stats.Measure{
+		Name: "queue",
+		Fields: []stats.Field{
+			stats.MakeField("depth", -3, stats.Gauge),
+			stats.MakeField("temp", -1.5, stats.Gauge),
+			stats.MakeField("size", 2, stats.Gauge),
+			stats.MakeField("delta", -1, stats.Counter),
+		},
+		Tags: []stats.Tag{{"host", "a"}},
+	}
+
+	expect := `queue.depth.host.a:0|g
+queue.depth.host.a:-3|g
+queue.temp.host.a:0|g
+queue.temp.host.a:-1.5|g
+queue.size.host.a:2|g
+queue.delta.host.a:-1|c
+`
+
+	if s := string(AppendStatsdMeasure(nil, m, TagsAsPathSegments)); s != expect {
+		t.Error("bad metric representation:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+}
+
+func TestClientStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var mutex sync.Mutex
+	var lines []string
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			lines = append(lines, strings.Split(strings.TrimSpace(string(b[:n])), "\n")...)
+			n = len(lines)
+			mutex.Unlock()
+			if n == 4 {
+				return
+			}
+		}
+	}()
+
+	client := NewClientWith(ClientConfig{
+		Address:     conn.LocalAddr().String(),
+		Protocol:    StatsD,
+		TagStrategy: TagsAsGraphiteSuffix,
+		Filters:     []string{"path"},
+	})
+
+	client.HandleMeasures(time.Time{}, testStatsdMeasure)
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for metrics")
+	}
+
+	expect := []string{
+		"request.count;host=www.example.com:5|c",
+		"request.rtt;host=www.example.com:100|ms",
+		"request.size;host=www.example.com:42|ms",
+		"request.inflight;host=www.example.com:2|g",
+	}
+
+	for i := range expect {
+		if lines[i] != expect[i] {
+			t.Errorf("bad metric #%d:\n- expected: %s\n- found:    %s", i, expect[i], lines[i])
+		}
+	}
+}
+
+func BenchmarkAppendStatsdMeasure(b *testing.B) {
+	buffer := make([]byte, 4096)
+
+	for i := 0; i != b.N; i++ {
+		AppendStatsdMeasure(buffer[:0], testStatsdMeasure, TagsAsPathSegments)
+	}
+}
@@ -0,0 +1,233 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+// AppendMeasure is a formatting routine to append the graphite plaintext
+// protocol representation of a measure to a memory buffer.
+//
+// Each field of the measure is written on its own line, under a path made of
+// the measure name, the tag names and values, and the field name, for example
+// "request.host.localhost.count 1 1500780960".
+func AppendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
+	for _, f := range m.Fields {
+		b = appendPath(b, m, f)
+		b = appendPlaintextValue(b, f.Value, t)
+	}
+	return b
+}
+
+// AppendTaggedMeasure is a formatting routine to append the graphite plaintext
+// protocol representation of a measure to a memory buffer, using the tagged
+// series introduced in graphite 1.1.
+//
+// Each field of the measure is written on its own line, the tags are appended
+// to the path made of the measure and field names, for example
+// "request.count;host=localhost 1 1500780960".
+func AppendTaggedMeasure(b []byte, t time.Time, m stats.Measure) []byte {
+	for _, f := range m.Fields {
+		b = appendTaggedPath(b, m, f)
+		b = appendPlaintextValue(b, f.Value, t)
+	}
+	return b
+}
+
+func appendPath(b []byte, m stats.Measure, f stats.Field) []byte {
+	n := len(b)
+	b = appendName(b, m.Name)
+
+	for _, t := range m.Tags {
+		if len(t.Value) == 0 {
+			continue
+		}
+		b = appendSeparator(b, n)
+		b = appendNode(b, t.Name)
+		b = append(b, '.')
+		b = appendNode(b, t.Value)
+	}
+
+	if len(f.Name) != 0 {
+		b = appendSeparator(b, n)
+		b = appendName(b, f.Name)
+	}
+
+	return b
+}
+
+func appendTaggedPath(b []byte, m stats.Measure, f stats.Field) []byte {
+	n := len(b)
+	b = appendName(b, m.Name)
+
+	if len(f.Name) != 0 {
+		b = appendSeparator(b, n)
+		b = appendName(b, f.Name)
+	}
+
+	for _, t := range m.Tags {
+		// Graphite rejects tags with empty values.
+		if len(t.Value) == 0 {
+			continue
+		}
+		b = append(b, ';')
+		b = appendTagName(b, t.Name)
+		b = append(b, '=')
+		b = appendTagValue(b, t.Value)
+	}
+
+	return b
+}
+
+func appendSeparator(b []byte, n int) []byte {
+	if len(b) != n {
+		b = append(b, '.')
+	}
+	return b
+}
+
+func appendPlaintextValue(b []byte, v stats.Value, t time.Time) []byte {
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, valueOf(v), 'g', -1, 64)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, t.Unix(), 10)
+	return append(b, '\n')
+}
+
+// appendName appends a metric name to b, dots are preserved since they delimit
+// the nodes of graphite paths.
+func appendName(b []byte, s string) []byte {
+	return appendSanitized(b, s, func(c byte) bool { return isSpecialPathByte(c) && c != '.' })
+}
+
+// appendNode appends a single node of a graphite path to b.
+func appendNode(b []byte, s string) []byte {
+	return appendSanitized(b, s, isSpecialPathByte)
+}
+
+func appendTagName(b []byte, s string) []byte {
+	return appendSanitized(b, s, func(c byte) bool {
+		return isSpecialPathByte(c) || c == '!' || c == '^' || c == '='
+	})
+}
+
+func appendTagValue(b []byte, s string) []byte {
+	i := len(b)
+	b = appendSanitized(b, s, func(c byte) bool { return c <= ' ' || c == ';' || c >= 0x7F })
+
+	// Tag values starting with a '~' are reserved by graphite.
+	if len(b) != i && b[i] == '~' {
+		b[i] = '_'
+	}
+
+	return b
+}
+
+func appendSanitized(b []byte, s string, special func(byte) bool) []byte {
+	i := len(b)
+	b = append(b, s...)
+
+	for j := i; j != len(b); j++ {
+		if special(b[j]) {
+			b[j] = '_'
+		}
+	}
+
+	return b
+}
+
+func isSpecialPathByte(c byte) bool {
+	return c <= ' ' || c >= 0x7F || c == '.' || c == ';'
+}
+
+// The pickle protocol encodes batches of metrics as a python list of
+// (path, (timestamp, value)) tuples, serialized with the version 2 of the
+// pickle protocol and prefixed with their length as a 32 bits big endian
+// integer.
+//
+// https://graphite.readthedocs.io/en/latest/feeding-carbon.html#the-pickle-protocol
+
+// Opcodes of the pickle protocol used to encode batches of metrics.
+const (
+	pickleProto    = 0x80
+	pickleEmpty    = ']'
+	pickleMark     = '('
+	pickleAppends  = 'e'
+	pickleStop     = '.'
+	pickleUnicode  = 'X'
+	pickleInt      = 'J'
+	pickleLong     = 0x8a
+	pickleFloat    = 'G'
+	pickleTuple2   = 0x86
+	pickleVersion2 = 2
+)
+
+// appendPickleMeasure appends the list items for each field of m to b, they are
+// wrapped into a list by appendPickleFrame before being sent.
+func appendPickleMeasure(b []byte, t time.Time, m stats.Measure, tagged bool) []byte {
+	for _, f := range m.Fields {
+		b = append(b, pickleUnicode, 0, 0, 0, 0)
+		n := len(b)
+
+		if tagged {
+			b = appendTaggedPath(b, m, f)
+		} else {
+			b = appendPath(b, m, f)
+		}
+
+		binary.LittleEndian.PutUint32(b[n-4:], uint32(len(b)-n))
+		b = appendPickleInt(b, t.Unix())
+		b = appendPickleFloat(b, valueOf(f.Value))
+		b = append(b, pickleTuple2, pickleTuple2)
+	}
+	return b
+}
+
+func appendPickleFrame(b []byte, items []byte) []byte {
+	n := len(b)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, pickleProto, pickleVersion2, pickleEmpty, pickleMark)
+	b = append(b, items...)
+	b = append(b, pickleAppends, pickleStop)
+	binary.BigEndian.PutUint32(b[n:], uint32(len(b)-(n+4)))
+	return b
+}
+
+func appendPickleInt(b []byte, v int64) []byte {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		b = append(b, pickleInt, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(v))
+		return b
+	}
+	b = append(b, pickleLong, 8, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], uint64(v))
+	return b
+}
+
+func appendPickleFloat(b []byte, f float64) []byte {
+	b = append(b, pickleFloat, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(b[len(b)-8:], math.Float64bits(f))
+	return b
+}
+
+func valueOf(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1.0
+		}
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Float:
+		return v.Float()
+	case stats.Duration:
+		return v.Duration().Seconds()
+	}
+	return 0.0
+}
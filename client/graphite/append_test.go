@@ -0,0 +1,152 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+var (
+	timestamp   = time.Date(2017, 7, 23, 3, 36, 0, 123456789, time.UTC)
+	testMeasure = stats.Measure{
+		Name: "http.req",
+		Fields: []stats.Field{
+			{Name: "count", Value: stats.ValueOf(5)},
+			{Name: "rtt", Value: stats.ValueOf(100 * time.Millisecond)},
+		},
+		Tags: []stats.Tag{
+			{"host", "www.example.com"},
+			{"path", "/ hello;world"},
+			{"empty", ""},
+			{"mode", "~all"},
+		},
+	}
+)
+
+func TestAppendMeasure(t *testing.T) {
+	const expect = "http.req.host.www_example_com.path./_hello_world.mode.~all.count 5 1500780960\n" +
+		"http.req.host.www_example_com.path./_hello_world.mode.~all.rtt 0.1 1500780960\n"
+
+	if s := string(AppendMeasure(nil, timestamp, testMeasure)); s != expect {
+		t.Errorf("\n<<< %q\n>>> %q", expect, s)
+	}
+}
+
+func TestAppendTaggedMeasure(t *testing.T) {
+	const expect = "http.req.count;host=www.example.com;path=/_hello_world;mode=_all 5 1500780960\n" +
+		"http.req.rtt;host=www.example.com;path=/_hello_world;mode=_all 0.1 1500780960\n"
+
+	if s := string(AppendTaggedMeasure(nil, timestamp, testMeasure)); s != expect {
+		t.Errorf("\n<<< %q\n>>> %q", expect, s)
+	}
+}
+
+func TestAppendPickleFrame(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			{Name: "count", Value: stats.ValueOf(5)},
+			{Name: "rtt", Value: stats.ValueOf(100 * time.Millisecond)},
+		},
+		Tags: []stats.Tag{{"answer", "42"}},
+	}
+
+	items := appendPickleMeasure(nil, timestamp, m, false)
+	items = appendPickleMeasure(items, timestamp, m, true)
+
+	metrics, err := decodePickleFrame(appendPickleFrame(nil, items))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []pickleMetric{
+		{"request.answer.42.count", 1500780960, 5},
+		{"request.answer.42.rtt", 1500780960, 0.1},
+		{"request.count;answer=42", 1500780960, 5},
+		{"request.rtt;answer=42", 1500780960, 0.1},
+	}
+
+	if !reflect.DeepEqual(metrics, expect) {
+		t.Errorf("\n<<< %v\n>>> %v", expect, metrics)
+	}
+}
+
+func BenchmarkAppendMeasure(b *testing.B) {
+	buffer := make([]byte, 4096)
+
+	for i := 0; i != b.N; i++ {
+		AppendMeasure(buffer[:0], timestamp, testMeasure)
+	}
+}
+
+func BenchmarkAppendTaggedMeasure(b *testing.B) {
+	buffer := make([]byte, 4096)
+
+	for i := 0; i != b.N; i++ {
+		AppendTaggedMeasure(buffer[:0], timestamp, testMeasure)
+	}
+}
+
+type pickleMetric struct {
+	path  string
+	time  int64
+	value float64
+}
+
+// decodePickleFrame decodes the subset of the pickle protocol produced by the
+// client, it expects a length prefix followed by a list of metric tuples.
+func decodePickleFrame(b []byte) ([]pickleMetric, error) {
+	errMalformed := errors.New("malformed pickle frame")
+
+	if len(b) < 4 || int(binary.BigEndian.Uint32(b)) != len(b)-4 {
+		return nil, errMalformed
+	}
+	b = b[4:]
+
+	if len(b) < 6 || b[0] != pickleProto || b[1] != pickleVersion2 || b[2] != pickleEmpty || b[3] != pickleMark {
+		return nil, errMalformed
+	}
+	b = b[4:]
+
+	var metrics []pickleMetric
+
+	for len(b) != 0 && b[0] != pickleAppends {
+		var m pickleMetric
+
+		if len(b) < 5 || b[0] != pickleUnicode {
+			return nil, errMalformed
+		}
+		n := int(binary.LittleEndian.Uint32(b[1:]))
+		if len(b) < 5+n {
+			return nil, errMalformed
+		}
+		m.path, b = string(b[5:5+n]), b[5+n:]
+
+		switch {
+		case len(b) >= 5 && b[0] == pickleInt:
+			m.time, b = int64(int32(binary.LittleEndian.Uint32(b[1:]))), b[5:]
+		case len(b) >= 10 && b[0] == pickleLong && b[1] == 8:
+			m.time, b = int64(binary.LittleEndian.Uint64(b[2:])), b[10:]
+		default:
+			return nil, errMalformed
+		}
+
+		if len(b) < 11 || b[0] != pickleFloat || b[9] != pickleTuple2 || b[10] != pickleTuple2 {
+			return nil, errMalformed
+		}
+		m.value, b = math.Float64frombits(binary.BigEndian.Uint64(b[1:])), b[11:]
+
+		metrics = append(metrics, m)
+	}
+
+	if len(b) != 2 || b[0] != pickleAppends || b[1] != pickleStop {
+		return nil, errMalformed
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,272 @@
+package graphite
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+const (
+	// DefaultAddress is the default address of the carbon plaintext receiver
+	// to which the graphite client tries to connect to.
+	DefaultAddress = "localhost:2003"
+
+	// DefaultPickleAddress is the default address of the carbon pickle
+	// receiver to which the graphite client tries to connect to.
+	DefaultPickleAddress = "localhost:2004"
+
+	// DefaultBufferSize is the default size for batches of metrics sent to
+	// graphite.
+	DefaultBufferSize = 8192
+
+	// DefaultTimeout is the default timeout value used when connecting and
+	// writing to graphite.
+	DefaultTimeout = 5 * time.Second
+)
+
+// Protocol represents the protocols that graphite clients can use to send
+// metrics to carbon.
+type Protocol int
+
+const (
+	// Plaintext is the line-based protocol of carbon, where each metric is
+	// sent as "<path> <value> <timestamp>".
+	Plaintext Protocol = iota
+
+	// Pickle is the protocol of carbon which sends batches of metrics encoded
+	// as python pickles, it is more efficient than the plaintext protocol.
+	Pickle
+)
+
+// String satisfies the fmt.Stringer interface.
+func (p Protocol) String() string {
+	switch p {
+	case Plaintext:
+		return "plaintext"
+	case Pickle:
+		return "pickle"
+	default:
+		return "unknown"
+	}
+}
+
+// The ClientConfig type is used to configure graphite clients.
+type ClientConfig struct {
+	// Address of the carbon receiver to send metrics to.
+	Address string
+
+	// Protocol used to send metrics to carbon, the default is Plaintext.
+	Protocol Protocol
+
+	// Maximum size of batch of metrics sent to graphite.
+	BufferSize int
+
+	// Maximum amount of time that connecting or writing to graphite may take.
+	Timeout time.Duration
+
+	// When set to true, tags are sent using the tagged series of graphite 1.1
+	// instead of being flattened into the metric paths.
+	Tagged bool
+
+	// ErrorHandler is called with the errors that occur while sending metrics,
+	// including the failed attempts that are retried. If nil, the errors are
+	// logged. See stats.ClientReporter for details.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the client reports metrics about
+	// itself, tagged with "client:graphite". See stats.ClientReporter for details.
+	StatsEngine *stats.Engine
+}
+
+// Client represents a graphite client that implements the stats.Handler
+// interface.
+//
+// The client maintains a TCP connection to carbon, which is established again
+// when writing to it fails.
+//
+// Carbon stores the raw values of the metrics, so every field is written as it
+// is regardless of its type. Sets only make sense after being aggregated, the
+// client can be placed behind a stats.Aggregator to write the estimated number
+// of distinct values instead of the set members.
+type Client struct {
+	serializer
+	buffer stats.Buffer
+}
+
+// NewClient creates and returns a new graphite client publishing metrics to
+// the plaintext receiver at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new graphite client configured with the
+// given config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		if config.Protocol == Pickle {
+			config.Address = DefaultPickleAddress
+		} else {
+			config.Address = DefaultAddress
+		}
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	c := &Client{
+		serializer: serializer{
+			address:  config.Address,
+			protocol: config.Protocol,
+			timeout:  config.Timeout,
+			tagged:   config.Tagged,
+			report:   stats.NewClientReporter("graphite", config.ErrorHandler, config.StatsEngine),
+		},
+	}
+
+	c.buffer.BufferSize = config.BufferSize
+	c.buffer.Serializer = &c.serializer
+	c.buffer.StatsEngine = c.report.Engine()
+
+	return c
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	c.buffer.HandleMeasures(time, measures...)
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.buffer.Flush()
+}
+
+// Close flushes and closes the client, satisfies the io.Closer interface.
+func (c *Client) Close() error {
+	c.Flush()
+	return c.close()
+}
+
+type serializer struct {
+	address  string
+	protocol Protocol
+	timeout  time.Duration
+	tagged   bool
+	report   *stats.ClientReporter
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	frame  []byte
+	closed bool
+}
+
+func (s *serializer) AppendMeasures(b []byte, t time.Time, measures ...stats.Measure) []byte {
+	for _, m := range measures {
+		switch {
+		case s.protocol == Pickle:
+			b = appendPickleMeasure(b, t, m, s.tagged)
+		case s.tagged:
+			b = AppendTaggedMeasure(b, t, m)
+		default:
+			b = AppendMeasure(b, t, m)
+		}
+	}
+	return b
+}
+
+func (s *serializer) Write(b []byte) (int, error) {
+	// The failures are reported after the mutex was released, so a slow
+	// error handler or stats engine doesn't block the other writes.
+	var f failures
+	s.mutex.Lock()
+	n, err := s.write(b, &f)
+	s.mutex.Unlock()
+	f.report(s.report)
+	return n, err
+}
+
+// write sends b to carbon, the mutex must be held when calling the method.
+func (s *serializer) write(b []byte, f *failures) (n int, err error) {
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	data := b
+
+	if s.protocol == Pickle {
+		s.frame = appendPickleFrame(s.frame[:0], b)
+		data = s.frame
+	}
+
+	// When writing fails the connection is established again and the write is
+	// retried once, carbon may have been restarted or the connection dropped
+	// by a load balancer.
+	for attempt := 0; attempt != 2; attempt++ {
+		if attempt != 0 {
+			f.retries++
+		}
+
+		if s.conn == nil {
+			if s.conn, err = net.DialTimeout("tcp", s.address, s.timeout); err != nil {
+				s.conn = nil
+				f.add(err)
+				return
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+
+		if _, err = s.conn.Write(data); err == nil {
+			return len(b), nil
+		}
+
+		f.add(err)
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return
+}
+
+// failures records the failures of a write, which has at most two attempts.
+type failures struct {
+	errs    [2]error
+	count   int
+	retries int
+}
+
+func (f *failures) add(err error) {
+	f.errs[f.count] = err
+	f.count++
+}
+
+func (f *failures) report(r *stats.ClientReporter) {
+	for _, err := range f.errs[:f.count] {
+		r.HandleError(err)
+	}
+	for i := 0; i != f.retries; i++ {
+		r.Retried()
+	}
+}
+
+func (s *serializer) close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != nil {
+		err = s.conn.Close()
+		s.conn = nil
+	}
+
+	s.closed = true
+	return
+}
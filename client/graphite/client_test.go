@@ -0,0 +1,233 @@
+package graphite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+func TestClient(t *testing.T) {
+	for _, protocol := range []Protocol{Plaintext, Pickle} {
+		t.Run(protocol.String(), func(t *testing.T) {
+			server := startTestServer(t, protocol)
+			defer server.Close()
+
+			client := NewClientWith(ClientConfig{
+				Address:    server.Addr(),
+				Protocol:   protocol,
+				BufferSize: 512,
+			})
+
+			for i := 0; i != 1000; i++ {
+				client.HandleMeasures(timestamp, stats.Measure{
+					Name: "request",
+					Fields: []stats.Field{
+						{Name: "count", Value: stats.ValueOf(5)},
+						{Name: "rtt", Value: stats.ValueOf(100 * time.Millisecond)},
+					},
+					Tags: []stats.Tag{
+						{"answer", "42"},
+						{"hello", "world"},
+					},
+				})
+			}
+
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+
+			metrics := server.Wait(2000)
+
+			for _, m := range metrics {
+				if m != "request.answer.42.hello.world.count 5 1500780960" && m != "request.answer.42.hello.world.rtt 0.1 1500780960" {
+					t.Errorf("bad metric: %q", m)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	var mutex sync.Mutex
+	var conns int
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns++
+			mutex.Unlock()
+			// Closing the connections right away simulates carbon being
+			// restarted.
+			conn.Close()
+		}
+	}()
+
+	client := NewClient(l.Addr().String())
+	defer client.Close()
+
+	for i := 0; i != 1000; i++ {
+		client.HandleMeasures(timestamp, stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{{Name: "count", Value: stats.ValueOf(1)}},
+		})
+		client.Flush()
+
+		mutex.Lock()
+		n := conns
+		mutex.Unlock()
+
+		if n >= 2 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Error("the client did not reconnect after the connection was closed")
+}
+
+func TestClientEmptyFlush(t *testing.T) {
+	// Nothing listens on the address, dialing carbon would report an error.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	for _, protocol := range []Protocol{Plaintext, Pickle} {
+		t.Run(protocol.String(), func(t *testing.T) {
+			var errs []error
+
+			client := NewClientWith(ClientConfig{
+				Address:      addr,
+				Protocol:     protocol,
+				ErrorHandler: func(err error) { errs = append(errs, err) },
+			})
+
+			client.Flush()
+			client.Close()
+
+			if len(errs) != 0 {
+				t.Error("the client connected to carbon on an empty flush:", errs)
+			}
+		})
+	}
+}
+
+type testServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	metrics  []string
+}
+
+func startTestServer(t *testing.T, protocol Protocol) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{listener: l}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				if protocol == Pickle {
+					s.readPickle(t, conn)
+				} else {
+					s.readPlaintext(conn)
+				}
+			}()
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *testServer) Wait(n int) []string {
+	for i := 0; i != 1000; i++ {
+		s.mutex.Lock()
+		m := s.metrics
+		s.mutex.Unlock()
+
+		if len(m) >= n {
+			return m
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+	panic(fmt.Sprintf("timeout waiting for %d metrics", n))
+}
+
+func (s *testServer) readPlaintext(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		s.mutex.Lock()
+		s.metrics = append(s.metrics, scanner.Text())
+		s.mutex.Unlock()
+	}
+}
+
+func (s *testServer) readPickle(t *testing.T, r io.Reader) {
+	for {
+		var size [4]byte
+
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+
+		frame := make([]byte, 4+binary.BigEndian.Uint32(size[:]))
+		copy(frame, size[:])
+
+		if _, err := io.ReadFull(r, frame[4:]); err != nil {
+			t.Error(err)
+			return
+		}
+
+		metrics, err := decodePickleFrame(frame)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		s.mutex.Lock()
+		for _, m := range metrics {
+			s.metrics = append(s.metrics, strings.Join([]string{m.path, fmt.Sprint(m.value), fmt.Sprint(m.time)}, " "))
+		}
+		s.mutex.Unlock()
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -32,6 +31,10 @@ const (
 	// DefaultTimeout is the default timeout value used when sending requests to
 	// InfluxDB.
 	DefaultTimeout = 5 * time.Second
+
+	// DefaultFlushInterval is the default maximum amount of time that metrics
+	// are buffered before being sent to InfluxDB.
+	DefaultFlushInterval = 10 * time.Second
 )
 
 // The ClientConfig type is used to configure InfluxDB clients.
@@ -45,16 +48,36 @@ type ClientConfig struct {
 	// Maximum size of batch of events sent to InfluxDB.
 	BufferSize int
 
+	// Maximum amount of time that metrics are buffered before being sent to
+	// InfluxDB. If zero, DefaultFlushInterval is used, a negative value
+	// disables time-based flushing.
+	FlushInterval time.Duration
+
 	// Maximum amount of time that requests to InfluxDB may take.
 	Timeout time.Duration
 
 	// Transport configures the HTTP transport used by the client to send
 	// requests to InfluxDB. By default http.DefaultTransport is used.
 	Transport http.RoundTripper
+
+	// ErrorHandler is called with the errors that occur while sending metrics,
+	// including the failed attempts that are retried. If nil, the errors are
+	// logged. See stats.ClientReporter for details.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the client reports metrics about
+	// itself, tagged with "client:influxdb". See stats.ClientReporter for details.
+	StatsEngine *stats.Engine
 }
 
 // Client represents an InfluxDB client that implements the stats.Handler
 // interface.
+//
+// InfluxDB stores the raw values of the measures, field types are not part of
+// the line protocol. Distributions are written like histograms, and the values
+// of sets are written as they were reported, so the number of distinct values
+// can be computed with a COUNT(DISTINCT(...)) query. Placing the client behind
+// a stats.Aggregator writes the estimated count of sets instead.
 type Client struct {
 	serializer
 	buffer stats.Buffer
@@ -87,10 +110,15 @@ func NewClientWith(config ClientConfig) *Client {
 		config.Timeout = DefaultTimeout
 	}
 
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+
 	c := &Client{
 		serializer: serializer{
-			url:  makeURL(config.Address, config.Database),
-			done: make(chan struct{}),
+			url:    makeURL(config.Address, config.Database),
+			done:   make(chan struct{}),
+			report: stats.NewClientReporter("influxdb", config.ErrorHandler, config.StatsEngine),
 			http: http.Client{
 				Timeout:   config.Timeout,
 				Transport: config.Transport,
@@ -100,6 +128,9 @@ func NewClientWith(config ClientConfig) *Client {
 
 	c.buffer.BufferSize = config.BufferSize
 	c.buffer.Serializer = &c.serializer
+	c.buffer.FlushInterval = config.FlushInterval
+	c.buffer.StatsEngine = c.report.Engine()
+
 	return c
 }
 
@@ -134,15 +165,16 @@ func (c *Client) Flush() {
 // Close flushes and closes the client, satisfies the io.Closer interface.
 func (c *Client) Close() error {
 	c.once.Do(func() { close(c.done) })
-	c.Flush()
+	c.buffer.Close()
 	return nil
 }
 
 type serializer struct {
-	url  *url.URL
-	http http.Client
-	once sync.Once
-	done chan struct{}
+	url    *url.URL
+	http   http.Client
+	once   sync.Once
+	done   chan struct{}
+	report *stats.ClientReporter
 }
 
 func (*serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
@@ -163,17 +195,18 @@ func (s *serializer) Write(b []byte) (n int, err error) {
 				err = context.Canceled
 				return
 			}
+			s.report.Retried()
 		}
 
 		req, _ := http.NewRequest("POST", s.url.String(), bytes.NewReader(b))
 		res, err = s.http.Do(req)
 		if err != nil {
-			log.Print("stats/influxdb:", err)
+			s.report.HandleError(err)
 			continue
 		}
 
 		if err = readResponse(res); err != nil {
-			log.Printf("stats/influxdb: POST %s: %d %s: %s", s.url, res.StatusCode, res.Status, err)
+			s.report.HandleError(fmt.Errorf("POST %s: %s: %s", s.url, res.Status, err))
 			continue
 		}
 
@@ -0,0 +1,369 @@
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+const (
+	// DefaultAddress is the default URL to which the client sends metrics, it
+	// is the OTLP/HTTP endpoint of a collector running on the local host.
+	DefaultAddress = "http://localhost:4318/v1/metrics"
+
+	// DefaultBufferSize is the default size for batches of metrics sent in a
+	// single export request.
+	DefaultBufferSize = 1024 * 1024 // 1 MB
+
+	// DefaultTimeout is the default timeout value used when sending requests to
+	// the collector.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultQueueSize is the default number of export requests waiting to be
+	// sent to the collector.
+	DefaultQueueSize = 16
+
+	// DefaultSeriesTimeout is the default amount of time after which series
+	// that didn't receive any values are forgotten by the client.
+	DefaultSeriesTimeout = 10 * time.Minute
+
+	// DefaultScope is the default name of the instrumentation scope that the
+	// metrics are reported under.
+	DefaultScope = "github.com/sniperkit/stats"
+)
+
+// Temporality represents the aggregation temporality of the sums and
+// histograms exported by a client.
+type Temporality int
+
+const (
+	// Delta temporality reports the change of sums and histograms since their
+	// last data point.
+	Delta Temporality = 1
+
+	// Cumulative temporality reports the values of sums and histograms since
+	// the client first saw them.
+	Cumulative Temporality = 2
+)
+
+// String satisfies the fmt.Stringer interface.
+func (t Temporality) String() string {
+	switch t {
+	case Delta:
+		return "delta"
+	case Cumulative:
+		return "cumulative"
+	default:
+		return "unspecified"
+	}
+}
+
+// The ClientConfig type is used to configure OTLP clients.
+type ClientConfig struct {
+	// URL of the OTLP/HTTP metrics endpoint to send metrics to.
+	Address string
+
+	// Maximum size of batch of metrics sent to the collector.
+	BufferSize int
+
+	// Maximum amount of time that requests to the collector may take.
+	Timeout time.Duration
+
+	// Maximum number of export requests waiting to be sent to the collector,
+	// the batches of metrics produced while the queue is full are dropped.
+	QueueSize int
+
+	// Transport configures the HTTP transport used by the client to send
+	// requests. By default http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Temporality of the sums and histograms exported by the client, the
+	// default is Cumulative.
+	Temporality Temporality
+
+	// Resource is a list of attributes describing the entity producing the
+	// metrics, for example service.name.
+	Resource []stats.Tag
+
+	// Name of the instrumentation scope of the exported metrics, the default
+	// is DefaultScope.
+	Scope string
+
+	// SeriesTimeout is the amount of time after which the client forgets the
+	// state of series that didn't receive any values, a series seen again
+	// after being forgotten restarts from a new start time. The default is
+	// DefaultSeriesTimeout, a negative value keeps series forever.
+	SeriesTimeout time.Duration
+
+	// Buckets is the registry of histogram buckets used by the client.
+	// If nil, stats.Buckets is used instead.
+	Buckets stats.HistogramBuckets
+
+	// ErrorHandler is called with the errors that occur while sending metrics,
+	// including the failed attempts that are retried. If nil, the errors are
+	// logged. See stats.ClientReporter for details.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the client reports metrics about
+	// itself, tagged with "client:otlp". See stats.ClientReporter for details.
+	StatsEngine *stats.Engine
+}
+
+// Client represents an OTLP client that implements the stats.Handler interface
+// and exports metrics to an OpenTelemetry collector over OTLP/HTTP.
+//
+// Counters are exported as monotonic sums, gauges as gauges, and histograms as
+// explicit bucket histograms, sums and histograms carry the temporality set on
+// the client configuration. Sampled counters and histograms are scaled by the
+// inverse of their sample rate, histogram counts being integers the scale is
+// rounded to the nearest whole number.
+//
+// Distributions are exported like histograms. Sets are exported as gauges of
+// the number of distinct values, estimated with a stats.HyperLogLog, seen since
+// the series was created with Cumulative temporality, or since the last export
+// request with Delta temporality.
+type Client struct {
+	serializer
+	buffer stats.Buffer
+}
+
+// NewClient creates and returns a new OTLP client exporting metrics to the
+// collector at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new OTLP client configured with the
+// given config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+
+	if config.Temporality == 0 {
+		config.Temporality = Cumulative
+	}
+
+	if len(config.Scope) == 0 {
+		config.Scope = DefaultScope
+	}
+
+	if config.SeriesTimeout == 0 {
+		config.SeriesTimeout = DefaultSeriesTimeout
+	}
+
+	if config.Buckets == nil {
+		config.Buckets = stats.Buckets
+	}
+
+	c := &Client{
+		serializer: serializer{
+			url:         config.Address,
+			temporality: config.Temporality,
+			buckets:     config.Buckets,
+			timeout:     config.SeriesTimeout,
+			series:      make(map[string]*series),
+			resource:    appendResource(nil, config.Resource),
+			scope:       appendScope(nil, config.Scope),
+			queue:       make(chan []byte, config.QueueSize),
+			done:        make(chan struct{}),
+			report:      stats.NewClientReporter("otlp", config.ErrorHandler, config.StatsEngine),
+			http: http.Client{
+				Timeout:   config.Timeout,
+				Transport: config.Transport,
+			},
+		},
+	}
+
+	c.buffer.BufferSize = config.BufferSize
+	// Data points of a series must be exported in order, using a single buffer
+	// guarantees that batches are written in the order they were produced.
+	c.buffer.BufferPoolSize = 1
+	c.buffer.Serializer = &c.serializer
+	c.buffer.StatsEngine = c.report.Engine()
+
+	c.join.Add(1)
+	go c.run()
+	return c
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	c.buffer.HandleMeasures(time, measures...)
+}
+
+// Flush satisfies the stats.Flusher interface.
+//
+// The export requests are sent in the background, Flush doesn't wait for the
+// collector to receive them.
+func (c *Client) Flush() {
+	c.buffer.Flush()
+}
+
+// Close flushes and closes the client, satisfies the io.Closer interface.
+//
+// The export requests waiting to be sent are attempted once more, requests that
+// fail are not retried anymore.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.buffer.Close()
+
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mutex.Unlock()
+
+	c.join.Wait()
+	return nil
+}
+
+type serializer struct {
+	url         string
+	http        http.Client
+	temporality Temporality
+	buckets     stats.HistogramBuckets
+	resource    []byte
+	scope       []byte
+	once        sync.Once
+	done        chan struct{}
+	report      *stats.ClientReporter
+
+	// The export requests are sent by a background goroutine so the buffer
+	// isn't held while waiting to retry failed requests.
+	mutex  sync.Mutex
+	closed bool
+	queue  chan []byte
+	join   sync.WaitGroup
+
+	series  map[string]*series
+	sets    []*series // sets updated since the last export, in delta mode
+	timeout time.Duration
+	expired time.Time
+	key     []byte
+}
+
+func (s *serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
+	s.expire(time)
+
+	for _, m := range measures {
+		b = s.appendMeasure(b, time, m)
+	}
+	return b
+}
+
+func (s *serializer) Write(b []byte) (n int, err error) {
+	defer s.exported()
+	body := appendExportRequest(make([]byte, 0, len(b)+256), s.resource, s.scope, b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		// The client was closed, the metrics are sent right away.
+		s.send(body)
+		return len(b), nil
+	}
+
+	select {
+	case s.queue <- body:
+		return len(b), nil
+	default:
+		err = fmt.Errorf("stats: dropping %d bytes of metrics, %d export requests are waiting to be sent to %s", len(b), cap(s.queue), s.url)
+		s.report.HandleError(err)
+		return 0, err
+	}
+}
+
+// run is the background goroutine sending the export requests of the queue,
+// the queue is closed when the client is closed.
+func (s *serializer) run() {
+	defer s.join.Done()
+
+	for body := range s.queue {
+		s.send(body)
+	}
+}
+
+// send posts body to the collector, retrying requests that failed until the
+// client is closed.
+func (s *serializer) send(body []byte) {
+	for attempt := 0; attempt != 10; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-time.After(s.http.Timeout):
+			case <-s.done:
+				return
+			}
+			s.report.Retried()
+		}
+
+		req, _ := http.NewRequest("POST", s.url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+
+		res, err := s.http.Do(req)
+		if err != nil {
+			s.report.HandleError(err)
+			continue
+		}
+
+		if err = readResponse(res); err != nil {
+			s.report.HandleError(fmt.Errorf("POST %s: %s", s.url, err))
+
+			if !retryable(res.StatusCode) {
+				return
+			}
+
+			continue
+		}
+
+		return
+	}
+}
+
+// retryable returns true if a request which received a response with the given
+// status code may be sent again, as defined by the OTLP specification.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func readResponse(r *http.Response) error {
+	defer r.Body.Close()
+
+	if r.StatusCode < 300 {
+		io.Copy(ioutil.Discard, r.Body)
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	return fmt.Errorf("%d %s: %s", r.StatusCode, http.StatusText(r.StatusCode), bytes.TrimSpace(b))
+}
@@ -0,0 +1,206 @@
+package otlp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+func TestClient(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []request
+
+	// The server is a stand-in for the OTLP/HTTP receiver of a collector.
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/metrics" {
+			t.Errorf("bad request path: %s", req.URL.Path)
+		}
+
+		if h := req.Header.Get("Content-Type"); h != "application/x-protobuf" {
+			t.Errorf("bad Content-Type header: %q", h)
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+
+		r, err := decodeExportRequest(b)
+		if err != nil {
+			t.Error(err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mutex.Lock()
+		requests = append(requests, r)
+		mutex.Unlock()
+
+		res.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL + "/v1/metrics",
+		BufferSize: 4096,
+		QueueSize:  1000,
+		Resource:   []stats.Tag{{"service.name", "test"}},
+	})
+
+	for i := 0; i != 1000; i++ {
+		client.HandleMeasures(time.Now(), stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 1, stats.Counter),
+			},
+			Tags: tags,
+		})
+	}
+
+	client.Close()
+
+	if len(requests) < 2 {
+		t.Errorf("measures were not batched in multiple requests: %d", len(requests))
+	}
+
+	var metrics []metric
+
+	for _, r := range requests {
+		if !reflect.DeepEqual(r.resource, map[string]string{"service.name": "test"}) {
+			t.Errorf("bad resource: %v", r.resource)
+		}
+		if r.scope != DefaultScope {
+			t.Errorf("bad scope: %q", r.scope)
+		}
+		metrics = append(metrics, r.metrics...)
+	}
+
+	if len(metrics) != 1000 {
+		t.Fatalf("bad number of metrics received: %d", len(metrics))
+	}
+
+	for i, m := range metrics {
+		if m.value != float64(i+1) {
+			t.Errorf("bad value at index %d: %g", i, m.value)
+			break
+		}
+	}
+}
+
+func TestClientRejectedRequest(t *testing.T) {
+	var mutex sync.Mutex
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		http.Error(res, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Close()
+
+	if requests != 1 {
+		t.Errorf("rejected requests must not be retried, %d requests were made", requests)
+	}
+}
+
+func TestClientUnavailableCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var errors int
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		BufferSize: 1,
+		QueueSize:  2,
+		Timeout:    time.Second,
+		ErrorHandler: func(error) {
+			mutex.Lock()
+			errors++
+			mutex.Unlock()
+		},
+	})
+
+	// Every call fills the buffer and produces an export request, the calls
+	// must not wait for the failed requests to be retried.
+	start := time.Now()
+
+	for i := 0; i != 10; i++ {
+		client.HandleMeasures(time.Now(), stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		})
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("handling measures took too long while the collector was failing: %s", elapsed)
+	}
+
+	start = time.Now()
+	client.Close()
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("closing the client took too long while the collector was failing: %s", elapsed)
+	}
+
+	if errors == 0 {
+		t.Error("no errors were reported")
+	}
+}
+
+func BenchmarkClient(b *testing.B) {
+	for _, N := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("write a batch of %d measures to a client", N), func(b *testing.B) {
+			client := NewClientWith(ClientConfig{
+				Address:   DefaultAddress,
+				Transport: &discardTransport{},
+			})
+			defer client.Close()
+
+			timestamp := time.Now()
+			measures := make([]stats.Measure, N)
+
+			for i := range measures {
+				measures[i] = stats.Measure{
+					Name: "benchmark.test.metric",
+					Fields: []stats.Field{
+						stats.MakeField("value", 42, stats.Gauge),
+					},
+					Tags: tags,
+				}
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					client.HandleMeasures(timestamp, measures...)
+				}
+			})
+		})
+	}
+}
+
+type discardTransport struct{}
+
+func (t *discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,308 @@
+package otlp
+
+import (
+	"math"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+// This file contains a minimal encoder for the OTLP metrics protobuf messages,
+// only the fields that the client needs to produce are supported.
+//
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/collector/metrics/v1/metrics_service.proto
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+
+// series carries the state of counters, histograms and sets, which is needed
+// to set the start time of data points and to compute cumulative values.
+type series struct {
+	start   time.Time
+	last    time.Time
+	value   float64
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	buckets []uint64
+	unique  stats.HyperLogLog
+	pending bool // the set has values which were not exported yet
+}
+
+// appendMeasure appends each field of m to b as a Metric message, encoded as
+// an entry of the metrics field of a ScopeMetrics message. Because repeated
+// fields may be concatenated, the output of successive calls can be wrapped in
+// a single export request.
+//
+// The method must not be called concurrently, the client relies on having a
+// single buffer to serialize the calls.
+func (s *serializer) appendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
+	for _, f := range m.Fields {
+		var name = concat(m.Name, f.Name)
+		var value = valueOf(f.Value)
+		var rate = f.Rate()
+		var metric []byte
+
+		metric = protobuf.AppendString(metric, 1, name)
+
+		if f.Value.Type() == stats.Duration && f.Type() != stats.UniqueSet {
+			metric = protobuf.AppendString(metric, 3, "s")
+		}
+
+		switch f.Type() {
+		case stats.Counter:
+			state := s.lookup(name, m.Tags, t, 0)
+			start := state.last
+			value /= rate
+
+			if s.temporality == Cumulative {
+				state.value += value
+				start, value = state.start, state.value
+			}
+
+			state.last = t
+
+			sum := protobuf.AppendBytes(nil, 1, appendNumberDataPoint(nil, m.Tags, start, t, value))
+			sum = protobuf.AppendVarint(sum, 2, uint64(s.temporality))
+			sum = protobuf.AppendVarint(sum, 3, 1) // is_monotonic
+			metric = protobuf.AppendBytes(metric, 7, sum)
+
+		case stats.UniqueSet:
+			state := s.lookup(name, m.Tags, t, 0)
+			state.unique.Add(f.Value)
+			state.last = t
+
+			if s.temporality == Delta && !state.pending {
+				state.pending = true
+				s.sets = append(s.sets, state)
+			}
+
+			gauge := protobuf.AppendBytes(nil, 1, appendNumberDataPoint(nil, m.Tags, time.Time{}, t, float64(state.unique.Count())))
+			metric = protobuf.AppendBytes(metric, 5, gauge)
+
+		case stats.Histogram, stats.Distribution:
+			bounds := s.buckets[stats.Key{Measure: m.Name, Field: f.Name}]
+			state := s.lookup(name, m.Tags, t, len(bounds)+1)
+			// Counts are integers in OTLP, the weight of sampled values is
+			// rounded to the nearest whole number.
+			weight := uint64(1/rate + 0.5)
+			point := histogramDataPoint{
+				start:   state.last,
+				time:    t,
+				count:   weight,
+				sum:     value * float64(weight),
+				min:     value,
+				max:     value,
+				bounds:  bounds,
+				buckets: make([]uint64, len(bounds)+1),
+			}
+			point.buckets[bucketIndex(bounds, value)] += weight
+
+			if s.temporality == Cumulative {
+				if state.count == 0 || value < state.min {
+					state.min = value
+				}
+				if state.count == 0 || value > state.max {
+					state.max = value
+				}
+				state.count += weight
+				state.sum += value * float64(weight)
+				state.buckets[bucketIndex(bounds, value)] += weight
+
+				point.start = state.start
+				point.count = state.count
+				point.sum = state.sum
+				point.min = state.min
+				point.max = state.max
+				point.buckets = state.buckets[:len(bounds)+1]
+			}
+
+			state.last = t
+
+			histogram := protobuf.AppendBytes(nil, 1, appendHistogramDataPoint(nil, m.Tags, point))
+			histogram = protobuf.AppendVarint(histogram, 2, uint64(s.temporality))
+			metric = protobuf.AppendBytes(metric, 9, histogram)
+
+		default:
+			gauge := protobuf.AppendBytes(nil, 1, appendNumberDataPoint(nil, m.Tags, time.Time{}, t, value))
+			metric = protobuf.AppendBytes(metric, 5, gauge)
+		}
+
+		b = protobuf.AppendBytes(b, 2, metric)
+	}
+
+	return b
+}
+
+// lookup returns the state of the series identified by name and tags, creating
+// it if it didn't exist yet.
+func (s *serializer) lookup(name string, tags []stats.Tag, t time.Time, buckets int) *series {
+	s.key = append(s.key[:0], name...)
+
+	for _, tag := range tags {
+		s.key = append(s.key, 0)
+		s.key = append(s.key, tag.Name...)
+		s.key = append(s.key, 0)
+		s.key = append(s.key, tag.Value...)
+	}
+
+	state := s.series[string(s.key)]
+
+	if state == nil {
+		state = &series{start: t, last: t}
+		s.series[string(s.key)] = state
+	}
+
+	if len(state.buckets) < buckets {
+		// The buckets of a histogram may have been set after it was first
+		// seen, counts of the new buckets start at zero.
+		state.buckets = append(state.buckets, make([]uint64, buckets-len(state.buckets))...)
+	}
+
+	return state
+}
+
+// expire removes the series which didn't receive any values for longer than the
+// series timeout. The series are scanned at most once per timeout period.
+func (s *serializer) expire(now time.Time) {
+	if s.timeout <= 0 || now.Sub(s.expired) < s.timeout {
+		return
+	}
+
+	for key, state := range s.series {
+		if now.Sub(state.last) >= s.timeout {
+			delete(s.series, key)
+		}
+	}
+
+	s.expired = now
+}
+
+// exported is called after an export request was sent, in delta mode the sets
+// start counting distinct values from scratch for the next request.
+func (s *serializer) exported() {
+	for i, state := range s.sets {
+		state.unique.Reset()
+		state.pending = false
+		s.sets[i] = nil
+	}
+	s.sets = s.sets[:0]
+}
+
+type histogramDataPoint struct {
+	start   time.Time
+	time    time.Time
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	bounds  []stats.Value
+	buckets []uint64
+}
+
+func bucketIndex(bounds []stats.Value, value float64) int {
+	for i, limit := range bounds {
+		if value <= valueOf(limit) {
+			return i
+		}
+	}
+	return len(bounds)
+}
+
+func appendExportRequest(b []byte, resource []byte, scope []byte, metrics []byte) []byte {
+	sm := make([]byte, 0, len(scope)+len(metrics)+16)
+	sm = protobuf.AppendBytes(sm, 1, scope)
+	sm = append(sm, metrics...)
+
+	rm := make([]byte, 0, len(resource)+len(sm)+16)
+	rm = protobuf.AppendBytes(rm, 1, resource)
+	rm = protobuf.AppendBytes(rm, 2, sm)
+
+	return protobuf.AppendBytes(b, 1, rm)
+}
+
+func appendResource(b []byte, attributes []stats.Tag) []byte {
+	for _, attr := range attributes {
+		b = protobuf.AppendBytes(b, 1, appendKeyValue(nil, attr))
+	}
+	return b
+}
+
+func appendScope(b []byte, name string) []byte {
+	return protobuf.AppendString(b, 1, name)
+}
+
+func appendNumberDataPoint(b []byte, tags []stats.Tag, start time.Time, t time.Time, value float64) []byte {
+	for _, tag := range tags {
+		b = protobuf.AppendBytes(b, 7, appendKeyValue(nil, tag))
+	}
+	if !start.IsZero() {
+		b = protobuf.AppendFixed64(b, 2, uint64(start.UnixNano()))
+	}
+	b = protobuf.AppendFixed64(b, 3, uint64(t.UnixNano()))
+	b = protobuf.AppendDouble(b, 4, value)
+	return b
+}
+
+func appendHistogramDataPoint(b []byte, tags []stats.Tag, point histogramDataPoint) []byte {
+	for _, tag := range tags {
+		b = protobuf.AppendBytes(b, 9, appendKeyValue(nil, tag))
+	}
+
+	b = protobuf.AppendFixed64(b, 2, uint64(point.start.UnixNano()))
+	b = protobuf.AppendFixed64(b, 3, uint64(point.time.UnixNano()))
+	b = protobuf.AppendFixed64(b, 4, point.count)
+	b = protobuf.AppendDouble(b, 5, point.sum)
+
+	counts := make([]byte, 0, 8*len(point.buckets))
+	for _, c := range point.buckets {
+		counts = protobuf.AppendUint64(counts, c)
+	}
+	b = protobuf.AppendBytes(b, 6, counts)
+
+	if len(point.bounds) != 0 {
+		bounds := make([]byte, 0, 8*len(point.bounds))
+		for _, v := range point.bounds {
+			bounds = protobuf.AppendUint64(bounds, math.Float64bits(valueOf(v)))
+		}
+		b = protobuf.AppendBytes(b, 7, bounds)
+	}
+
+	b = protobuf.AppendDouble(b, 11, point.min)
+	b = protobuf.AppendDouble(b, 12, point.max)
+	return b
+}
+
+func appendKeyValue(b []byte, tag stats.Tag) []byte {
+	b = protobuf.AppendString(b, 1, tag.Name)
+	b = protobuf.AppendBytes(b, 2, protobuf.AppendString(nil, 1, tag.Value))
+	return b
+}
+
+func concat(prefix string, suffix string) string {
+	if len(prefix) == 0 {
+		return suffix
+	}
+	if len(suffix) == 0 {
+		return prefix
+	}
+	return prefix + "." + suffix
+}
+
+func valueOf(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1.0
+		}
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Float:
+		return v.Float()
+	case stats.Duration:
+		return v.Duration().Seconds()
+	}
+	return 0.0
+}
@@ -0,0 +1,446 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+var (
+	timestamp = time.Date(2017, 7, 23, 3, 36, 0, 123456789, time.UTC)
+	tags      = []stats.Tag{{"answer", "42"}, {"hello", "world"}}
+	attrs     = map[string]string{"answer": "42", "hello": "world"}
+)
+
+func TestAppendMeasures(t *testing.T) {
+	buckets := stats.HistogramBuckets{}
+	buckets.Set("request:rtt", 0.25, 1)
+
+	t1 := timestamp
+	t2 := timestamp.Add(10 * time.Second)
+
+	measures := []struct {
+		t time.Time
+		m stats.Measure
+	}{
+		{t1, stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 1, stats.Counter),
+				stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram),
+				stats.MakeField("inflight", 3, stats.Gauge),
+			},
+			Tags: tags,
+		}},
+		{t2, stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 2, stats.Counter),
+				stats.MakeField("rtt", 500*time.Millisecond, stats.Histogram),
+			},
+			Tags: tags,
+		}},
+	}
+
+	tests := []struct {
+		temporality Temporality
+		metrics     []metric
+	}{
+		{
+			temporality: Cumulative,
+			metrics: []metric{
+				{name: "request.count", kind: "sum", temporality: 2, monotonic: true, attrs: attrs, start: t1, time: t1, value: 1},
+				{name: "request.rtt", unit: "s", kind: "histogram", temporality: 2, attrs: attrs, start: t1, time: t1, count: 1, value: 0.1, min: 0.1, max: 0.1, bounds: []float64{0.25, 1}, buckets: []uint64{1, 0, 0}},
+				{name: "request.inflight", kind: "gauge", attrs: attrs, time: t1, value: 3},
+				{name: "request.count", kind: "sum", temporality: 2, monotonic: true, attrs: attrs, start: t1, time: t2, value: 3},
+				{name: "request.rtt", unit: "s", kind: "histogram", temporality: 2, attrs: attrs, start: t1, time: t2, count: 2, value: 0.6, min: 0.1, max: 0.5, bounds: []float64{0.25, 1}, buckets: []uint64{1, 1, 0}},
+			},
+		},
+		{
+			temporality: Delta,
+			metrics: []metric{
+				{name: "request.count", kind: "sum", temporality: 1, monotonic: true, attrs: attrs, start: t1, time: t1, value: 1},
+				{name: "request.rtt", unit: "s", kind: "histogram", temporality: 1, attrs: attrs, start: t1, time: t1, count: 1, value: 0.1, min: 0.1, max: 0.1, bounds: []float64{0.25, 1}, buckets: []uint64{1, 0, 0}},
+				{name: "request.inflight", kind: "gauge", attrs: attrs, time: t1, value: 3},
+				{name: "request.count", kind: "sum", temporality: 1, monotonic: true, attrs: attrs, start: t1, time: t2, value: 2},
+				{name: "request.rtt", unit: "s", kind: "histogram", temporality: 1, attrs: attrs, start: t1, time: t2, count: 1, value: 0.5, min: 0.5, max: 0.5, bounds: []float64{0.25, 1}, buckets: []uint64{0, 1, 0}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.temporality.String(), func(t *testing.T) {
+			s := &serializer{
+				temporality: test.temporality,
+				buckets:     buckets,
+				series:      make(map[string]*series),
+			}
+
+			var b []byte
+			for _, m := range measures {
+				b = s.AppendMeasures(b, m.t, m.m)
+			}
+
+			found, err := decodeMetrics(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(found) != len(test.metrics) {
+				t.Fatalf("bad number of metrics: %d != %d", len(found), len(test.metrics))
+			}
+
+			for i := range found {
+				// Sums of floats are compared with a tolerance.
+				if math.Abs(found[i].value-test.metrics[i].value) < 1e-9 {
+					found[i].value = test.metrics[i].value
+				}
+				if !reflect.DeepEqual(found[i], test.metrics[i]) {
+					t.Errorf("metric #%d mismatch:\n- expected: %+v\n- found:    %+v", i, test.metrics[i], found[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAppendMeasuresDistributionAndSet(t *testing.T) {
+	s := &serializer{
+		temporality: Delta,
+		buckets:     stats.HistogramBuckets{},
+		series:      make(map[string]*series),
+	}
+
+	var b []byte
+	for _, v := range []int{1, 2, 1} {
+		b = s.AppendMeasures(b, timestamp, stats.Measure{
+			Name: "job",
+			Fields: []stats.Field{
+				stats.MakeField("size", v, stats.Distribution),
+				stats.MakeField("users", v, stats.UniqueSet),
+			},
+			Tags: tags,
+		})
+	}
+
+	found, err := decodeMetrics(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []metric{
+		{name: "job.size", kind: "histogram", temporality: 1, attrs: attrs, start: timestamp, time: timestamp, count: 1, value: 1, min: 1, max: 1, buckets: []uint64{1}},
+		{name: "job.users", kind: "gauge", attrs: attrs, time: timestamp, value: 1},
+		{name: "job.size", kind: "histogram", temporality: 1, attrs: attrs, start: timestamp, time: timestamp, count: 1, value: 2, min: 2, max: 2, buckets: []uint64{1}},
+		{name: "job.users", kind: "gauge", attrs: attrs, time: timestamp, value: 2},
+		{name: "job.size", kind: "histogram", temporality: 1, attrs: attrs, start: timestamp, time: timestamp, count: 1, value: 1, min: 1, max: 1, buckets: []uint64{1}},
+		{name: "job.users", kind: "gauge", attrs: attrs, time: timestamp, value: 2},
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("metrics mismatch")
+		t.Logf("expected: %+v", expect)
+		t.Logf("found:    %+v", found)
+	}
+}
+
+func TestAppendMeasuresDeltaSetReset(t *testing.T) {
+	s := &serializer{
+		temporality: Delta,
+		buckets:     stats.HistogramBuckets{},
+		series:      make(map[string]*series),
+	}
+
+	var b []byte
+	for i, v := range []int{1, 2, 1} {
+		if i == 2 {
+			// Distinct values are counted again after each export request.
+			s.exported()
+		}
+		b = s.AppendMeasures(b, timestamp, stats.Measure{
+			Name:   "job",
+			Fields: []stats.Field{stats.MakeField("users", v, stats.UniqueSet)},
+		})
+	}
+
+	found, err := decodeMetrics(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, value := range []float64{1, 2, 1} {
+		if found[i].value != value {
+			t.Errorf("bad value of metric #%d: %g", i, found[i].value)
+		}
+	}
+}
+
+func TestAppendMeasuresSeriesTimeout(t *testing.T) {
+	s := &serializer{
+		temporality: Cumulative,
+		buckets:     stats.HistogramBuckets{},
+		series:      make(map[string]*series),
+		timeout:     time.Minute,
+	}
+
+	count := func(name string) stats.Measure {
+		return stats.Measure{
+			Name:   name,
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		}
+	}
+
+	s.AppendMeasures(nil, timestamp, count("a"), count("b"))
+	s.AppendMeasures(nil, timestamp.Add(30*time.Second), count("b"))
+	b := s.AppendMeasures(nil, timestamp.Add(70*time.Second), count("a"))
+
+	if len(s.series) != 2 {
+		t.Error("bad number of series:", len(s.series))
+	}
+
+	found, err := decodeMetrics(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The series of "a" expired, it restarts from a new start time.
+	if m := found[0]; m.value != 1 || !m.start.Equal(timestamp.Add(70*time.Second)) {
+		t.Errorf("bad metric: %+v", m)
+	}
+
+	s.AppendMeasures(nil, timestamp.Add(150*time.Second))
+
+	if len(s.series) != 0 {
+		t.Error("idle series were not removed:", len(s.series))
+	}
+}
+
+func TestAppendMeasuresSampled(t *testing.T) {
+	s := &serializer{
+		temporality: Cumulative,
+		buckets:     stats.HistogramBuckets{},
+		series:      make(map[string]*series),
+	}
+
+	b := s.AppendMeasures(nil, timestamp, stats.Measure{
+		Name: "job",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter).WithRate(0.1),
+			stats.MakeField("size", 2, stats.Histogram).WithRate(0.25),
+		},
+		Tags: tags,
+	})
+
+	found, err := decodeMetrics(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []metric{
+		{name: "job.count", kind: "sum", temporality: 2, monotonic: true, attrs: attrs, start: timestamp, time: timestamp, value: 10},
+		{name: "job.size", kind: "histogram", temporality: 2, attrs: attrs, start: timestamp, time: timestamp, count: 4, value: 8, min: 2, max: 2, buckets: []uint64{4}},
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("metrics mismatch")
+		t.Logf("expected: %+v", expect)
+		t.Logf("found:    %+v", found)
+	}
+}
+
+func BenchmarkAppendMeasures(b *testing.B) {
+	s := &serializer{
+		temporality: Cumulative,
+		buckets:     stats.HistogramBuckets{},
+		series:      make(map[string]*series),
+	}
+
+	m := stats.Measure{
+		Name: "benchmark.test.metric",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("value", 42, stats.Gauge),
+		},
+		Tags: tags,
+	}
+
+	buf := make([]byte, 0, 1024)
+
+	for i := 0; i != b.N; i++ {
+		buf = s.AppendMeasures(buf[:0], timestamp, m)
+	}
+}
+
+// metric is a flattened representation of a Metric message carrying a single
+// data point, which is what the client produces.
+type metric struct {
+	name        string
+	unit        string
+	kind        string
+	temporality uint64
+	monotonic   bool
+	attrs       map[string]string
+	start       time.Time
+	time        time.Time
+	value       float64 // value of number data points, sum of histograms
+	count       uint64
+	min         float64
+	max         float64
+	bounds      []float64
+	buckets     []uint64
+}
+
+type request struct {
+	resource map[string]string
+	scope    string
+	metrics  []metric
+}
+
+func decodeExportRequest(b []byte) (req request, err error) {
+	err = protobuf.Decode(b, func(field int, v []byte, u uint64) error {
+		if field != 1 {
+			return fmt.Errorf("unexpected ExportMetricsServiceRequest field: %d", field)
+		}
+		return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+			switch field {
+			case 1:
+				req.resource = map[string]string{}
+				return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+					return decodeKeyValue(v, req.resource)
+				})
+			case 2:
+				return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+					switch field {
+					case 1:
+						return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+							if field == 1 {
+								req.scope = string(v)
+							}
+							return nil
+						})
+					case 2:
+						m, err := decodeMetric(v)
+						req.metrics = append(req.metrics, m)
+						return err
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func decodeMetrics(b []byte) ([]metric, error) {
+	var metrics []metric
+	err := protobuf.Decode(b, func(field int, v []byte, u uint64) error {
+		if field != 2 {
+			return fmt.Errorf("unexpected ScopeMetrics field: %d", field)
+		}
+		m, err := decodeMetric(v)
+		metrics = append(metrics, m)
+		return err
+	})
+	return metrics, err
+}
+
+func decodeMetric(b []byte) (m metric, err error) {
+	m.attrs = map[string]string{}
+
+	point := func(attrsField int) func(int, []byte, uint64) error {
+		return func(field int, v []byte, u uint64) error {
+			switch field {
+			case attrsField:
+				return decodeKeyValue(v, m.attrs)
+			case 2:
+				m.start = time.Unix(0, int64(u)).UTC()
+			case 3:
+				m.time = time.Unix(0, int64(u)).UTC()
+			}
+
+			if m.kind == "histogram" {
+				switch field {
+				case 4:
+					m.count = u
+				case 5:
+					m.value = math.Float64frombits(u)
+				case 6:
+					for ; len(v) >= 8; v = v[8:] {
+						m.buckets = append(m.buckets, binary.LittleEndian.Uint64(v))
+					}
+				case 7:
+					for ; len(v) >= 8; v = v[8:] {
+						m.bounds = append(m.bounds, math.Float64frombits(binary.LittleEndian.Uint64(v)))
+					}
+				case 11:
+					m.min = math.Float64frombits(u)
+				case 12:
+					m.max = math.Float64frombits(u)
+				}
+			} else if field == 4 {
+				m.value = math.Float64frombits(u)
+			}
+			return nil
+		}
+	}
+
+	data := func(attrsField int) func(int, []byte, uint64) error {
+		return func(field int, v []byte, u uint64) error {
+			switch field {
+			case 1:
+				return protobuf.Decode(v, point(attrsField))
+			case 2:
+				m.temporality = u
+			case 3:
+				m.monotonic = u != 0
+			}
+			return nil
+		}
+	}
+
+	err = protobuf.Decode(b, func(field int, v []byte, u uint64) error {
+		switch field {
+		case 1:
+			m.name = string(v)
+		case 3:
+			m.unit = string(v)
+		case 5:
+			m.kind = "gauge"
+			return protobuf.Decode(v, data(7))
+		case 7:
+			m.kind = "sum"
+			return protobuf.Decode(v, data(7))
+		case 9:
+			m.kind = "histogram"
+			return protobuf.Decode(v, data(9))
+		default:
+			return fmt.Errorf("unexpected Metric field: %d", field)
+		}
+		return nil
+	})
+	return
+}
+
+func decodeKeyValue(b []byte, attrs map[string]string) error {
+	var key string
+	return protobuf.Decode(b, func(field int, v []byte, u uint64) error {
+		switch field {
+		case 1:
+			key = string(v)
+		case 2:
+			return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+				if field == 1 {
+					attrs[key] = string(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
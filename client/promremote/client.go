@@ -0,0 +1,323 @@
+package promremote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sniperkit/stats"
+)
+
+const (
+	// DefaultAddress is the default URL to which the remote-write client sends
+	// metrics.
+	DefaultAddress = "http://localhost:9090/api/v1/write"
+
+	// DefaultBufferSize is the default size for batches of time series sent in
+	// a single remote-write request (before compression).
+	DefaultBufferSize = 1024 * 1024 // 1 MB
+
+	// DefaultTimeout is the default timeout value used when sending requests to
+	// the remote-write endpoint.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultSeriesTimeout is the default amount of time after which series
+	// that didn't receive any values are forgotten by the client.
+	DefaultSeriesTimeout = 10 * time.Minute
+
+	// DefaultQueueSize is the default number of remote-write requests waiting
+	// to be sent to the endpoint.
+	DefaultQueueSize = 16
+)
+
+// The ClientConfig type is used to configure remote-write clients.
+type ClientConfig struct {
+	// URL of the remote-write endpoint to send metrics to.
+	Address string
+
+	// Maximum size of batch of time series sent to the endpoint.
+	BufferSize int
+
+	// Maximum amount of time that requests to the endpoint may take.
+	Timeout time.Duration
+
+	// Maximum number of remote-write requests waiting to be sent to the
+	// endpoint, the batches of time series produced while the queue is full
+	// are dropped.
+	QueueSize int
+
+	// Transport configures the HTTP transport used by the client to send
+	// requests. By default http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// SeriesTimeout is the amount of time after which the client forgets the
+	// state of series that didn't receive any values, counters and histograms
+	// seen again after being forgotten restart from zero, which prometheus
+	// handles as a counter reset. The default is DefaultSeriesTimeout, a
+	// negative value keeps series forever.
+	SeriesTimeout time.Duration
+
+	// Buckets is the registry of histogram buckets used by the client.
+	// If nil, stats.Buckets is used instead.
+	Buckets stats.HistogramBuckets
+
+	// ErrorHandler is called with the errors that occur while sending metrics,
+	// including the failed attempts that are retried. If nil, the errors are
+	// logged. See stats.ClientReporter for details.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the client reports metrics about
+	// itself, tagged with "client:promremote". See stats.ClientReporter for details.
+	StatsEngine *stats.Engine
+}
+
+// Client represents a prometheus remote-write client that implements the
+// stats.Handler interface.
+//
+// The client is intended for programs that cannot be scraped, like short-lived
+// batch jobs. Counters and histograms are reported as cumulative values, which
+// is what prometheus expects, so the client keeps in memory the state of every
+// time series that it has seen. Sampled counters and histograms are scaled by
+// the inverse of their sample rate.
+//
+// Distributions are reported like histograms. Sets are reported as gauges of
+// the number of distinct values seen since the series was created, estimated
+// with a stats.HyperLogLog. Series that stop receiving values are forgotten
+// after the series timeout set on the client configuration.
+//
+// Metric and label names are generated the same way the prometheus handler
+// does, so metrics are named the same whether they are pulled or pushed.
+type Client struct {
+	serializer
+	buffer stats.Buffer
+}
+
+// NewClient creates and returns a new remote-write client publishing metrics
+// to the endpoint at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new remote-write client configured with
+// the given config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+
+	if config.SeriesTimeout == 0 {
+		config.SeriesTimeout = DefaultSeriesTimeout
+	}
+
+	if config.Buckets == nil {
+		config.Buckets = stats.Buckets
+	}
+
+	c := &Client{
+		serializer: serializer{
+			url:     config.Address,
+			buckets: config.Buckets,
+			timeout: config.SeriesTimeout,
+			series:  make(map[string]*series),
+			queue:   make(chan []byte, config.QueueSize),
+			done:    make(chan struct{}),
+			report:  stats.NewClientReporter("promremote", config.ErrorHandler, config.StatsEngine),
+			http: http.Client{
+				Timeout:   config.Timeout,
+				Transport: config.Transport,
+			},
+		},
+	}
+
+	c.buffer.BufferSize = config.BufferSize
+	// Samples of a time series must be sent in order, using a single buffer
+	// guarantees that batches are written in the order they were produced.
+	c.buffer.BufferPoolSize = 1
+	c.buffer.Serializer = &c.serializer
+	c.buffer.StatsEngine = c.report.Engine()
+
+	c.join.Add(1)
+	go c.run()
+	return c
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	c.buffer.HandleMeasures(time, measures...)
+}
+
+// Flush satisfies the stats.Flusher interface.
+//
+// The remote-write requests are sent in the background, Flush doesn't wait for
+// the endpoint to receive them.
+func (c *Client) Flush() {
+	c.buffer.Flush()
+}
+
+// Close flushes and closes the client, satisfies the io.Closer interface.
+//
+// The remote-write requests waiting to be sent are attempted once more,
+// requests that fail are not retried anymore.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.buffer.Close()
+
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mutex.Unlock()
+
+	c.join.Wait()
+	return nil
+}
+
+type serializer struct {
+	url     string
+	http    http.Client
+	buckets stats.HistogramBuckets
+	once    sync.Once
+	done    chan struct{}
+	report  *stats.ClientReporter
+
+	// The remote-write requests are sent by a background goroutine so the
+	// buffer isn't held while waiting to retry failed requests.
+	mutex  sync.Mutex
+	closed bool
+	queue  chan []byte
+	join   sync.WaitGroup
+
+	series  map[string]*series
+	timeout time.Duration
+	expired time.Time
+	key     []byte
+	labels  []label
+}
+
+func (s *serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
+	s.expire(time)
+
+	for _, m := range measures {
+		b = s.appendMeasure(b, time, m)
+	}
+	return b
+}
+
+func (s *serializer) Write(b []byte) (n int, err error) {
+	body := snappy.Encode(nil, b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		// The client was closed, the time series are sent right away.
+		s.send(body)
+		return len(b), nil
+	}
+
+	select {
+	case s.queue <- body:
+		return len(b), nil
+	default:
+		err = fmt.Errorf("stats: dropping %d bytes of time series, %d remote-write requests are waiting to be sent to %s", len(b), cap(s.queue), s.url)
+		s.report.HandleError(err)
+		return 0, err
+	}
+}
+
+// run is the background goroutine sending the remote-write requests of the
+// queue, the queue is closed when the client is closed.
+func (s *serializer) run() {
+	defer s.join.Done()
+
+	for body := range s.queue {
+		s.send(body)
+	}
+}
+
+// send posts body to the remote-write endpoint, retrying requests that failed
+// until the client is closed.
+func (s *serializer) send(body []byte) {
+	for attempt := 0; attempt != 10; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-time.After(s.http.Timeout):
+			case <-s.done:
+				return
+			}
+			s.report.Retried()
+		}
+
+		req, _ := http.NewRequest("POST", s.url, bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		res, err := s.http.Do(req)
+		if err != nil {
+			s.report.HandleError(err)
+			continue
+		}
+
+		if err = readResponse(res); err != nil {
+			s.report.HandleError(fmt.Errorf("POST %s: %s", s.url, err))
+
+			if !retryable(res.StatusCode) {
+				// The data was rejected, sending it again would only produce
+				// the same error.
+				return
+			}
+
+			continue
+		}
+
+		return
+	}
+}
+
+// retryable returns true if a request which received a response with the given
+// status code may be sent again, the endpoint is overloaded or temporarily
+// unavailable.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func readResponse(r *http.Response) error {
+	defer r.Body.Close()
+
+	if r.StatusCode < 300 {
+		io.Copy(ioutil.Discard, r.Body)
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	return fmt.Errorf("%d %s: %s", r.StatusCode, http.StatusText(r.StatusCode), bytes.TrimSpace(b))
+}
@@ -0,0 +1,278 @@
+package promremote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestClient(t *testing.T) {
+	var mutex sync.Mutex
+	var series []timeSeries
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		for header, value := range map[string]string{
+			"Content-Encoding":                  "snappy",
+			"Content-Type":                      "application/x-protobuf",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+		} {
+			if h := req.Header.Get(header); h != value {
+				t.Errorf("bad %s header: %q", header, h)
+			}
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+
+		b, err := snappy.Decode(nil, b)
+		if err != nil {
+			t.Error(err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s, err := decodeWriteRequest(b)
+		if err != nil {
+			t.Error(err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mutex.Lock()
+		series = append(series, s...)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		BufferSize: 4096,
+		QueueSize:  1000,
+	})
+
+	for i := 0; i != 1000; i++ {
+		client.HandleMeasures(time.Now(), stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 1, stats.Counter),
+			},
+			Tags: []stats.Tag{
+				{"answer", "42"},
+				{"hello", "world"},
+			},
+		})
+	}
+
+	client.Close()
+
+	if len(series) != 1000 {
+		t.Fatalf("bad number of time series received: %d", len(series))
+	}
+
+	for i, s := range series {
+		if s.value != float64(i+1) {
+			t.Errorf("bad value at index %d: %g", i, s.value)
+			break
+		}
+	}
+}
+
+func TestClientRejectedRequest(t *testing.T) {
+	var mutex sync.Mutex
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		http.Error(res, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Close()
+
+	if requests != 1 {
+		t.Errorf("rejected requests must not be retried, %d requests were made", requests)
+	}
+}
+
+func TestClientErrorHandler(t *testing.T) {
+	var mutex sync.Mutex
+	var requests int
+	var errs []error
+	retried := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		n := requests
+		mutex.Unlock()
+
+		switch n {
+		case 1:
+			http.Error(res, "rate limited", http.StatusTooManyRequests)
+		case 2:
+			close(retried)
+		}
+	}))
+	defer server.Close()
+
+	h := &statstest.Handler{}
+	client := NewClientWith(ClientConfig{
+		Address:      server.URL,
+		Timeout:      10 * time.Millisecond,
+		ErrorHandler: func(err error) { errs = append(errs, err) },
+		StatsEngine:  stats.NewEngine("", h),
+	})
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	// Closing the client cancels the retries, the test waits for the request
+	// to be sent again first.
+	select {
+	case <-retried:
+	case <-time.After(time.Second):
+		t.Error("the failed request was not retried")
+	}
+
+	client.Close()
+
+	if requests != 2 {
+		t.Errorf("the failed request must have been retried once, %d requests were made", requests)
+	}
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "429") {
+		t.Error("bad errors:", errs)
+	}
+
+	var retries, flushes int64
+
+	for _, m := range h.Measures() {
+		if len(m.Tags) != 1 || m.Tags[0] != stats.T("client", "promremote") {
+			t.Error("bad tags:", m)
+		}
+
+		switch m.Name {
+		case "stats.client":
+			retries += m.Fields[0].Value.Int()
+		case "stats.buffer":
+			flushes += m.Fields[2].Value.Int()
+		}
+	}
+
+	if retries != 1 {
+		t.Error("bad number of retries:", retries)
+	}
+
+	if flushes != 1 {
+		t.Error("bad number of flushes:", flushes)
+	}
+}
+
+func TestClientUnavailableEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var errors int
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		BufferSize: 1,
+		QueueSize:  2,
+		Timeout:    time.Second,
+		ErrorHandler: func(error) {
+			mutex.Lock()
+			errors++
+			mutex.Unlock()
+		},
+	})
+
+	// Every call fills the buffer and produces a remote-write request, the
+	// calls must not wait for the failed requests to be retried.
+	start := time.Now()
+
+	for i := 0; i != 10; i++ {
+		client.HandleMeasures(time.Now(), stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		})
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("handling measures took too long while the endpoint was failing: %s", elapsed)
+	}
+
+	start = time.Now()
+	client.Close()
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("closing the client took too long while the endpoint was failing: %s", elapsed)
+	}
+
+	if errors == 0 {
+		t.Error("no errors were reported")
+	}
+}
+
+func BenchmarkClient(b *testing.B) {
+	for _, N := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("write a batch of %d measures to a client", N), func(b *testing.B) {
+			client := NewClientWith(ClientConfig{
+				Address:   DefaultAddress,
+				Transport: &discardTransport{},
+			})
+			defer client.Close()
+
+			timestamp := time.Now()
+			measures := make([]stats.Measure, N)
+
+			for i := range measures {
+				measures[i] = stats.Measure{
+					Name: "benchmark.test.metric",
+					Fields: []stats.Field{
+						stats.MakeField("value", 42, stats.Gauge),
+					},
+					Tags: []stats.Tag{
+						{"answer", "42"},
+						{"hello", "world"},
+					},
+				}
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					client.HandleMeasures(timestamp, measures...)
+				}
+			})
+		})
+	}
+}
+
+type discardTransport struct{}
+
+func (t *discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,208 @@
+package promremote
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/backend/prometheus"
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+// This file contains a minimal encoder for the prometheus.WriteRequest protobuf
+// message, only the fields that the client needs to produce are supported.
+//
+// https://github.com/prometheus/prometheus/blob/master/prompb/remote.proto
+// https://github.com/prometheus/prometheus/blob/master/prompb/types.proto
+
+// series carries the cumulative state of counters, histograms and sets, which
+// are reported to the remote-write endpoint as totals since the series was
+// created.
+type series struct {
+	value   float64
+	sum     float64
+	count   float64 // counts of sampled values may not be whole numbers
+	buckets []float64
+	unique  stats.HyperLogLog
+	last    time.Time
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type labelsByName []label
+
+func (l labelsByName) Len() int               { return len(l) }
+func (l labelsByName) Swap(i int, j int)      { l[i], l[j] = l[j], l[i] }
+func (l labelsByName) Less(i int, j int) bool { return l[i].name < l[j].name }
+
+// appendMeasure appends the time series of each field of m to b, encoded as
+// entries of the timeseries field of a WriteRequest message. Because repeated
+// fields may be concatenated, the output of successive calls can be sent as a
+// single request.
+//
+// The method must not be called concurrently, the client relies on having a
+// single buffer to serialize the calls.
+func (s *serializer) appendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
+	s.labels = s.labels[:0]
+
+	for _, tag := range m.Tags {
+		s.labels = append(s.labels, label{
+			name:  string(prometheus.AppendLabelName(nil, tag.Name)),
+			value: tag.Value,
+		})
+	}
+
+	sort.Stable(labelsByName(s.labels))
+
+	for _, f := range m.Fields {
+		name := string(prometheus.AppendMetricScopedName(nil, m.Name, f.Name))
+		value := valueOf(f.Value)
+		weight := 1 / f.Rate()
+
+		switch f.Type() {
+		case stats.Counter:
+			state := s.lookup(name, 0, t)
+			state.value += value * weight
+			b = s.appendTimeSeries(b, name, label{}, state.value, t)
+
+		case stats.UniqueSet:
+			state := s.lookup(name, 0, t)
+			state.unique.Add(f.Value)
+			b = s.appendTimeSeries(b, name, label{}, float64(state.unique.Count()), t)
+
+		case stats.Histogram, stats.Distribution:
+			buckets := s.buckets[stats.Key{Measure: m.Name, Field: f.Name}]
+			state := s.lookup(name, len(buckets), t)
+			state.sum += value * weight
+			state.count += weight
+
+			for i, limit := range buckets {
+				if value <= valueOf(limit) {
+					state.buckets[i] += weight
+					break
+				}
+			}
+
+			var cumulativeCount float64
+			for i, limit := range buckets {
+				cumulativeCount += state.buckets[i]
+				le := strconv.FormatFloat(valueOf(limit), 'g', -1, 64)
+				b = s.appendTimeSeries(b, name+"_bucket", label{"le", le}, cumulativeCount, t)
+			}
+
+			b = s.appendTimeSeries(b, name+"_bucket", label{"le", "+Inf"}, state.count, t)
+			b = s.appendTimeSeries(b, name+"_count", label{}, state.count, t)
+			b = s.appendTimeSeries(b, name+"_sum", label{}, state.sum, t)
+
+		default:
+			b = s.appendTimeSeries(b, name, label{}, value, t)
+		}
+	}
+
+	return b
+}
+
+// lookup returns the state of the series identified by name and the labels
+// currently held by the serializer, creating it if it didn't exist yet.
+func (s *serializer) lookup(name string, buckets int, t time.Time) *series {
+	s.key = append(s.key[:0], name...)
+
+	for _, l := range s.labels {
+		s.key = append(s.key, 0)
+		s.key = append(s.key, l.name...)
+		s.key = append(s.key, 0)
+		s.key = append(s.key, l.value...)
+	}
+
+	state := s.series[string(s.key)]
+
+	if state == nil {
+		state = &series{buckets: make([]float64, buckets)}
+		s.series[string(s.key)] = state
+	}
+
+	if len(state.buckets) < buckets {
+		// The buckets of a histogram may have been set after it was first
+		// seen, counts of the new buckets start at zero.
+		state.buckets = append(state.buckets, make([]float64, buckets-len(state.buckets))...)
+	}
+
+	state.last = t
+	return state
+}
+
+// expire removes the series which didn't receive any values for longer than the
+// series timeout. The series are scanned at most once per timeout period.
+func (s *serializer) expire(now time.Time) {
+	if s.timeout <= 0 || now.Sub(s.expired) < s.timeout {
+		return
+	}
+
+	for key, state := range s.series {
+		if now.Sub(state.last) >= s.timeout {
+			delete(s.series, key)
+		}
+	}
+
+	s.expired = now
+}
+
+func (s *serializer) appendTimeSeries(b []byte, name string, extra label, value float64, t time.Time) []byte {
+	m := make([]byte, 0, 128)
+	n := label{"__name__", name}
+	i := 0
+
+	// Remote-write requires the labels of a series to be sorted by name, the
+	// metric name and the extra label are merged with the sorted tags.
+	for ; i != len(s.labels) && s.labels[i].name < n.name; i++ {
+		m = protobuf.AppendBytes(m, 1, appendProtoLabel(nil, s.labels[i]))
+	}
+
+	m = protobuf.AppendBytes(m, 1, appendProtoLabel(nil, n))
+
+	for ; i != len(s.labels); i++ {
+		if len(extra.name) != 0 && extra.name < s.labels[i].name {
+			m = protobuf.AppendBytes(m, 1, appendProtoLabel(nil, extra))
+			extra = label{}
+		}
+		m = protobuf.AppendBytes(m, 1, appendProtoLabel(nil, s.labels[i]))
+	}
+
+	if len(extra.name) != 0 {
+		m = protobuf.AppendBytes(m, 1, appendProtoLabel(nil, extra))
+	}
+
+	sample := protobuf.AppendDouble(nil, 1, value)
+	sample = protobuf.AppendVarint(sample, 2, uint64(t.UnixNano()/1e6))
+	m = protobuf.AppendBytes(m, 2, sample)
+
+	return protobuf.AppendBytes(b, 1, m)
+}
+
+func appendProtoLabel(b []byte, l label) []byte {
+	b = protobuf.AppendString(b, 1, l.name)
+	b = protobuf.AppendString(b, 2, l.value)
+	return b
+}
+
+func valueOf(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1.0
+		}
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Float:
+		return v.Float()
+	case stats.Duration:
+		return v.Duration().Seconds()
+	}
+	return 0.0
+}
@@ -0,0 +1,309 @@
+package promremote
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+var timestamp = time.Date(2017, 7, 23, 3, 36, 0, 123456789, time.UTC)
+
+func TestAppendMeasures(t *testing.T) {
+	buckets := stats.HistogramBuckets{}
+	buckets.Set("http.req:rtt", 0.25, 0.5, 1)
+
+	s := &serializer{
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	tags := []stats.Tag{
+		{"path", "/"},
+		{"Zone", "us-west-2"},
+		{"http.method", "GET"},
+	}
+
+	var b []byte
+
+	b = s.AppendMeasures(b, timestamp, stats.Measure{
+		Name: "http.req",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram),
+		},
+		Tags: tags,
+	})
+
+	b = s.AppendMeasures(b, timestamp, stats.Measure{
+		Name: "http.req",
+		Fields: []stats.Field{
+			stats.MakeField("count", 2, stats.Counter),
+			stats.MakeField("rtt", 750*time.Millisecond, stats.Histogram),
+			stats.MakeField("inflight", 3, stats.Gauge),
+		},
+		Tags: tags,
+	})
+
+	labels := func(name string, extra ...label) []label {
+		l := []label{{"Zone", "us-west-2"}, {"__name__", name}, {"http_method", "GET"}}
+		l = append(l, extra...)
+		return append(l, label{"path", "/"})
+	}
+
+	ms := timestamp.UnixNano() / 1e6
+
+	expect := []timeSeries{
+		{labels("http_req_count"), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "0.25"}), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "0.5"}), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "1"}), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "+Inf"}), 1, ms},
+		{labels("http_req_rtt_count"), 1, ms},
+		{labels("http_req_rtt_sum"), 0.1, ms},
+
+		{labels("http_req_count"), 3, ms},
+		{labels("http_req_rtt_bucket", label{"le", "0.25"}), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "0.5"}), 1, ms},
+		{labels("http_req_rtt_bucket", label{"le", "1"}), 2, ms},
+		{labels("http_req_rtt_bucket", label{"le", "+Inf"}), 2, ms},
+		{labels("http_req_rtt_count"), 2, ms},
+		{labels("http_req_rtt_sum"), 0.85, ms},
+		{labels("http_req_inflight"), 3, ms},
+	}
+
+	found, err := decodeWriteRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("time series mismatch")
+		t.Logf("expected: %v", expect)
+		t.Logf("found:    %v", found)
+	}
+}
+
+func TestAppendMeasuresDistributionAndSet(t *testing.T) {
+	buckets := stats.HistogramBuckets{}
+	buckets.Set("job:size", 10)
+
+	s := &serializer{
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	var b []byte
+
+	for _, v := range []int{1, 20, 1} {
+		b = s.AppendMeasures(b, timestamp, stats.Measure{
+			Name: "job",
+			Fields: []stats.Field{
+				stats.MakeField("size", v, stats.Distribution),
+				stats.MakeField("users", v, stats.UniqueSet),
+			},
+		})
+	}
+
+	labels := func(name string, extra ...label) []label {
+		return append([]label{{"__name__", name}}, extra...)
+	}
+
+	ms := timestamp.UnixNano() / 1e6
+
+	expect := []timeSeries{
+		{labels("job_size_bucket", label{"le", "10"}), 1, ms},
+		{labels("job_size_bucket", label{"le", "+Inf"}), 1, ms},
+		{labels("job_size_count"), 1, ms},
+		{labels("job_size_sum"), 1, ms},
+		{labels("job_users"), 1, ms},
+
+		{labels("job_size_bucket", label{"le", "10"}), 1, ms},
+		{labels("job_size_bucket", label{"le", "+Inf"}), 2, ms},
+		{labels("job_size_count"), 2, ms},
+		{labels("job_size_sum"), 21, ms},
+		{labels("job_users"), 2, ms},
+
+		{labels("job_size_bucket", label{"le", "10"}), 2, ms},
+		{labels("job_size_bucket", label{"le", "+Inf"}), 3, ms},
+		{labels("job_size_count"), 3, ms},
+		{labels("job_size_sum"), 22, ms},
+		{labels("job_users"), 2, ms},
+	}
+
+	found, err := decodeWriteRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("time series mismatch")
+		t.Logf("expected: %v", expect)
+		t.Logf("found:    %v", found)
+	}
+}
+
+func TestAppendMeasuresSampled(t *testing.T) {
+	buckets := stats.HistogramBuckets{}
+	buckets.Set("job:size", 10)
+
+	s := &serializer{
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	b := s.AppendMeasures(nil, timestamp, stats.Measure{
+		Name: "job",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter).WithRate(0.1),
+			stats.MakeField("size", 2, stats.Histogram).WithRate(0.5),
+		},
+	})
+
+	labels := func(name string, extra ...label) []label {
+		return append([]label{{"__name__", name}}, extra...)
+	}
+
+	ms := timestamp.UnixNano() / 1e6
+
+	expect := []timeSeries{
+		{labels("job_count"), 10, ms},
+		{labels("job_size_bucket", label{"le", "10"}), 2, ms},
+		{labels("job_size_bucket", label{"le", "+Inf"}), 2, ms},
+		{labels("job_size_count"), 2, ms},
+		{labels("job_size_sum"), 4, ms},
+	}
+
+	found, err := decodeWriteRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("time series mismatch")
+		t.Logf("expected: %v", expect)
+		t.Logf("found:    %v", found)
+	}
+}
+
+func TestAppendMeasuresSeriesTimeout(t *testing.T) {
+	s := &serializer{
+		buckets: stats.HistogramBuckets{},
+		series:  make(map[string]*series),
+		timeout: time.Minute,
+	}
+
+	count := func(name string) stats.Measure {
+		return stats.Measure{
+			Name:   name,
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		}
+	}
+
+	s.AppendMeasures(nil, timestamp, count("a"), count("b"))
+	s.AppendMeasures(nil, timestamp.Add(30*time.Second), count("b"))
+	b := s.AppendMeasures(nil, timestamp.Add(70*time.Second), count("a"))
+
+	if len(s.series) != 2 {
+		t.Error("bad number of series:", len(s.series))
+	}
+
+	found, err := decodeWriteRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The series of "a" expired, its counter restarts from zero.
+	if len(found) != 1 || found[0].value != 1 {
+		t.Errorf("bad time series: %+v", found)
+	}
+
+	s.AppendMeasures(nil, timestamp.Add(150*time.Second))
+
+	if len(s.series) != 0 {
+		t.Error("idle series were not removed:", len(s.series))
+	}
+}
+
+func BenchmarkAppendMeasures(b *testing.B) {
+	s := &serializer{
+		buckets: stats.HistogramBuckets{},
+		series:  make(map[string]*series),
+	}
+
+	m := stats.Measure{
+		Name: "benchmark.test.metric",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("value", 42, stats.Gauge),
+		},
+		Tags: []stats.Tag{
+			{"answer", "42"},
+			{"hello", "world"},
+		},
+	}
+
+	buf := make([]byte, 0, 1024)
+
+	for i := 0; i != b.N; i++ {
+		buf = s.AppendMeasures(buf[:0], timestamp, m)
+	}
+}
+
+type timeSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+func decodeWriteRequest(b []byte) ([]timeSeries, error) {
+	var series []timeSeries
+
+	err := protobuf.Decode(b, func(field int, v []byte, u uint64) error {
+		if field != 1 {
+			return fmt.Errorf("unexpected WriteRequest field: %d", field)
+		}
+
+		var ts timeSeries
+
+		if err := protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+			switch field {
+			case 1:
+				var l label
+				return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+					switch field {
+					case 1:
+						l.name = string(v)
+					case 2:
+						l.value = string(v)
+						ts.labels = append(ts.labels, l)
+					}
+					return nil
+				})
+			case 2:
+				return protobuf.Decode(v, func(field int, v []byte, u uint64) error {
+					switch field {
+					case 1:
+						ts.value = math.Float64frombits(u)
+					case 2:
+						ts.timestamp = int64(u)
+					}
+					return nil
+				})
+			default:
+				return fmt.Errorf("unexpected TimeSeries field: %d", field)
+			}
+		}); err != nil {
+			return err
+		}
+
+		series = append(series, ts)
+		return nil
+	})
+
+	return series, err
+}
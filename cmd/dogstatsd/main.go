@@ -26,6 +26,10 @@ func main() {
 	switch cmd, args := args[0], args[1:]; cmd {
 	case "add", "set", "time":
 		client(cmd, args...)
+	case "event":
+		event(args...)
+	case "check":
+		check(args...)
 	case "agent":
 		server(args...)
 	default:
@@ -39,6 +43,8 @@ func usage() {
 commands:
  - add
  - agent
+ - check
+ - event
  - help
  - set
  - time
@@ -105,17 +111,113 @@ func client(cmd string, args ...string) {
 	}
 }
 
+func event(args ...string) {
+	var fset = flag.NewFlagSet("dogstatsd event [options...] title [text]", flag.ExitOnError)
+	var e datadog.Event
+	var tags tags
+	var addr string
+	var priority string
+	var alertType string
+
+	fset.StringVar(&addr, "addr", "localhost:8125", "The network address where a dogstatsd server is listening for incoming UDP datagrams")
+	fset.Var(&tags, "tags", "A comma-separated list of tags to set on the event")
+	fset.StringVar(&e.Hostname, "host", "", "The host name that the event is about")
+	fset.StringVar(&e.AggregationKey, "aggregation-key", "", "The key used to group the event with others")
+	fset.StringVar(&e.SourceTypeName, "source-type", "", "The name of the source of the event")
+	fset.StringVar(&priority, "priority", "", "The priority of the event (normal or low)")
+	fset.StringVar(&alertType, "alert-type", "", "The alert type of the event (error, warning, info or success)")
+	fset.Parse(args)
+	args = fset.Args()
+
+	if len(args) == 0 {
+		errorf("missing event title")
+	}
+
+	e.Title, args = args[0], args[1:]
+	e.Text = strings.Join(args, " ")
+	e.Timestamp = time.Now()
+	e.Priority = datadog.EventPriority(priority)
+	e.AlertType = datadog.EventAlertType(alertType)
+	e.Tags = tags
+
+	dd := datadog.NewClient(addr)
+	defer dd.Close()
+
+	if err := dd.SendEvent(e); err != nil {
+		errorf("%s", err)
+	}
+}
+
+func check(args ...string) {
+	var fset = flag.NewFlagSet("dogstatsd check [options...] name status [message]", flag.ExitOnError)
+	var sc datadog.ServiceCheck
+	var tags tags
+	var addr string
+
+	fset.StringVar(&addr, "addr", "localhost:8125", "The network address where a dogstatsd server is listening for incoming UDP datagrams")
+	fset.Var(&tags, "tags", "A comma-separated list of tags to set on the service check")
+	fset.StringVar(&sc.Hostname, "host", "", "The host name that the service check is about")
+	fset.Parse(args)
+	args = fset.Args()
+
+	if len(args) == 0 {
+		errorf("missing service check name")
+	}
+
+	sc.Name, args = args[0], args[1:]
+
+	if len(args) == 0 {
+		errorf("missing service check status")
+	}
+
+	switch status := args[0]; status {
+	case "0", "ok":
+		sc.Status = datadog.ServiceCheckOK
+	case "1", "warning":
+		sc.Status = datadog.ServiceCheckWarning
+	case "2", "critical":
+		sc.Status = datadog.ServiceCheckCritical
+	case "3", "unknown":
+		sc.Status = datadog.ServiceCheckUnknown
+	default:
+		errorf("bad service check status: %s", status)
+	}
+
+	sc.Message = strings.Join(args[1:], " ")
+	sc.Timestamp = time.Now()
+	sc.Tags = tags
+
+	dd := datadog.NewClient(addr)
+	defer dd.Close()
+
+	if err := dd.SendServiceCheck(sc); err != nil {
+		errorf("%s", err)
+	}
+}
+
 func server(args ...string) {
 	var fset = flag.NewFlagSet("dogstatsd agent [options...]", flag.ExitOnError)
 	var bind string
 
-	fset.StringVar(&bind, "bind", ":8125", "The network address to listen on for incoming UDP datagrams")
+	fset.StringVar(&bind, "bind", ":8125", "The network address to listen on for incoming UDP datagrams, prefix with unixgram:// to listen on a unix socket")
 	fset.Parse(args)
-	log.Printf("listening for incoming UDP datagram on %s", bind)
+	log.Printf("listening for incoming datagrams on %s", bind)
+
+	datadog.ListenAndServe(bind, agent{})
+}
+
+type agent struct{}
+
+func (agent) HandleMetric(metric datadog.Metric, from net.Addr) {
+	log.Print(metric)
+}
+
+func (agent) HandleEvent(event datadog.Event, from net.Addr) {
+	log.Print(event)
+}
 
-	datadog.ListenAndServe(bind, datadog.HandlerFunc(func(metric datadog.Metric, from net.Addr) {
-		log.Print(metric)
-	}))
+func (agent) HandleServiceCheck(check datadog.ServiceCheck, from net.Addr) {
+	log.Print(check)
 }
 
 func run(args ...string) {
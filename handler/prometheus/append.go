@@ -5,6 +5,27 @@ import (
 	"strings"
 )
 
+// AppendMetricName appends s to b, replacing the bytes that are not valid in a
+// prometheus metric name with underscores.
+//
+// The function is exposed so other packages producing prometheus metrics (like
+// the remote-write client) generate the same names as the Handler.
+func AppendMetricName(b []byte, s string) []byte {
+	return appendMetricName(b, s)
+}
+
+// AppendMetricScopedName appends the prometheus metric name made of scope and
+// name to b, the way the Handler does for the fields of measures.
+func AppendMetricScopedName(b []byte, scope string, name string) []byte {
+	return appendMetricScopedName(b, scope, name)
+}
+
+// AppendLabelName appends s to b, replacing the bytes that are not valid in a
+// prometheus label name with underscores.
+func AppendLabelName(b []byte, s string) []byte {
+	return appendLabelName(b, s)
+}
+
 func appendMetricName(b []byte, s string) []byte {
 	i := len(b)
 	b = append(b, s...)
@@ -0,0 +1,137 @@
+package prometheus
+
+import (
+	"sort"
+	"time"
+)
+
+// metricFamily is a representation of the metrics of the store which groups
+// samples by series, it is used by the exposition formats which, unlike the
+// text format, need to know about all the samples of a series at once.
+type metricFamily struct {
+	mtype  metricType
+	scope  string
+	name   string
+	help   string
+	unit   string
+	series []metricSeries
+}
+
+type metricSeries struct {
+	labels   labels
+	value    float64 // counters, gauges and untyped metrics
+	sum      float64 // histograms and summaries
+	count    float64 // histograms and summaries
+	points   []metricPoint
+	time     time.Time
+	created  time.Time
+	exemplar exemplar
+}
+
+// metricPoint represents either a bucket of a histogram or a quantile of a
+// summary.
+type metricPoint struct {
+	bound    float64 // upper bound of a bucket or quantile
+	value    float64 // cumulative count of a bucket or value of a quantile
+	exemplar exemplar
+}
+
+func (store *metricStore) collectFamilies(families []metricFamily) []metricFamily {
+	store.mutex.RLock()
+
+	for _, entry := range store.entries {
+		families = append(families, entry.collectFamily())
+	}
+
+	store.mutex.RUnlock()
+
+	sort.Sort(familiesByName(families))
+	return families
+}
+
+func (entry *metricEntry) collectFamily() metricFamily {
+	family := metricFamily{
+		mtype: entry.mtype,
+		scope: entry.scope,
+		name:  entry.name,
+		help:  entry.help,
+		unit:  entry.unit,
+	}
+
+	entry.mutex.RLock()
+
+	for _, states := range entry.states {
+		for _, state := range states {
+			family.series = append(family.series, state.collectSeries(entry.mtype))
+		}
+	}
+
+	entry.mutex.RUnlock()
+
+	sort.Sort(seriesByLabels(family.series))
+	return family
+}
+
+func (state *metricState) collectSeries(mtype metricType) metricSeries {
+	state.mutex.Lock()
+
+	series := metricSeries{
+		labels:   state.labels,
+		value:    state.value,
+		sum:      state.sum,
+		count:    state.count,
+		time:     state.time,
+		created:  state.created,
+		exemplar: state.exemplar.copy(),
+	}
+
+	switch mtype {
+	case histogram:
+		var cumulativeCount float64
+		series.points = make([]metricPoint, len(state.buckets))
+
+		for i, bucket := range state.buckets {
+			cumulativeCount += bucket.count
+			series.points[i] = metricPoint{
+				bound:    bucket.limit,
+				value:    cumulativeCount,
+				exemplar: bucket.exemplar.copy(),
+			}
+		}
+
+	case summary:
+		sketch := state.quantileSketch()
+		series.points = make([]metricPoint, len(state.quantiles))
+
+		for i, quantile := range state.quantiles {
+			series.points[i] = metricPoint{
+				bound: quantile.value,
+				value: sketch.Quantile(quantile.value),
+			}
+		}
+	}
+
+	state.mutex.Unlock()
+	return series
+}
+
+func (e exemplar) copy() exemplar {
+	if len(e.labels) != 0 {
+		e.labels = e.labels.copy()
+	}
+	return e
+}
+
+type familiesByName []metricFamily
+
+func (f familiesByName) Len() int          { return len(f) }
+func (f familiesByName) Swap(i int, j int) { f[i], f[j] = f[j], f[i] }
+func (f familiesByName) Less(i int, j int) bool {
+	return f[i].scope < f[j].scope || (f[i].scope == f[j].scope && f[i].name < f[j].name)
+}
+
+type seriesByLabels []metricSeries
+
+func (s seriesByLabels) Len() int               { return len(s) }
+func (s seriesByLabels) Swap(i int, j int)      { s[i], s[j] = s[j], s[i] }
+func (s seriesByLabels) Less(i int, j int) bool { return s[i].labels.less(s[j].labels) }
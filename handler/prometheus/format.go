@@ -0,0 +1,90 @@
+package prometheus
+
+import (
+	"strconv"
+	"strings"
+)
+
+// format is an enumeration of the exposition formats supported by the handler.
+type format int
+
+const (
+	textFormat format = iota
+	openMetricsFormat
+	protobufFormat
+)
+
+func (f format) contentType() string {
+	switch f {
+	case openMetricsFormat:
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	case protobufFormat:
+		return "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	default:
+		return "text/plain; version=0.0.4"
+	}
+}
+
+// negotiateFormat returns the exposition format that best matches the Accept
+// header of a scrape request. The text format is used when the header is empty
+// or doesn't list any of the supported formats.
+//
+// When multiple formats have the same quality factor, the one that appears
+// first in the header is selected. OpenMetrics is only selected when the media
+// range doesn't specify a version, or asks for version 1.0.0 which is the one
+// produced by the handler.
+func negotiateFormat(accept string) format {
+	selected, quality := textFormat, 0.0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		f, q, ok := parseMediaRange(mediaRange)
+
+		if ok && q > quality {
+			selected, quality = f, q
+		}
+	}
+
+	return selected
+}
+
+func parseMediaRange(s string) (f format, q float64, ok bool) {
+	params := strings.Split(s, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	q = 1.0
+
+	var proto, encoding, version string
+
+	for _, param := range params[1:] {
+		name, value := strings.TrimSpace(param), ""
+
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], strings.Trim(name[i+1:], `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "q":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return
+			}
+			q = v
+		case "proto":
+			proto = value
+		case "encoding":
+			encoding = value
+		case "version":
+			version = value
+		}
+	}
+
+	switch mediaType {
+	case "application/openmetrics-text":
+		f, ok = openMetricsFormat, version == "" || version == "1.0.0"
+	case "application/vnd.google.protobuf":
+		f, ok = protobufFormat, proto == "io.prometheus.client.MetricFamily" && encoding == "delimited"
+	case "text/plain", "text/*", "*/*":
+		f, ok = textFormat, true
+	}
+
+	return
+}
@@ -0,0 +1,63 @@
+package prometheus
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		format format
+	}{
+		{
+			accept: "",
+			format: textFormat,
+		},
+		{
+			accept: "text/plain; version=0.0.4",
+			format: textFormat,
+		},
+		{
+			accept: "application/json",
+			format: textFormat,
+		},
+		{
+			accept: "application/openmetrics-text; version=1.0.0",
+			format: openMetricsFormat,
+		},
+		{
+			accept: "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			format: openMetricsFormat,
+		},
+		{
+			accept: "application/openmetrics-text",
+			format: openMetricsFormat,
+		},
+		{
+			accept: "application/openmetrics-text; version=0.0.1",
+			format: textFormat,
+		},
+		{
+			accept: "application/openmetrics-text;version=2.0.0,application/openmetrics-text;version=1.0.0;q=0.5,text/plain;q=0.3",
+			format: openMetricsFormat,
+		},
+		{
+			accept: "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3",
+			format: protobufFormat,
+		},
+		{
+			accept: "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=text",
+			format: textFormat,
+		},
+		{
+			accept: "text/plain;q=0.9,application/openmetrics-text;q=0.5",
+			format: textFormat,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			if f := negotiateFormat(test.accept); f != test.format {
+				t.Error("bad format:", f)
+			}
+		})
+	}
+}
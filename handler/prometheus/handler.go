@@ -19,7 +19,20 @@ import (
 // Typically, a program creates one Handler, registers it to the stats package,
 // and adds it to the muxer used by the application under the /metrics path.
 //
-// The handle ignores histograms that have no buckets set.
+// Histograms that have buckets set are exposed as prometheus histograms, the
+// others are exposed as summaries reporting the quantiles configured on the
+// handler, which are estimated with a stats.Sketch. Prometheus has no concept
+// of distributions aggregated across hosts, so distributions are exposed like
+// histograms.
+//
+// Sampled counters and histograms are scaled by the inverse of their sample
+// rate, so the exposed totals estimate the values produced by the program.
+//
+// Sets are exposed as gauges reporting the number of distinct values that the
+// series received since it was created, estimated with a stats.HyperLogLog.
+// Since prometheus scrapes the current state of the metrics, the count is not
+// reset when the metrics are collected, it is only discarded when the series
+// expires after MetricTimeout.
 type Handler struct {
 	// Setting this field will trim this prefix from metric namespaces of the
 	// metrics received by this handler.
@@ -45,6 +58,27 @@ type Handler struct {
 	// If nil, stats.Buckets is used instead.
 	Buckets stats.HistogramBuckets
 
+	// Quantiles is the list of quantiles exposed for histograms that have no
+	// buckets set, values must be between 0 and 1.
+	// If nil, DefaultQuantiles is used instead.
+	Quantiles []stats.Value
+
+	// QuantileMaxAge is the maximum age of the values that the quantiles of
+	// summaries are estimated from, so they reflect the recent behavior of
+	// the program. The sum and count of summaries are not affected.
+	//
+	// If zero, DefaultQuantileMaxAge is used, a negative value makes the
+	// quantiles cover all the values observed since the series was created.
+	QuantileMaxAge time.Duration
+
+	// ExemplarTags is a list of tag names that are exposed as exemplars of the
+	// counters and histogram buckets instead of labels, for example a tag
+	// carrying the identifier of the trace where the measure was taken.
+	//
+	// Exemplars are only part of the OpenMetrics and protobuf formats, they
+	// are not exposed by the text format.
+	ExemplarTags []string
+
 	opcount uint64
 	metrics metricStore
 }
@@ -57,7 +91,19 @@ func (h *Handler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
 		scope := h.trimPrefix(m.Name)
 
 		cache.labels = cache.labels[:0]
-		cache.labels = cache.labels.appendTags(m.Tags...)
+		cache.exemplar = cache.exemplar[:0]
+
+		if len(h.ExemplarTags) == 0 {
+			cache.labels = cache.labels.appendTags(m.Tags...)
+		} else {
+			for _, t := range m.Tags {
+				if h.isExemplarTag(t.Name) {
+					cache.exemplar = cache.exemplar.appendTags(t)
+				} else {
+					cache.labels = cache.labels.appendTags(t)
+				}
+			}
+		}
 
 		for _, f := range m.Fields {
 			var buckets []stats.Value
@@ -71,21 +117,45 @@ func (h *Handler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
 				} else {
 					buckets = stats.Buckets[k]
 				}
+
+				if len(buckets) == 0 {
+					// Histograms with no buckets are exposed as summaries, the
+					// quantiles take the place of the buckets.
+					mtype, buckets = summary, h.quantiles()
+				}
+			}
+
+			var unit = unitOf(f.Value)
+			var unique = f.Type() == stats.UniqueSet
+
+			if unique {
+				// Sets count values, the unit of the values doesn't apply.
+				unit = ""
 			}
 
 			h.metrics.update(metric{
-				mtype:  mtype,
-				scope:  scope,
-				name:   f.Name,
-				value:  valueOf(f.Value),
-				time:   mtime,
-				labels: cache.labels,
+				mtype:    mtype,
+				scope:    scope,
+				name:     f.Name,
+				unit:     unit,
+				value:    valueOf(f.Value),
+				time:     mtime,
+				labels:   cache.labels,
+				exemplar: cache.exemplar,
+				unique:   unique,
+				member:   f.Value,
+				rate:     f.Rate(),
+				window:   h.quantileMaxAge(),
 			}, buckets)
 		}
 
 		for i := range cache.labels {
 			cache.labels[i] = label{}
 		}
+
+		for i := range cache.exemplar {
+			cache.exemplar[i] = label{}
+		}
 	}
 
 	handleMetricPool.Put(cache)
@@ -106,6 +176,29 @@ func (h *Handler) trimPrefix(s string) string {
 	return s
 }
 
+func (h *Handler) isExemplarTag(name string) bool {
+	for _, tag := range h.ExemplarTags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) quantiles() []stats.Value {
+	if q := h.Quantiles; q != nil {
+		return q
+	}
+	return DefaultQuantiles
+}
+
+func (h *Handler) quantileMaxAge() time.Duration {
+	if maxAge := h.QuantileMaxAge; maxAge != 0 {
+		return maxAge
+	}
+	return DefaultQuantileMaxAge
+}
+
 func (h *Handler) timeout() time.Duration {
 	if timeout := h.MetricTimeout; timeout != 0 {
 		return timeout
@@ -122,11 +215,10 @@ func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	metrics := h.metrics.collect(make([]metric, 0, 10000))
-	sort.Sort(byNameAndLabels(metrics))
+	format := negotiateFormat(req.Header.Get("Accept"))
 
 	w := io.Writer(res)
-	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	res.Header().Set("Content-Type", format.contentType())
 
 	if acceptEncoding(req.Header.Get("Accept-Encoding"), "gzip") {
 		res.Header().Set("Content-Encoding", "gzip")
@@ -135,6 +227,20 @@ func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		w = zw
 	}
 
+	switch format {
+	case openMetricsFormat:
+		h.writeOpenMetrics(w)
+	case protobufFormat:
+		h.writeProtobuf(w)
+	default:
+		h.writeText(w)
+	}
+}
+
+func (h *Handler) writeText(w io.Writer) {
+	metrics := h.metrics.collect(make([]metric, 0, 10000))
+	sort.Sort(byNameAndLabels(metrics))
+
 	b := make([]byte, 1024)
 
 	var lastMetricName string
@@ -157,6 +263,28 @@ func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (h *Handler) writeOpenMetrics(w io.Writer) {
+	families := h.metrics.collectFamilies(make([]metricFamily, 0, 100))
+	b := make([]byte, 0, 4096)
+
+	for i := range families {
+		b = appendOpenMetricsFamily(b[:0], &families[i])
+		w.Write(b)
+	}
+
+	w.Write(appendOpenMetricsEOF(b[:0]))
+}
+
+func (h *Handler) writeProtobuf(w io.Writer) {
+	families := h.metrics.collectFamilies(make([]metricFamily, 0, 100))
+	b := make([]byte, 0, 4096)
+
+	for i := range families {
+		b = appendProtoFamily(b[:0], &families[i])
+		w.Write(b)
+	}
+}
+
 func acceptEncoding(accept string, check string) bool {
 	for _, coding := range strings.Split(accept, ",") {
 		if coding = strings.TrimSpace(coding); strings.HasPrefix(coding, check) {
@@ -167,7 +295,8 @@ func acceptEncoding(accept string, check string) bool {
 }
 
 type handleMetricCache struct {
-	labels labels
+	labels   labels
+	exemplar labels
 }
 
 var handleMetricPool = sync.Pool{
@@ -188,6 +317,18 @@ func (cache *handleMetricCache) Less(i int, j int) bool {
 	return cache.labels[i].less(cache.labels[j])
 }
 
+// DefaultQuantileMaxAge is the maximum age of the values that the quantiles of
+// summaries are estimated from, when handlers are not configured with one.
+const DefaultQuantileMaxAge = 10 * time.Minute
+
+// DefaultQuantiles is the list of quantiles exposed by handlers for histograms
+// that have no buckets set.
+var DefaultQuantiles = []stats.Value{
+	stats.ValueOf(0.5),
+	stats.ValueOf(0.9),
+	stats.ValueOf(0.99),
+}
+
 // DefaultHandler is a prometheus handler configured to trim the default metric
 // namespace off of metrics that it handles.
 var DefaultHandler = &Handler{
@@ -200,13 +341,23 @@ func typeOf(t stats.FieldType) metricType {
 		return counter
 	case stats.Gauge:
 		return gauge
-	case stats.Histogram:
+	case stats.Histogram, stats.Distribution:
 		return histogram
+	case stats.UniqueSet:
+		return gauge
 	default:
 		return untyped
 	}
 }
 
+func unitOf(v stats.Value) string {
+	if v.Type() == stats.Duration {
+		// Durations are always exposed in seconds, see valueOf.
+		return "seconds"
+	}
+	return ""
+}
+
 func valueOf(v stats.Value) float64 {
 	switch v.Type() {
 	case stats.Bool:
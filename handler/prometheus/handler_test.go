@@ -3,8 +3,11 @@ package prometheus
 import (
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -118,6 +121,178 @@ C_sum 10.7 1496614320000
 	}
 }
 
+func TestServeHTTPSummary(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{},
+		Quantiles: []stats.Value{
+			stats.ValueOf(0.5),
+			stats.ValueOf(0.99),
+		},
+	}
+
+	for i := 1; i <= 100; i++ {
+		handler.HandleMeasures(now, stats.Measure{
+			Fields: []stats.Field{stats.MakeField("D", i, stats.Histogram)},
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	// Quantiles are estimations, the values are checked within the relative
+	// accuracy of the sketch.
+	expects := []string{
+		`# TYPE D summary`,
+		`D{quantile="0.5"} 50 1496614320000`,
+		`D{quantile="0.99"} 99 1496614320000`,
+		`D_count 100 1496614320000`,
+		`D_sum 5050 1496614320000`,
+	}
+
+	found := strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+
+	if len(found) != len(expects) {
+		t.Fatal("bad output:", res.Body.String())
+	}
+
+	for i := range expects {
+		e := strings.Fields(expects[i])
+		f := strings.Fields(found[i])
+
+		if len(e) != len(f) || e[0] != f[0] || e[len(e)-1] != f[len(f)-1] {
+			t.Errorf("bad line: expected %q, found %q", expects[i], found[i])
+			continue
+		}
+
+		if len(e) == 3 {
+			v1, _ := strconv.ParseFloat(e[1], 64)
+			v2, _ := strconv.ParseFloat(f[1], 64)
+
+			if math.Abs(v1-v2) > v1*stats.DefaultSketchAccuracy {
+				t.Errorf("bad value: expected %q, found %q", expects[i], found[i])
+			}
+		}
+	}
+}
+
+func TestServeHTTPSummaryMaxAge(t *testing.T) {
+	now := time.Now()
+
+	handler := &Handler{
+		Buckets:        map[stats.Key][]stats.Value{},
+		Quantiles:      []stats.Value{stats.ValueOf(0.99)},
+		QuantileMaxAge: 50 * time.Millisecond,
+	}
+
+	// The values observed before the quantile window must not be part of the
+	// quantiles anymore, but are still counted.
+	for i := 1; i <= 100; i++ {
+		handler.HandleMeasures(now, stats.Measure{
+			Fields: []stats.Field{stats.MakeField("D", 1000, stats.Histogram)},
+		})
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 1; i <= 100; i++ {
+		handler.HandleMeasures(now, stats.Measure{
+			Fields: []stats.Field{stats.MakeField("D", i, stats.Histogram)},
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	found := strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+
+	if len(found) != 4 {
+		t.Fatal("bad output:", res.Body.String())
+	}
+
+	if v, _ := strconv.ParseFloat(strings.Fields(found[1])[1], 64); math.Abs(v-99) > 99*stats.DefaultSketchAccuracy {
+		t.Error("bad quantile:", found[1])
+	}
+
+	if count := strings.Fields(found[2]); count[0] != "D_count" || count[1] != "200" {
+		t.Error("bad count:", found[2])
+	}
+}
+
+func TestServeHTTPDistributionAndSet(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{},
+	}
+
+	for _, v := range []int{1, 20, 1, 3} {
+		handler.HandleMeasures(now, stats.Measure{
+			Fields: []stats.Field{
+				stats.MakeField("D", v, stats.Distribution),
+				stats.MakeField("S", v, stats.UniqueSet),
+			},
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	// Distributions with no buckets are exposed as summaries, the quantiles
+	// are estimations so only the exact lines are checked.
+	expects := []string{
+		"# TYPE D summary\n",
+		"D_count 4 1496614320000\n",
+		"D_sum 25 1496614320000\n",
+		"# TYPE S gauge\nS 3 1496614320000\n",
+	}
+
+	found := res.Body.String()
+
+	for _, e := range expects {
+		if !strings.Contains(found, e) {
+			t.Errorf("missing %q in output:\n%s", e, found)
+		}
+	}
+}
+
+func TestServeHTTPSampled(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{},
+	}
+
+	handler.HandleMeasures(now, stats.Measure{
+		Fields: []stats.Field{
+			stats.MakeField("A", 1, stats.Counter).WithRate(0.1),
+			stats.MakeField("D", 3, stats.Histogram).WithRate(0.25),
+		},
+	})
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	expects := []string{
+		"# TYPE A counter\nA 10 1496614320000\n",
+		"D_count 4 1496614320000\n",
+		"D_sum 12 1496614320000\n",
+	}
+
+	found := res.Body.String()
+
+	for _, e := range expects {
+		if !strings.Contains(found, e) {
+			t.Errorf("missing %q in output:\n%s", e, found)
+		}
+	}
+}
+
 func BenchmarkHandleMetric(b *testing.B) {
 	now := time.Now()
 
@@ -44,13 +44,20 @@ type metricKey struct {
 }
 
 type metric struct {
-	mtype  metricType
-	scope  string
-	name   string
-	help   string
-	value  float64
-	time   time.Time
-	labels labels
+	mtype    metricType
+	scope    string
+	name     string
+	help     string
+	unit     string
+	value    float64
+	time     time.Time
+	labels   labels
+	exemplar labels
+	unique   bool          // the gauge counts the distinct values of a set
+	member   stats.Value   // the set member, when unique is true
+	rate     float64       // the sample rate of the value, zero if not sampled
+	root     string        // the name of the summary the metric belongs to
+	window   time.Duration // the max age of the values of summary quantiles
 }
 
 func (m metric) key() metricKey {
@@ -58,8 +65,20 @@ func (m metric) key() metricKey {
 }
 
 func (m metric) rootName() string {
-	if m.mtype == histogram {
+	switch m.mtype {
+	case histogram:
 		return m.name[:strings.LastIndexByte(m.name, '_')]
+	case summary:
+		if len(m.root) != 0 {
+			return m.root
+		}
+		// Quantiles are exposed under the root name, only the sum and count
+		// have a suffix.
+		for _, suffix := range [...]string{"_sum", "_count"} {
+			if strings.HasSuffix(m.name, suffix) {
+				return m.name[:len(m.name)-len(suffix)]
+			}
+		}
 	}
 	return m.name
 }
@@ -69,7 +88,7 @@ type metricStore struct {
 	entries map[metricKey]*metricEntry
 }
 
-func (store *metricStore) lookup(mtype metricType, key metricKey, help string) *metricEntry {
+func (store *metricStore) lookup(mtype metricType, key metricKey, help string, unit string) *metricEntry {
 	store.mutex.RLock()
 	entry := store.entries[key]
 	store.mutex.RUnlock()
@@ -85,7 +104,7 @@ func (store *metricStore) lookup(mtype metricType, key metricKey, help string) *
 		}
 
 		if entry = store.entries[key]; entry == nil || entry.mtype != mtype {
-			entry = newMetricEntry(mtype, key.scope, key.name, help)
+			entry = newMetricEntry(mtype, key.scope, key.name, help, unit)
 			store.entries[key] = entry
 		}
 
@@ -96,9 +115,22 @@ func (store *metricStore) lookup(mtype metricType, key metricKey, help string) *
 }
 
 func (store *metricStore) update(metric metric, buckets []stats.Value) {
-	entry := store.lookup(metric.mtype, metric.key(), metric.help)
-	state := entry.lookup(metric.labels)
-	state.update(metric.mtype, metric.value, metric.time, buckets)
+	entry := store.lookup(metric.mtype, metric.key(), metric.help, metric.unit)
+	state := entry.lookup(metric.labels, metric.time)
+
+	if metric.unique {
+		state.updateUnique(metric.member, metric.time)
+	} else {
+		state.update(metric.mtype, metric.value, weightOf(metric.rate), metric.time, buckets, metric.exemplar, metric.window)
+	}
+}
+
+// weightOf returns the number of values that a sampled value represents.
+func weightOf(rate float64) float64 {
+	if rate > 0 && rate < 1 {
+		return 1 / rate
+	}
+	return 1
 }
 
 func (store *metricStore) collect(metrics []metric) []metric {
@@ -136,33 +168,39 @@ type metricEntry struct {
 	scope  string
 	name   string
 	help   string
+	unit   string
 	bucket string
 	sum    string
 	count  string
 	states metricStateMap
 }
 
-func newMetricEntry(mtype metricType, scope string, name string, help string) *metricEntry {
+func newMetricEntry(mtype metricType, scope string, name string, help string, unit string) *metricEntry {
 	entry := &metricEntry{
 		mtype:  mtype,
 		scope:  scope,
 		name:   name,
 		help:   help,
+		unit:   unit,
 		states: make(metricStateMap),
 	}
 
-	if mtype == histogram {
+	switch mtype {
+	case histogram:
 		// Here we cache those metric names to avoid having to recompute them
 		// every time we collect the state of the metrics.
 		entry.bucket = name + "_bucket"
 		entry.sum = name + "_sum"
 		entry.count = name + "_count"
+	case summary:
+		entry.sum = name + "_sum"
+		entry.count = name + "_count"
 	}
 
 	return entry
 }
 
-func (entry *metricEntry) lookup(labels labels) *metricState {
+func (entry *metricEntry) lookup(labels labels, created time.Time) *metricState {
 	key := labels.hash()
 
 	entry.mutex.RLock()
@@ -173,7 +211,7 @@ func (entry *metricEntry) lookup(labels labels) *metricState {
 		entry.mutex.Lock()
 
 		if state = entry.states.find(key, labels); state == nil {
-			state = newMetricState(labels)
+			state = newMetricState(labels, created)
 			entry.states.put(key, state)
 		}
 
@@ -236,28 +274,38 @@ func (entry *metricEntry) cleanup(exp time.Time, empty func()) {
 
 type metricState struct {
 	// immutable
-	labels labels
+	labels  labels
+	created time.Time
 	// mutable
-	mutex   sync.Mutex
-	buckets metricBuckets
-	value   float64
-	sum     float64
-	count   uint64
-	time    time.Time
+	mutex     sync.Mutex
+	buckets   metricBuckets
+	quantiles metricQuantiles
+	sketch    stats.Sketch // values of the current quantile window
+	previous  stats.Sketch // values of the previous quantile window
+	window    time.Duration
+	rotated   time.Time
+	unique    stats.HyperLogLog
+	value     float64
+	sum       float64
+	count     float64
+	time      time.Time
+	exemplar  exemplar
 }
 
-func newMetricState(labels labels) *metricState {
+func newMetricState(labels labels, created time.Time) *metricState {
 	return &metricState{
-		labels: labels.copy(),
+		labels:  labels.copy(),
+		created: created,
 	}
 }
 
-func (state *metricState) update(mtype metricType, value float64, time time.Time, buckets []stats.Value) {
+func (state *metricState) update(mtype metricType, value float64, weight float64, time time.Time, buckets []stats.Value, exemplar labels, window time.Duration) {
 	state.mutex.Lock()
 
 	switch mtype {
 	case counter:
-		state.value += value
+		state.value += value * weight
+		state.exemplar.update(exemplar, value, time)
 
 	case gauge:
 		state.value = value
@@ -266,11 +314,72 @@ func (state *metricState) update(mtype metricType, value float64, time time.Time
 		if len(state.buckets) != len(buckets) {
 			state.buckets = makeMetricBuckets(buckets, state.labels)
 		}
-		state.buckets.update(value)
-		state.sum += value
-		state.count++
+		state.buckets.update(value, weight, time, exemplar)
+		state.sum += value * weight
+		state.count += weight
+
+	case summary:
+		// For summaries the buckets argument carries the list of quantiles.
+		if len(state.quantiles) != len(buckets) {
+			state.quantiles = makeMetricQuantiles(buckets, state.labels)
+		}
+		state.window = window
+		state.rotate()
+		state.sketch.Add(value)
+		state.sum += value * weight
+		state.count += weight
+	}
+
+	state.time = time
+	state.mutex.Unlock()
+}
+
+// rotate discards the values of summaries that are older than the quantile
+// window. The values are kept in two sketches which cover half of the window
+// each, so the quantiles are estimated from the values observed during the
+// last half to full window. The window is based on the time the values were
+// received, not the time of the measures they came from.
+func (state *metricState) rotate() {
+	if state.window <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	if state.rotated.IsZero() {
+		state.rotated = now
+		return
 	}
 
+	if elapsed := now.Sub(state.rotated); elapsed >= state.window/2 {
+		if elapsed >= state.window {
+			state.previous.Reset()
+		} else {
+			state.previous, state.sketch = state.sketch, state.previous
+		}
+		state.sketch.Reset()
+		state.rotated = now
+	}
+}
+
+// quantileSketch returns a sketch of the values of the current quantile window.
+func (state *metricState) quantileSketch() *stats.Sketch {
+	state.rotate()
+
+	if state.previous.Count() == 0 {
+		return &state.sketch
+	}
+
+	s := &stats.Sketch{}
+	s.Merge(&state.previous)
+	s.Merge(&state.sketch)
+	return s
+}
+
+func (state *metricState) updateUnique(member stats.Value, time time.Time) {
+	state.mutex.Lock()
+	state.unique.Add(member)
+	state.value = float64(state.unique.Count())
 	state.time = time
 	state.mutex.Unlock()
 }
@@ -290,11 +399,49 @@ func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric
 			labels: state.labels,
 		})
 
+	case summary:
+		sketch := state.quantileSketch()
+
+		for _, quantile := range state.quantiles {
+			metrics = append(metrics, metric{
+				mtype:  entry.mtype,
+				scope:  entry.scope,
+				name:   entry.name,
+				help:   entry.help,
+				value:  sketch.Quantile(quantile.value),
+				time:   state.time,
+				labels: quantile.labels,
+				root:   entry.name,
+			})
+		}
+		metrics = append(metrics,
+			metric{
+				mtype:  entry.mtype,
+				scope:  entry.scope,
+				name:   entry.sum,
+				help:   entry.help,
+				value:  state.sum,
+				time:   state.time,
+				labels: state.labels,
+				root:   entry.name,
+			},
+			metric{
+				mtype:  entry.mtype,
+				scope:  entry.scope,
+				name:   entry.count,
+				help:   entry.help,
+				value:  state.count,
+				time:   state.time,
+				labels: state.labels,
+				root:   entry.name,
+			},
+		)
+
 	case histogram:
 		// Prometheus' scraper expects for histogram buckets to be cumulative.
 		// [1] https://prometheus.io/docs/practices/histograms/#apdex-score
 		// [2] https://en.wikipedia.org/wiki/Histogram#Cumulative_histogram
-		var cumulativeCount uint64
+		var cumulativeCount float64
 		for _, bucket := range state.buckets {
 			cumulativeCount += bucket.count
 			metrics = append(metrics, metric{
@@ -302,7 +449,7 @@ func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric
 				scope:  entry.scope,
 				name:   entry.bucket,
 				help:   entry.help,
-				value:  float64(cumulativeCount),
+				value:  cumulativeCount,
 				time:   state.time,
 				labels: bucket.labels,
 			})
@@ -322,7 +469,7 @@ func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric
 				scope:  entry.scope,
 				name:   entry.count,
 				help:   entry.help,
-				value:  float64(state.count),
+				value:  state.count,
 				time:   state.time,
 				labels: state.labels,
 			},
@@ -352,9 +499,10 @@ func (m metricStateMap) find(key uint64, labels labels) *metricState {
 }
 
 type metricBucket struct {
-	limit  float64
-	count  uint64
-	labels labels
+	limit    float64
+	count    float64
+	labels   labels
+	exemplar exemplar
 }
 
 type metricBuckets []metricBucket
@@ -373,15 +521,52 @@ func makeMetricBuckets(buckets []stats.Value, labels labels) metricBuckets {
 	return b
 }
 
-func (m metricBuckets) update(value float64) {
+func (m metricBuckets) update(value float64, weight float64, time time.Time, exemplar labels) {
 	for i := range m {
 		if value <= m[i].limit {
-			m[i].count++
+			m[i].count += weight
+			m[i].exemplar.update(exemplar, value, time)
 			break
 		}
 	}
 }
 
+// exemplar represents the last observation of a metric (or histogram bucket)
+// that was made with exemplar labels, typically a trace identifier.
+type exemplar struct {
+	labels labels
+	value  float64
+	time   time.Time
+}
+
+func (e *exemplar) update(labels labels, value float64, time time.Time) {
+	if len(labels) != 0 {
+		// The labels are owned by the caller, they have to be copied (the
+		// slice is reused if it has enough capacity).
+		e.labels = append(e.labels[:0], labels...)
+		e.value = value
+		e.time = time
+	}
+}
+
+type metricQuantile struct {
+	value  float64
+	labels labels
+}
+
+type metricQuantiles []metricQuantile
+
+func makeMetricQuantiles(quantiles []stats.Value, labels labels) metricQuantiles {
+	q := make(metricQuantiles, len(quantiles))
+
+	for i := range quantiles {
+		q[i].value = valueOf(quantiles[i])
+		q[i].labels = labels.copyAppend(label{"quantile", string(appendFloat(nil, q[i].value))})
+	}
+
+	return q
+}
+
 // This function builds a string of column-separated float representations of
 // the given list of buckets, which is then split by calls to nextLe to generate
 // the values of the "le" label for each bucket of a histogram.
@@ -0,0 +1,196 @@
+package prometheus
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func appendOpenMetricsFamily(b []byte, family *metricFamily) []byte {
+	name := family.name
+
+	if family.mtype == counter {
+		// OpenMetrics counter samples always have a "_total" suffix which is
+		// not part of the family name.
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	b = append(b, "# TYPE "...)
+	b = appendMetricScopedName(b, family.scope, name)
+	b = append(b, ' ')
+	b = append(b, openMetricsType(family.mtype)...)
+	b = append(b, '\n')
+
+	if unit := family.unit; len(unit) != 0 && strings.HasSuffix(name, "_"+unit) {
+		// The specification requires the family name to end with the unit,
+		// which may not be the case with names generated by the stats package.
+		b = append(b, "# UNIT "...)
+		b = appendMetricScopedName(b, family.scope, name)
+		b = append(b, ' ')
+		b = append(b, unit...)
+		b = append(b, '\n')
+	}
+
+	if len(family.help) != 0 {
+		b = append(b, "# HELP "...)
+		b = appendMetricScopedName(b, family.scope, name)
+		b = append(b, ' ')
+		b = appendEscapedString(b, family.help, indexOfSpecialLabelValueByte)
+		b = append(b, '\n')
+	}
+
+	for i := range family.series {
+		s := &family.series[i]
+
+		switch family.mtype {
+		case counter:
+			b = appendOpenMetricsSample(b, family.scope, name, "_total", s.labels, label{}, s.value, s.time)
+			b = appendOpenMetricsExemplar(b, s.exemplar)
+			b = append(b, '\n')
+			b = appendOpenMetricsCreated(b, family.scope, name, s)
+
+		case histogram:
+			for _, p := range s.points {
+				b = appendOpenMetricsSample(b, family.scope, name, "_bucket", s.labels, label{"le", formatFloat(p.bound)}, p.value, s.time)
+				b = appendOpenMetricsExemplar(b, p.exemplar)
+				b = append(b, '\n')
+			}
+			b = appendOpenMetricsSample(b, family.scope, name, "_bucket", s.labels, label{"le", "+Inf"}, s.count, s.time)
+			b = append(b, '\n')
+			b = appendOpenMetricsSample(b, family.scope, name, "_count", s.labels, label{}, s.count, s.time)
+			b = append(b, '\n')
+			b = appendOpenMetricsSample(b, family.scope, name, "_sum", s.labels, label{}, s.sum, s.time)
+			b = append(b, '\n')
+			b = appendOpenMetricsCreated(b, family.scope, name, s)
+
+		case summary:
+			for _, p := range s.points {
+				b = appendOpenMetricsSample(b, family.scope, name, "", s.labels, label{"quantile", formatFloat(p.bound)}, p.value, s.time)
+				b = append(b, '\n')
+			}
+			b = appendOpenMetricsSample(b, family.scope, name, "_count", s.labels, label{}, s.count, s.time)
+			b = append(b, '\n')
+			b = appendOpenMetricsSample(b, family.scope, name, "_sum", s.labels, label{}, s.sum, s.time)
+			b = append(b, '\n')
+			b = appendOpenMetricsCreated(b, family.scope, name, s)
+
+		default:
+			b = appendOpenMetricsSample(b, family.scope, name, "", s.labels, label{}, s.value, s.time)
+			b = append(b, '\n')
+		}
+	}
+
+	return b
+}
+
+func appendOpenMetricsEOF(b []byte) []byte {
+	return append(b, "# EOF\n"...)
+}
+
+func appendOpenMetricsSample(b []byte, scope string, name string, suffix string, labels labels, extra label, value float64, time time.Time) []byte {
+	b = appendMetricScopedName(b, scope, name)
+	b = append(b, suffix...)
+	b = appendOpenMetricsLabels(b, labels, extra)
+	b = append(b, ' ')
+	b = appendFloat(b, value)
+
+	if !time.IsZero() {
+		b = append(b, ' ')
+		b = appendOpenMetricsTimestamp(b, time)
+	}
+
+	return b
+}
+
+func appendOpenMetricsCreated(b []byte, scope string, name string, series *metricSeries) []byte {
+	if series.created.IsZero() {
+		return b
+	}
+	b = appendMetricScopedName(b, scope, name)
+	b = append(b, "_created"...)
+	b = appendOpenMetricsLabels(b, series.labels, label{})
+	b = append(b, ' ')
+	b = appendOpenMetricsTimestamp(b, series.created)
+	return append(b, '\n')
+}
+
+func appendOpenMetricsExemplar(b []byte, e exemplar) []byte {
+	if len(e.labels) == 0 {
+		return b
+	}
+	b = append(b, " # "...)
+	b = append(b, '{')
+	for i, l := range e.labels {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = appendLabel(b, l)
+	}
+	b = append(b, '}', ' ')
+	b = appendFloat(b, e.value)
+
+	if !e.time.IsZero() {
+		b = append(b, ' ')
+		b = appendOpenMetricsTimestamp(b, e.time)
+	}
+
+	return b
+}
+
+func appendOpenMetricsLabels(b []byte, labels labels, extra label) []byte {
+	if len(labels) == 0 && len(extra.name) == 0 {
+		return b
+	}
+
+	b = append(b, '{')
+
+	for i, l := range labels {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = appendLabel(b, l)
+	}
+
+	if len(extra.name) != 0 {
+		if len(labels) != 0 {
+			b = append(b, ',')
+		}
+		b = appendLabel(b, extra)
+	}
+
+	return append(b, '}')
+}
+
+func appendOpenMetricsTimestamp(b []byte, t time.Time) []byte {
+	// OpenMetrics timestamps are expressed in seconds, we keep the millisecond
+	// precision of the text format.
+	ms := t.UnixNano() / 1e6
+	b = strconv.AppendInt(b, ms/1000, 10)
+	b = append(b, '.')
+
+	if ms %= 1000; ms < 0 {
+		ms = -ms
+	}
+
+	switch {
+	case ms < 10:
+		b = append(b, '0', '0')
+	case ms < 100:
+		b = append(b, '0')
+	}
+
+	return strconv.AppendInt(b, ms, 10)
+}
+
+func openMetricsType(t metricType) string {
+	switch t {
+	case counter, gauge, histogram, summary:
+		return t.String()
+	default:
+		return "unknown"
+	}
+}
+
+func formatFloat(f float64) string {
+	return string(appendFloat(nil, f))
+}
@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+)
+
+func TestServeOpenMetrics(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{
+			stats.Key{Field: "C_seconds"}: []stats.Value{
+				stats.ValueOf(0.25),
+				stats.ValueOf(1.0),
+			},
+		},
+		ExemplarTags: []string{"trace_id"},
+	}
+
+	handler.HandleMeasures(now,
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)},
+			Tags:   []stats.Tag{{Name: "trace_id", Value: "abc"}},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("A", 2, stats.Counter)},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("B", 42, stats.Gauge)},
+			Tags:   []stats.Tag{{Name: "a", Value: "1"}},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("C_seconds", 100*time.Millisecond, stats.Histogram)},
+			Tags:   []stats.Tag{{Name: "trace_id", Value: "def"}},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("C_seconds", 2*time.Second, stats.Histogram)},
+		},
+	)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if ct := res.Header().Get("Content-Type"); ct != openMetricsFormat.contentType() {
+		t.Error("bad content type:", ct)
+	}
+
+	const expects = `# TYPE A counter
+A_total 3 1496614320.000 # {trace_id="abc"} 1 1496614320.000
+A_created 1496614320.000
+# TYPE B gauge
+B{a="1"} 42 1496614320.000
+# TYPE C_seconds histogram
+# UNIT C_seconds seconds
+C_seconds_bucket{le="0.25"} 1 1496614320.000 # {trace_id="def"} 0.1 1496614320.000
+C_seconds_bucket{le="1"} 1 1496614320.000
+C_seconds_bucket{le="+Inf"} 2 1496614320.000
+C_seconds_count 2 1496614320.000
+C_seconds_sum 2.1 1496614320.000
+C_seconds_created 1496614320.000
+# EOF
+`
+
+	if s := res.Body.String(); s != expects {
+		t.Error("bad output:")
+		t.Log("expected:", expects)
+		t.Log("found:", s)
+	}
+}
+
+func TestAppendOpenMetricsTimestamp(t *testing.T) {
+	tests := []struct {
+		time   time.Time
+		string string
+	}{
+		{time.Unix(1496614320, 0), "1496614320.000"},
+		{time.Unix(1496614320, 5e6), "1496614320.005"},
+		{time.Unix(1496614320, 50e6), "1496614320.050"},
+		{time.Unix(1496614320, 500e6), "1496614320.500"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.string, func(t *testing.T) {
+			if s := string(appendOpenMetricsTimestamp(nil, test.time)); s != test.string {
+				t.Error("bad timestamp:", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+// This file contains a minimal encoder for the io.prometheus.client protobuf
+// messages, which avoids depending on a protobuf runtime for the few message
+// types that the handler needs to produce.
+//
+// https://github.com/prometheus/client_model/blob/master/io/prometheus/client/metrics.proto
+
+// Values of the io.prometheus.client.MetricType enum.
+const (
+	protoCounter   = 0
+	protoGauge     = 1
+	protoSummary   = 2
+	protoUntyped   = 3
+	protoHistogram = 4
+)
+
+// appendProtoFamily appends the length-delimited protobuf representation of a
+// io.prometheus.client.MetricFamily message to b.
+func appendProtoFamily(b []byte, family *metricFamily) []byte {
+	m := make([]byte, 0, 256)
+	// Unlike OpenMetrics, the protobuf format uses the same metric names as the
+	// text format, counters are not stripped of their "_total" suffix.
+	m = protobuf.AppendString(m, 1, string(appendMetricScopedName(nil, family.scope, family.name)))
+
+	if len(family.help) != 0 {
+		m = protobuf.AppendString(m, 2, family.help)
+	}
+
+	m = protobuf.AppendVarint(m, 3, uint64(protoType(family.mtype)))
+
+	for i := range family.series {
+		m = protobuf.AppendBytes(m, 4, appendProtoMetric(nil, family.mtype, &family.series[i]))
+	}
+
+	b = protobuf.AppendUvarint(b, uint64(len(m)))
+	return append(b, m...)
+}
+
+func appendProtoMetric(b []byte, mtype metricType, series *metricSeries) []byte {
+	for _, l := range series.labels {
+		b = protobuf.AppendBytes(b, 1, appendProtoLabel(nil, l))
+	}
+
+	switch mtype {
+	case counter:
+		c := protobuf.AppendDouble(nil, 1, series.value)
+		if len(series.exemplar.labels) != 0 {
+			c = protobuf.AppendBytes(c, 2, appendProtoExemplar(nil, series.exemplar))
+		}
+		if !series.created.IsZero() {
+			c = protobuf.AppendBytes(c, 3, appendProtoTimestamp(nil, series.created))
+		}
+		b = protobuf.AppendBytes(b, 3, c)
+
+	case gauge:
+		b = protobuf.AppendBytes(b, 2, protobuf.AppendDouble(nil, 1, series.value))
+
+	case summary:
+		s := protobuf.AppendVarint(nil, 1, protoCount(series.count))
+		s = protobuf.AppendDouble(s, 2, series.sum)
+		for _, p := range series.points {
+			q := protobuf.AppendDouble(nil, 1, p.bound)
+			q = protobuf.AppendDouble(q, 2, p.value)
+			s = protobuf.AppendBytes(s, 3, q)
+		}
+		if !series.created.IsZero() {
+			s = protobuf.AppendBytes(s, 4, appendProtoTimestamp(nil, series.created))
+		}
+		b = protobuf.AppendBytes(b, 4, s)
+
+	case histogram:
+		h := protobuf.AppendVarint(nil, 1, protoCount(series.count))
+		h = protobuf.AppendDouble(h, 2, series.sum)
+		for _, p := range series.points {
+			k := protobuf.AppendVarint(nil, 1, protoCount(p.value))
+			k = protobuf.AppendDouble(k, 2, p.bound)
+			if len(p.exemplar.labels) != 0 {
+				k = protobuf.AppendBytes(k, 3, appendProtoExemplar(nil, p.exemplar))
+			}
+			h = protobuf.AppendBytes(h, 3, k)
+		}
+		if !series.created.IsZero() {
+			h = protobuf.AppendBytes(h, 15, appendProtoTimestamp(nil, series.created))
+		}
+		b = protobuf.AppendBytes(b, 7, h)
+
+	default:
+		b = protobuf.AppendBytes(b, 5, protobuf.AppendDouble(nil, 1, series.value))
+	}
+
+	if !series.time.IsZero() {
+		b = protobuf.AppendVarint(b, 6, uint64(series.time.UnixNano()/1e6))
+	}
+
+	return b
+}
+
+func appendProtoLabel(b []byte, l label) []byte {
+	b = protobuf.AppendString(b, 1, string(appendLabelName(nil, l.name)))
+	b = protobuf.AppendString(b, 2, l.value)
+	return b
+}
+
+func appendProtoExemplar(b []byte, e exemplar) []byte {
+	for _, l := range e.labels {
+		b = protobuf.AppendBytes(b, 1, appendProtoLabel(nil, l))
+	}
+	b = protobuf.AppendDouble(b, 2, e.value)
+	if !e.time.IsZero() {
+		b = protobuf.AppendBytes(b, 3, appendProtoTimestamp(nil, e.time))
+	}
+	return b
+}
+
+func appendProtoTimestamp(b []byte, t time.Time) []byte {
+	b = protobuf.AppendVarint(b, 1, uint64(t.Unix()))
+	b = protobuf.AppendVarint(b, 2, uint64(t.Nanosecond()))
+	return b
+}
+
+func protoType(t metricType) int {
+	switch t {
+	case counter:
+		return protoCounter
+	case gauge:
+		return protoGauge
+	case summary:
+		return protoSummary
+	case histogram:
+		return protoHistogram
+	default:
+		return protoUntyped
+	}
+}
+
+// protoCount converts a count to the integer representation used by the
+// protobuf format, counts of sampled values may not be whole numbers.
+func protoCount(count float64) uint64 {
+	return uint64(count + 0.5)
+}
@@ -0,0 +1,197 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/internal/protobuf"
+)
+
+func TestServeProtobuf(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+	handler := &Handler{}
+
+	handler.HandleMeasures(now,
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)},
+			Tags:   []stats.Tag{{Name: "a", Value: "1"}},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("B", 42, stats.Gauge)},
+		},
+	)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if ct := res.Header().Get("Content-Type"); ct != protobufFormat.contentType() {
+		t.Error("bad content type:", ct)
+	}
+
+	b := res.Body.Bytes()
+
+	expects := []struct {
+		name  string
+		mtype uint64
+		value float64
+		label string
+	}{
+		{name: "A", mtype: protoCounter, value: 1, label: "a"},
+		{name: "B", mtype: protoGauge, value: 42},
+	}
+
+	for _, e := range expects {
+		var family []byte
+
+		if family, b = readProtoDelimited(t, b); family == nil {
+			t.Fatal("missing metric family:", e.name)
+		}
+
+		fields := readProtoFields(t, family)
+
+		if name := string(fields[1][0]); name != e.name {
+			t.Error("bad family name:", name)
+		}
+
+		if mtype, _ := binary.Uvarint(fields[3][0]); mtype != e.mtype {
+			t.Error("bad family type:", mtype)
+		}
+
+		metric := readProtoFields(t, fields[4][0])
+
+		if e.label != "" {
+			label := readProtoFields(t, metric[1][0])
+
+			if name := string(label[1][0]); name != e.label {
+				t.Error("bad label name:", name)
+			}
+		}
+
+		var value []byte
+
+		switch e.mtype {
+		case protoCounter:
+			value = readProtoFields(t, metric[3][0])[1][0]
+		case protoGauge:
+			value = readProtoFields(t, metric[2][0])[1][0]
+		}
+
+		if v := math.Float64frombits(binary.LittleEndian.Uint64(value)); v != e.value {
+			t.Error("bad value:", v)
+		}
+
+		if ts, _ := binary.Uvarint(metric[6][0]); ts != 1496614320000 {
+			t.Error("bad timestamp:", ts)
+		}
+	}
+
+	if len(b) != 0 {
+		t.Error("unexpected trailing bytes in the response:", len(b))
+	}
+}
+
+func TestServeTextAndProtobuf(t *testing.T) {
+	now := time.Now()
+	handler := &Handler{Buckets: map[stats.Key][]stats.Value{}}
+
+	handler.HandleMeasures(now,
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("requests_total", 1, stats.Counter)},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("queue_total", 10, stats.Gauge)},
+		},
+		stats.Measure{
+			Fields: []stats.Field{stats.MakeField("latency_count", 0.5, stats.Histogram)},
+		},
+	)
+
+	// Scraping the text format lists the families in its TYPE comments.
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	var text []string
+
+	for _, line := range strings.Split(res.Body.String(), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			text = append(text, strings.Fields(line)[2])
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	var proto []string
+
+	for b := res.Body.Bytes(); len(b) != 0; {
+		var family []byte
+
+		if family, b = readProtoDelimited(t, b); family == nil {
+			t.Fatal("malformed metric family")
+		}
+
+		proto = append(proto, string(readProtoFields(t, family)[1][0]))
+	}
+
+	expects := []string{"latency_count", "queue_total", "requests_total"}
+
+	if !reflect.DeepEqual(text, expects) {
+		t.Error("bad families in the text format:", text)
+	}
+
+	if !reflect.DeepEqual(proto, expects) {
+		t.Error("bad families in the protobuf format:", proto)
+	}
+}
+
+func readProtoDelimited(t *testing.T, b []byte) ([]byte, []byte) {
+	n, i := binary.Uvarint(b)
+	if i <= 0 || uint64(len(b)-i) < n {
+		return nil, b
+	}
+	b = b[i:]
+	return b[:n], b[n:]
+}
+
+// readProtoFields decodes the fields of a protobuf message, varints and
+// fixed64 values are returned in their raw encoding.
+func readProtoFields(t *testing.T, b []byte) map[uint64][][]byte {
+	fields := make(map[uint64][][]byte)
+
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("malformed protobuf key")
+		}
+		b = b[n:]
+
+		var value []byte
+
+		switch key & 7 {
+		case protobuf.Varint:
+			_, n = binary.Uvarint(b)
+			value, b = b[:n], b[n:]
+		case protobuf.Fixed64:
+			value, b = b[:8], b[8:]
+		case protobuf.Bytes:
+			value, b = readProtoDelimited(t, b)
+		default:
+			t.Fatal("unsupported wire type:", key&7)
+		}
+
+		fields[key>>3] = append(fields[key>>3], value)
+	}
+
+	return fields
+}
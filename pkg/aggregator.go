@@ -0,0 +1,451 @@
+package stats
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Aggregator is the implementation of a measure handler which pre-aggregates
+// the measures it receives in memory and forwards a compact summary of them to
+// another handler on every flush.
+//
+// Measures are aggregated per name, field name and tags:
+//
+//   - counters are summed,
+//   - gauges retain the last value they were set to,
+//   - histograms are summarized by a count, sum, min and max, which are
+//     forwarded as fields named after the original field with a ".count",
+//     ".sum", ".min" or ".max" suffix. When Quantiles is set, histograms are
+//     also tracked with a Sketch and each quantile is forwarded as a field
+//     with a ".p<percentile>" suffix (for example ".p99" for 0.99).
+//   - distributions are summarized like histograms,
+//   - sets are tracked with a HyperLogLog and forwarded as a gauge reporting
+//     the estimated number of distinct values seen during the flush interval.
+//
+// Sampled counters and histograms are scaled by the inverse of their sample
+// rate, so the forwarded counts and sums estimate the totals of the program and
+// are not sampled anymore.
+//
+// Once created, series are kept in memory and reused between flushes, which
+// means that aggregating measures does not allocate memory in the steady state.
+// Series that didn't receive any updates during a flush interval are removed.
+//
+// Aggregators are safe to use concurrently from multiple goroutines.
+type Aggregator struct {
+	// The handler that aggregated measures are forwarded to.
+	//
+	// This field cannot be nil.
+	Handler Handler
+
+	// FlushInterval configures how often the aggregator forwards the measures
+	// it has accumulated to its handler.
+	//
+	// If left to zero, measures are only forwarded when Flush is called.
+	FlushInterval time.Duration
+
+	// Quantiles is the list of quantiles reported for histograms, values must
+	// be between 0 and 1.
+	//
+	// Handlers like the InfluxDB client which write each measure as it is can
+	// be placed behind an aggregator configured with quantiles to report
+	// histogram distributions instead of individual observations.
+	Quantiles []float64
+
+	once   sync.Once
+	mutex  sync.Mutex
+	key    []byte
+	series map[string]*aggregate
+
+	flushMutex sync.Mutex
+	measures   []Measure
+
+	stop chan struct{}
+	join sync.WaitGroup
+}
+
+// NewAggregator creates and returns a new aggregator which forwards aggregated
+// measures to handler every interval.
+func NewAggregator(handler Handler, interval time.Duration) *Aggregator {
+	a := &Aggregator{
+		Handler:       handler,
+		FlushInterval: interval,
+	}
+	a.prepare()
+	return a
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (a *Aggregator) HandleMeasures(time time.Time, measures ...Measure) {
+	a.prepare()
+	a.mutex.Lock()
+
+	for i := range measures {
+		m := &measures[i]
+		s := a.lookup(m.Name, m.Tags)
+
+		for _, f := range m.Fields {
+			s.field(f.Name, f.Type(), a.Quantiles).update(f.Value, f.Rate())
+		}
+
+		s.dirty = true
+	}
+
+	a.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+//
+// The method forwards all measures accumulated since the last flush to the
+// aggregator's handler, then flushes it.
+func (a *Aggregator) Flush() {
+	a.prepare()
+	a.flush(time.Now())
+	flush(a.Handler)
+}
+
+// Close stops the background flushes of the aggregator and forwards the
+// measures that it had accumulated, satisfies the io.Closer interface.
+func (a *Aggregator) Close() error {
+	a.prepare()
+
+	if a.stop != nil {
+		close(a.stop)
+		a.join.Wait()
+		a.stop = nil
+	}
+
+	a.Flush()
+	return nil
+}
+
+func (a *Aggregator) prepare() {
+	a.once.Do(func() {
+		a.series = make(map[string]*aggregate)
+
+		if a.FlushInterval > 0 {
+			a.stop = make(chan struct{})
+			a.join.Add(1)
+			go a.run(a.FlushInterval, a.stop)
+		}
+	})
+}
+
+func (a *Aggregator) run(interval time.Duration, stop <-chan struct{}) {
+	defer a.join.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			a.flush(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (a *Aggregator) lookup(name string, tags []Tag) *aggregate {
+	a.key = appendAggregateKey(a.key[:0], name, tags)
+
+	// The compiler optimizes map lookups with a []byte converted to a string
+	// so they don't allocate memory.
+	s := a.series[string(a.key)]
+
+	if s == nil {
+		s = &aggregate{
+			name: name,
+			tags: copyTags(tags),
+		}
+		a.series[string(a.key)] = s
+	}
+
+	return s
+}
+
+func (a *Aggregator) flush(now time.Time) {
+	a.flushMutex.Lock()
+	defer a.flushMutex.Unlock()
+
+	a.mutex.Lock()
+	a.measures = a.measures[:0]
+
+	for key, s := range a.series {
+		if !s.dirty {
+			delete(a.series, key)
+			continue
+		}
+		a.measures = append(a.measures, s.collect())
+	}
+
+	a.mutex.Unlock()
+
+	if len(a.measures) != 0 {
+		a.Handler.HandleMeasures(now, a.measures...)
+	}
+
+	for i := range a.measures {
+		a.measures[i] = Measure{}
+	}
+}
+
+func appendAggregateKey(b []byte, name string, tags []Tag) []byte {
+	b = append(b, name...)
+
+	for _, t := range tags {
+		b = append(b, 0)
+		b = append(b, t.Name...)
+		b = append(b, 0)
+		b = append(b, t.Value...)
+	}
+
+	return b
+}
+
+type aggregate struct {
+	name   string
+	tags   []Tag
+	fields []aggregateField
+	output []Field
+	dirty  bool
+}
+
+func (s *aggregate) field(name string, ftype FieldType, quantiles []float64) *aggregateField {
+	for i := range s.fields {
+		if f := &s.fields[i]; f.name == name && f.ftype == ftype {
+			return f
+		}
+	}
+	s.fields = append(s.fields, makeAggregateField(name, ftype, quantiles))
+	return &s.fields[len(s.fields)-1]
+}
+
+func (s *aggregate) collect() Measure {
+	s.output = s.output[:0]
+
+	for i := range s.fields {
+		s.output = s.fields[i].appendFields(s.output)
+		s.fields[i].reset()
+	}
+
+	s.dirty = false
+
+	return Measure{
+		Name:   s.name,
+		Fields: s.output,
+		Tags:   s.tags,
+	}
+}
+
+type aggregateField struct {
+	name      string
+	ftype     FieldType
+	names     []string // count, sum, min, max, quantiles... (histograms only)
+	quantiles []float64
+	sketch    *Sketch
+	unique    *HyperLogLog // sets only
+	count     uint64
+	weight    float64 // sum of the inverse sample rates (histograms only)
+	value     Value   // sum of counters, last value of gauges, sum of histograms
+	min       Value
+	max       Value
+}
+
+func makeAggregateField(name string, ftype FieldType, quantiles []float64) aggregateField {
+	f := aggregateField{name: name, ftype: ftype}
+
+	switch ftype {
+	case Counter, Gauge:
+	case UniqueSet:
+		f.unique = &HyperLogLog{}
+	default:
+		// Cache the names of the summary fields so they don't have to be
+		// recomputed on every flush.
+		f.names = make([]string, 0, 4+len(quantiles))
+
+		for _, suffix := range [...]string{"count", "sum", "min", "max"} {
+			f.names = append(f.names, concat(name, suffix))
+		}
+
+		if len(quantiles) != 0 {
+			f.quantiles = quantiles
+			f.sketch = NewSketch(DefaultSketchAccuracy)
+
+			for _, q := range quantiles {
+				f.names = append(f.names, concat(name, "p"+strconv.FormatFloat(100*q, 'g', -1, 64)))
+			}
+		}
+	}
+
+	return f
+}
+
+func (f *aggregateField) update(v Value, rate float64) {
+	// The padding of the value carries the type and sample rate of the field
+	// it came from, which must not leak into the aggregated fields.
+	v.pad = 0
+
+	if v.Type() == Bool {
+		v = int64Value(int64(boolBits(v.Bool())))
+	}
+
+	switch f.ftype {
+	case Counter:
+		if rate != 1 {
+			v = scaleValue(v, 1/rate)
+		}
+
+		if f.count == 0 {
+			f.value = v
+		} else {
+			f.value = addValues(f.value, v)
+		}
+
+	case Gauge:
+		f.value = v
+
+	case UniqueSet:
+		f.unique.Add(v)
+
+	default:
+		sum := v
+
+		if rate != 1 {
+			sum = scaleValue(v, 1/rate)
+		}
+
+		if f.count == 0 {
+			f.value, f.min, f.max = sum, v, v
+		} else {
+			f.value = addValues(f.value, sum)
+
+			if lessValues(v, f.min) {
+				f.min = v
+			}
+
+			if lessValues(f.max, v) {
+				f.max = v
+			}
+		}
+
+		if f.sketch != nil {
+			f.sketch.Add(floatOf(v))
+		}
+
+		f.weight += 1 / rate
+	}
+
+	f.count++
+}
+
+func (f *aggregateField) appendFields(fields []Field) []Field {
+	if f.count == 0 {
+		return fields
+	}
+
+	switch f.ftype {
+	case Counter, Gauge:
+		fields = append(fields, f.makeField(f.name, f.value, f.ftype))
+
+	case UniqueSet:
+		fields = append(fields, f.makeField(f.name, uint64Value(f.unique.Count()), Gauge))
+
+	default:
+		fields = append(fields,
+			f.makeField(f.names[0], uint64Value(uint64(f.weight+0.5)), Counter),
+			f.makeField(f.names[1], f.value, Counter),
+			f.makeField(f.names[2], f.min, Gauge),
+			f.makeField(f.names[3], f.max, Gauge),
+		)
+
+		for i, q := range f.quantiles {
+			v := f.sketch.Quantile(q)
+			fields = append(fields, f.makeField(f.names[4+i], f.quantileValue(v), Gauge))
+		}
+	}
+
+	return fields
+}
+
+func (f *aggregateField) makeField(name string, value Value, ftype FieldType) Field {
+	field := Field{Name: name, Value: value}
+	field.setType(ftype)
+	return field
+}
+
+func (f *aggregateField) quantileValue(v float64) Value {
+	if f.value.Type() == Duration {
+		return durationValue(time.Duration(v))
+	}
+	return float64Value(v)
+}
+
+func (f *aggregateField) reset() {
+	if f.sketch != nil {
+		f.sketch.Reset()
+	}
+	if f.unique != nil {
+		f.unique.Reset()
+	}
+	f.count = 0
+	f.weight = 0
+	f.value = Value{}
+	f.min = Value{}
+	f.max = Value{}
+}
+
+func addValues(v1 Value, v2 Value) Value {
+	if v1.Type() == v2.Type() {
+		switch v1.Type() {
+		case Int:
+			return int64Value(v1.Int() + v2.Int())
+		case Uint:
+			return uint64Value(v1.Uint() + v2.Uint())
+		case Float:
+			return float64Value(v1.Float() + v2.Float())
+		case Duration:
+			return durationValue(v1.Duration() + v2.Duration())
+		}
+	}
+	return float64Value(floatOf(v1) + floatOf(v2))
+}
+
+func scaleValue(v Value, factor float64) Value {
+	if v.Type() == Duration {
+		return durationValue(time.Duration(float64(v.Duration()) * factor))
+	}
+	return float64Value(floatOf(v) * factor)
+}
+
+func lessValues(v1 Value, v2 Value) bool {
+	if v1.Type() == v2.Type() {
+		switch v1.Type() {
+		case Int:
+			return v1.Int() < v2.Int()
+		case Uint:
+			return v1.Uint() < v2.Uint()
+		case Duration:
+			return v1.Duration() < v2.Duration()
+		}
+	}
+	return floatOf(v1) < floatOf(v2)
+}
+
+func floatOf(v Value) float64 {
+	switch v.Type() {
+	case Bool:
+		return float64(boolBits(v.Bool()))
+	case Int:
+		return float64(v.Int())
+	case Uint:
+		return float64(v.Uint())
+	case Float:
+		return v.Float()
+	case Duration:
+		return float64(v.Duration())
+	default:
+		return 0
+	}
+}
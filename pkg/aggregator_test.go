@@ -0,0 +1,332 @@
+package stats_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestAggregator(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *stats.Aggregator, *statstest.Handler)
+	}{
+		{
+			scenario: "counters are summed per name and tags",
+			function: testAggregatorCounters,
+		},
+		{
+			scenario: "gauges retain the last value they were set to",
+			function: testAggregatorGauges,
+		},
+		{
+			scenario: "histograms are summarized by count, sum, min and max",
+			function: testAggregatorHistograms,
+		},
+		{
+			scenario: "histograms report the configured quantiles",
+			function: testAggregatorQuantiles,
+		},
+		{
+			scenario: "distributions are summarized like histograms",
+			function: testAggregatorDistributions,
+		},
+		{
+			scenario: "sets report the number of distinct values",
+			function: testAggregatorSets,
+		},
+		{
+			scenario: "sampled counters and histograms are scaled by their sample rate",
+			function: testAggregatorSampled,
+		},
+		{
+			scenario: "series that were not updated are not forwarded again",
+			function: testAggregatorIdleSeries,
+		},
+		{
+			scenario: "calling Flush flushes the underlying handler",
+			function: testAggregatorFlush,
+		},
+	}
+
+	for _, test := range tests {
+		testFunc := test.function
+		t.Run(test.scenario, func(t *testing.T) {
+			t.Parallel()
+			h := &statstest.Handler{}
+			testFunc(t, stats.NewAggregator(h, 0), h)
+		})
+	}
+}
+
+func testAggregatorCounters(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Incr("calls")
+	eng.Incr("calls")
+	eng.Add("calls", 40)
+	eng.Incr("calls", stats.T("status", "error"))
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Counter)},
+		},
+		stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("status", "error")},
+		},
+	)
+}
+
+func testAggregatorGauges(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Set("level", 1)
+	eng.Set("level", 3)
+	eng.Set("level", 2)
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.level",
+			Fields: []stats.Field{stats.MakeField("", 2, stats.Gauge)},
+		},
+	)
+}
+
+func testAggregatorHistograms(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Observe("rtt:value", 2*time.Second)
+	eng.Observe("rtt:value", 1*time.Second)
+	eng.Observe("rtt:value", 3*time.Second)
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name: "test.rtt",
+			Fields: []stats.Field{
+				stats.MakeField("value.count", uint64(3), stats.Counter),
+				stats.MakeField("value.sum", 6*time.Second, stats.Counter),
+				stats.MakeField("value.min", 1*time.Second, stats.Gauge),
+				stats.MakeField("value.max", 3*time.Second, stats.Gauge),
+			},
+		},
+	)
+}
+
+func testAggregatorQuantiles(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	a.Quantiles = []float64{0.5}
+	eng := stats.NewEngine("test", a)
+	eng.Observe("size", 1.0)
+	eng.Observe("size", 1.0)
+	eng.Observe("size", 1.0)
+	a.Flush()
+
+	found := h.Measures()
+
+	if len(found) != 1 || len(found[0].Fields) != 5 {
+		t.Fatal("bad measures:", found)
+	}
+
+	if f := found[0].Fields[4]; f.Name != "p50" || f.Value.Float() != 1.0 {
+		t.Error("bad quantile field:", f)
+	}
+}
+
+func testAggregatorDistributions(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Distribute("size", 2)
+	eng.Distribute("size", 1)
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name: "test.size",
+			Fields: []stats.Field{
+				stats.MakeField("count", uint64(2), stats.Counter),
+				stats.MakeField("sum", 3, stats.Counter),
+				stats.MakeField("min", 1, stats.Gauge),
+				stats.MakeField("max", 2, stats.Gauge),
+			},
+		},
+	)
+}
+
+func testAggregatorSets(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Unique("users", "alice")
+	eng.Unique("users", "bob")
+	eng.Unique("users", "alice")
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.users",
+			Fields: []stats.Field{stats.MakeField("", uint64(2), stats.Gauge)},
+		},
+	)
+
+	// The set is reset after every flush.
+	h.Clear()
+	eng.Unique("users", "alice")
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.users",
+			Fields: []stats.Field{stats.MakeField("", uint64(1), stats.Gauge)},
+		},
+	)
+}
+
+func testAggregatorSampled(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	now := time.Now()
+
+	a.HandleMeasures(now,
+		stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter).WithRate(0.1)},
+		},
+		stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter).WithRate(0.5)},
+		},
+		stats.Measure{
+			Name:   "test.rtt",
+			Fields: []stats.Field{stats.MakeField("", time.Second, stats.Histogram).WithRate(0.25)},
+		},
+		stats.Measure{
+			Name:   "test.rtt",
+			Fields: []stats.Field{stats.MakeField("", 2*time.Second, stats.Histogram)},
+		},
+	)
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 12.0, stats.Counter)},
+		},
+		stats.Measure{
+			Name: "test.rtt",
+			Fields: []stats.Field{
+				stats.MakeField("count", uint64(5), stats.Counter),
+				stats.MakeField("sum", 6*time.Second, stats.Counter),
+				stats.MakeField("min", 1*time.Second, stats.Gauge),
+				stats.MakeField("max", 2*time.Second, stats.Gauge),
+			},
+		},
+	)
+}
+
+func testAggregatorIdleSeries(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	eng := stats.NewEngine("test", a)
+	eng.Incr("A")
+	eng.Incr("B")
+	a.Flush()
+
+	h.Clear()
+	eng.Incr("B")
+	a.Flush()
+
+	checkAggregatedMeasures(t, h,
+		stats.Measure{
+			Name:   "test.B",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+		},
+	)
+
+	h.Clear()
+	a.Flush()
+	checkAggregatedMeasures(t, h)
+}
+
+func testAggregatorFlush(t *testing.T, a *stats.Aggregator, h *statstest.Handler) {
+	a.Flush()
+	a.Flush()
+
+	if n := h.FlushCalls(); n != 2 {
+		t.Error("bad number of flush calls:", n)
+	}
+}
+
+func TestAggregatorFlushInterval(t *testing.T) {
+	h := &statstest.Handler{}
+	a := stats.NewAggregator(h, 10*time.Millisecond)
+	stats.NewEngine("test", a).Incr("calls")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := len(h.Measures()); n != 1 {
+		t.Error("bad number of measures forwarded by the background flush:", n)
+	}
+
+	a.Close()
+}
+
+func TestAggregatorAllocs(t *testing.T) {
+	a := stats.NewAggregator(stats.Discard, 0)
+	now := time.Now()
+	measures := []stats.Measure{
+		{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		{
+			Name:   "test.rtt",
+			Fields: []stats.Field{stats.MakeField("", time.Second, stats.Histogram)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+	}
+
+	// Warm up the series so the measurement only covers the steady state.
+	a.HandleMeasures(now, measures...)
+	a.Flush()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		a.HandleMeasures(now, measures...)
+		a.Flush()
+	})
+
+	if allocs != 0 {
+		t.Error("aggregating measures allocated memory in the steady state:", allocs)
+	}
+}
+
+func checkAggregatedMeasures(t *testing.T, h *statstest.Handler, expected ...stats.Measure) {
+	found := h.Measures()
+	sort.Slice(found, func(i int, j int) bool { return found[i].String() < found[j].String() })
+	sort.Slice(expected, func(i int, j int) bool { return expected[i].String() < expected[j].String() })
+
+	if len(found) == 0 && len(expected) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Error("bad measures:")
+		t.Logf("expected: %v", expected)
+		t.Logf("found:    %v", found)
+	}
+}
+
+func BenchmarkAggregator(b *testing.B) {
+	a := stats.NewAggregator(stats.Discard, 0)
+	t := time.Now()
+	m := stats.Measure{
+		Name:   "test.calls",
+		Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("service", "test-service")},
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.HandleMeasures(t, m)
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the capacity of the queue of an AsyncHandler that
+	// wasn't configured with one.
+	DefaultQueueSize = 1024
+
+	// DefaultQueueMeasure is the name of the measure reporting the state of the
+	// queue of an AsyncHandler that wasn't configured with one.
+	DefaultQueueMeasure = "stats.queue"
+)
+
+// QueuePolicy is an enumeration of the behaviors of an AsyncHandler when its
+// queue is full.
+type QueuePolicy int
+
+const (
+	// QueueDropOldest discards the oldest batch of measures in the queue to
+	// make room for the new one.
+	QueueDropOldest QueuePolicy = iota
+
+	// QueueDropNewest discards the new batch of measures.
+	QueueDropNewest
+
+	// QueueBlock blocks the caller until there is room in the queue.
+	QueueBlock
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueDropOldest:
+		return "drop-oldest"
+	case QueueDropNewest:
+		return "drop-newest"
+	case QueueBlock:
+		return "block"
+	default:
+		return "<unknown>"
+	}
+}
+
+// AsyncHandler is the implementation of a measure handler which forwards the
+// measures it receives to another handler on background goroutines, so slow
+// backends (a handler doing network I/O for example) don't stall the program.
+//
+// The measures are copied into a bounded queue, the Policy field controls what
+// happens when the queue is full.
+//
+// On every flush, the handler reports the state of its queue as a measure named
+// after Measure, with a "depth" gauge field counting the batches waiting in the
+// queue and a "drops" counter field counting the batches that were discarded
+// since the previous flush.
+//
+// Async handlers are safe to use concurrently from multiple goroutines. The
+// program must call Close to release the background goroutines.
+type AsyncHandler struct {
+	// The handler that measures are forwarded to.
+	//
+	// This field cannot be nil.
+	Handler Handler
+
+	// QueueSize is the maximum number of batches of measures (one per call to
+	// HandleMeasures) waiting to be forwarded.
+	//
+	// If zero, DefaultQueueSize is used.
+	QueueSize int
+
+	// Workers is the number of goroutines forwarding measures to the handler.
+	//
+	// If zero, a single goroutine is used, which preserves the order of the
+	// measures.
+	Workers int
+
+	// Policy is the behavior of the handler when its queue is full.
+	Policy QueuePolicy
+
+	// Measure is the name of the measure reporting the state of the queue.
+	//
+	// If empty, DefaultQueueMeasure is used.
+	Measure string
+
+	once     sync.Once
+	join     sync.WaitGroup
+	mutex    sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	idle     sync.Cond
+	queue    []asyncBatch
+	head     int
+	size     int
+	seq      uint64   // sequence number of the next batch pushed to the queue
+	working  []uint64 // sequence number + 1 of the batch of each worker, or 0
+	drops    uint64
+	closed   bool
+}
+
+type asyncBatch struct {
+	seq      uint64
+	time     time.Time
+	measures []Measure
+}
+
+// NewAsyncHandler creates and returns a new async handler which forwards
+// measures to handler, with a queue of the given size.
+func NewAsyncHandler(handler Handler, size int) *AsyncHandler {
+	return &AsyncHandler{
+		Handler:   handler,
+		QueueSize: size,
+	}
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (h *AsyncHandler) HandleMeasures(time time.Time, measures ...Measure) {
+	if len(measures) == 0 {
+		return
+	}
+
+	h.once.Do(h.init)
+
+	// The measures are owned by the caller, they have to be copied before the
+	// method returns.
+	batch := asyncBatch{time: time, measures: make([]Measure, len(measures))}
+
+	for i, m := range measures {
+		batch.measures[i] = m.Clone()
+	}
+
+	h.mutex.Lock()
+
+	if h.Policy == QueueBlock {
+		for h.size == len(h.queue) && !h.closed {
+			h.notFull.Wait()
+		}
+	}
+
+	switch {
+	case h.closed:
+		h.drops++
+
+	case h.size < len(h.queue):
+		h.push(batch)
+
+	case h.Policy == QueueDropOldest:
+		h.pop()
+		h.push(batch)
+		h.drops++
+		h.idle.Broadcast()
+
+	default:
+		h.drops++
+	}
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+//
+// The method waits for the measures queued before it was called to be
+// forwarded (measures queued concurrently by other goroutines are not waited
+// for), reports the state of the queue, then flushes the handler that measures
+// are forwarded to.
+func (h *AsyncHandler) Flush() {
+	h.once.Do(h.init)
+	h.mutex.Lock()
+
+	for seq := h.seq; h.pending() < seq && !h.closed; {
+		h.idle.Wait()
+	}
+
+	m := h.stats()
+	h.mutex.Unlock()
+
+	h.Handler.HandleMeasures(time.Now(), m)
+	flush(h.Handler)
+}
+
+// Close stops the handler after forwarding the measures remaining in its queue,
+// then flushes the handler that measures are forwarded to. Measures received
+// after the handler was closed are dropped.
+//
+// The method always returns a nil error, it has this signature to satisfy the
+// io.Closer interface.
+func (h *AsyncHandler) Close() error {
+	if m, ok := h.drain(); ok {
+		h.Handler.HandleMeasures(time.Now(), m)
+		flush(h.Handler)
+	}
+	return nil
+}
+
+// drain stops the handler after forwarding the measures remaining in its queue,
+// and returns the final state of the queue. The method returns false if the
+// handler was already closed.
+func (h *AsyncHandler) drain() (Measure, bool) {
+	h.once.Do(h.init)
+	h.mutex.Lock()
+
+	if h.closed {
+		h.mutex.Unlock()
+		return Measure{}, false
+	}
+
+	h.closed = true
+	h.notEmpty.Broadcast()
+	h.notFull.Broadcast()
+	h.idle.Broadcast()
+	h.mutex.Unlock()
+
+	h.join.Wait()
+
+	h.mutex.Lock()
+	m := h.stats()
+	h.mutex.Unlock()
+	return m, true
+}
+
+func (h *AsyncHandler) init() {
+	h.queue = make([]asyncBatch, h.queueSize())
+	h.notEmpty.L = &h.mutex
+	h.notFull.L = &h.mutex
+	h.idle.L = &h.mutex
+	h.working = make([]uint64, h.workers())
+
+	for i := range h.working {
+		h.join.Add(1)
+		go h.run(i)
+	}
+}
+
+func (h *AsyncHandler) run(worker int) {
+	defer h.join.Done()
+	h.mutex.Lock()
+
+	for {
+		for h.size == 0 && !h.closed {
+			h.notEmpty.Wait()
+		}
+
+		// The queue is drained before the workers exit when the handler
+		// is closed.
+		if h.size == 0 {
+			break
+		}
+
+		batch := h.pop()
+		h.working[worker] = batch.seq + 1
+		h.notFull.Signal()
+		h.mutex.Unlock()
+
+		h.Handler.HandleMeasures(batch.time, batch.measures...)
+
+		h.mutex.Lock()
+		h.working[worker] = 0
+		h.idle.Broadcast()
+	}
+
+	h.mutex.Unlock()
+}
+
+func (h *AsyncHandler) push(batch asyncBatch) {
+	batch.seq = h.seq
+	h.seq++
+	h.queue[(h.head+h.size)%len(h.queue)] = batch
+	h.size++
+	h.notEmpty.Signal()
+}
+
+func (h *AsyncHandler) pop() asyncBatch {
+	batch := h.queue[h.head]
+	h.queue[h.head] = asyncBatch{}
+	h.head = (h.head + 1) % len(h.queue)
+	h.size--
+	return batch
+}
+
+// pending returns the sequence number of the oldest batch which wasn't forwarded
+// yet, all the batches before it were either forwarded or dropped. The mutex
+// must be held when calling the method.
+func (h *AsyncHandler) pending() uint64 {
+	seq := h.seq
+
+	if h.size != 0 {
+		seq = h.queue[h.head].seq
+	}
+
+	for _, w := range h.working {
+		if w != 0 && w-1 < seq {
+			seq = w - 1
+		}
+	}
+
+	return seq
+}
+
+// stats returns the measure reporting the state of the queue, and resets the
+// drop counter. The mutex must be held when calling the method.
+func (h *AsyncHandler) stats() Measure {
+	m := Measure{
+		Name: h.measure(),
+		Fields: []Field{
+			MakeField("depth", h.size, Gauge),
+			MakeField("drops", h.drops, Counter),
+		},
+	}
+	h.drops = 0
+	return m
+}
+
+func (h *AsyncHandler) queueSize() int {
+	if size := h.QueueSize; size > 0 {
+		return size
+	}
+	return DefaultQueueSize
+}
+
+func (h *AsyncHandler) workers() int {
+	if n := h.Workers; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (h *AsyncHandler) measure() string {
+	if measure := h.Measure; len(measure) != 0 {
+		return measure
+	}
+	return DefaultQueueMeasure
+}
@@ -0,0 +1,264 @@
+package stats_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+// blockingHandler is a measure handler which blocks until it is released, it
+// is used to fill the queue of async handlers.
+type blockingHandler struct {
+	statstest.Handler
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *blockingHandler) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	if measures[0].Name != stats.DefaultQueueMeasure {
+		h.started <- struct{}{}
+		<-h.release
+	}
+	h.Handler.HandleMeasures(time, measures...)
+}
+
+func (h *blockingHandler) unblock() {
+	h.once.Do(func() { close(h.release) })
+}
+
+func (h *blockingHandler) names() (names []string, drops uint64) {
+	for _, m := range h.Measures() {
+		if m.Name == stats.DefaultQueueMeasure {
+			drops += m.Fields[1].Value.Uint()
+		} else {
+			names = append(names, m.Name)
+		}
+	}
+	return
+}
+
+func TestAsyncHandler(t *testing.T) {
+	h := &statstest.Handler{}
+	a := stats.NewAsyncHandler(h, 10)
+	defer a.Close()
+
+	tags := []stats.Tag{stats.T("a", "1")}
+
+	for i := 0; i != 5; i++ {
+		a.HandleMeasures(time.Now(), stats.Measure{Name: "test", Tags: tags})
+	}
+
+	// The measures must have been copied by the handler.
+	tags[0].Value = "2"
+	a.Flush()
+
+	found := h.Measures()
+
+	if len(found) != 6 {
+		t.Fatal("bad number of measures:", len(found))
+	}
+
+	for _, m := range found[:5] {
+		if m.Name != "test" || m.Tags[0] != stats.T("a", "1") {
+			t.Error("bad measure:", m)
+		}
+	}
+
+	if m := found[5]; m.Name != stats.DefaultQueueMeasure || m.Fields[0].Value.Int() != 0 || m.Fields[1].Value.Uint() != 0 {
+		t.Error("bad queue measure:", m)
+	}
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func TestAsyncHandlerPolicy(t *testing.T) {
+	tests := []struct {
+		policy stats.QueuePolicy
+		names  []string
+		drops  uint64
+	}{
+		{
+			policy: stats.QueueDropOldest,
+			names:  []string{"m0", "m2"},
+			drops:  1,
+		},
+		{
+			policy: stats.QueueDropNewest,
+			names:  []string{"m0", "m1"},
+			drops:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			h := newBlockingHandler()
+			a := &stats.AsyncHandler{Handler: h, QueueSize: 1, Policy: test.policy}
+
+			a.HandleMeasures(time.Now(), stats.Measure{Name: "m0"})
+			<-h.started // m0 is being handled, the queue is empty
+
+			a.HandleMeasures(time.Now(), stats.Measure{Name: "m1"})
+			a.HandleMeasures(time.Now(), stats.Measure{Name: "m2"})
+
+			h.unblock()
+			a.Close()
+
+			names, drops := h.names()
+
+			if len(names) != len(test.names) || names[0] != test.names[0] || names[1] != test.names[1] {
+				t.Error("bad measures:", names)
+			}
+
+			if drops != test.drops {
+				t.Error("bad number of drops:", drops)
+			}
+		})
+	}
+}
+
+func TestAsyncHandlerBlock(t *testing.T) {
+	h := newBlockingHandler()
+	a := &stats.AsyncHandler{Handler: h, QueueSize: 1, Policy: stats.QueueBlock}
+
+	a.HandleMeasures(time.Now(), stats.Measure{Name: "m0"})
+	<-h.started
+	a.HandleMeasures(time.Now(), stats.Measure{Name: "m1"})
+
+	done := make(chan struct{})
+
+	go func() {
+		a.HandleMeasures(time.Now(), stats.Measure{Name: "m2"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("the handler did not block when its queue was full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	h.unblock()
+	<-done
+	a.Close()
+
+	if names, drops := h.names(); len(names) != 3 || drops != 0 {
+		t.Error("bad measures:", names, drops)
+	}
+}
+
+// slowHandler is a measure handler which takes some time to handle measures,
+// so producers can enqueue measures faster than they are forwarded.
+type slowHandler struct {
+	statstest.Handler
+}
+
+func (h *slowHandler) HandleMeasures(t time.Time, measures ...stats.Measure) {
+	time.Sleep(100 * time.Microsecond)
+	h.Handler.HandleMeasures(t, measures...)
+}
+
+func TestAsyncHandlerFlushBusy(t *testing.T) {
+	h := &slowHandler{}
+	a := &stats.AsyncHandler{Handler: h, QueueSize: 10, Policy: stats.QueueBlock, Workers: 2}
+	defer a.Close()
+
+	for i := 0; i != 10; i++ {
+		a.HandleMeasures(time.Now(), stats.Measure{Name: "before"})
+	}
+
+	stop := make(chan struct{})
+	join := make(chan struct{})
+
+	go func() {
+		defer close(join)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				a.HandleMeasures(time.Now(), stats.Measure{Name: "after"})
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+
+	go func() {
+		a.Flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("flushing blocked while measures were enqueued concurrently")
+	}
+
+	n := 0
+	for _, m := range h.Measures() {
+		if m.Name == "before" {
+			n++
+		}
+	}
+
+	close(stop)
+	<-join
+
+	if n != 10 {
+		t.Error("the measures queued before the flush were not forwarded:", n)
+	}
+}
+
+func TestAsyncHandlerClose(t *testing.T) {
+	h := newBlockingHandler()
+	a := &stats.AsyncHandler{Handler: h, Workers: 4}
+
+	for i := 0; i != 100; i++ {
+		a.HandleMeasures(time.Now(), stats.Measure{Name: "test"})
+	}
+
+	h.unblock()
+	a.Close()
+	a.Close() // closing twice is fine
+
+	a.HandleMeasures(time.Now(), stats.Measure{Name: "test"})
+
+	if names, _ := h.names(); len(names) != 100 {
+		t.Error("the queue was not drained when closing the handler:", len(names))
+	}
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func BenchmarkAsyncHandler(b *testing.B) {
+	a := stats.NewAsyncHandler(stats.Discard, 0)
+	defer a.Close()
+
+	t := time.Now()
+	m := stats.Measure{
+		Name:   "test.calls",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("a", "1")},
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.HandleMeasures(t, m)
+		}
+	})
+}
@@ -33,9 +33,49 @@ type Buffer struct {
 	// This field cannot be nil.
 	Serializer Serializer
 
-	once    sync.Once
-	offset  uint64
-	buffers []buffer
+	// ErrorHandler is called with the errors returned by the Serializer when
+	// writing measures. If nil, the errors are ignored.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the buffer reports metrics about
+	// itself, in a measure named "stats.buffer" with counter fields for the
+	// number of measures and bytes written, the number of flushes and write
+	// errors, and the number of batches of measures that were larger than the
+	// buffer size. If nil, no metrics are reported.
+	//
+	// The engine must not forward measures to the buffer itself, it is usually
+	// a separate engine, which makes it possible to report when the delivery
+	// of metrics is failing.
+	StatsEngine *Engine
+
+	// FlushInterval is the maximum amount of time that measures may wait in
+	// the buffer before being written, regardless of the buffer size. When
+	// set, a background goroutine flushes the buffers that reached this age,
+	// the program must call Close to stop it.
+	//
+	// If zero or negative, measures are only written when a buffer is full or
+	// when Flush is called.
+	FlushInterval time.Duration
+
+	once     sync.Once
+	offset   uint64
+	measures uint64
+	buffers  []buffer
+
+	closeOnce sync.Once
+	closed    uint32
+	stop      chan struct{}
+	join      sync.WaitGroup
+}
+
+type bufferMetrics struct {
+	buffer struct {
+		measures uint64 `metric:"measures.count" type:"counter"`
+		bytes    int    `metric:"written.bytes"  type:"counter"`
+		flushes  int    `metric:"flushes.count"  type:"counter"`
+		errors   int    `metric:"errors.count"   type:"counter"`
+		oversize int    `metric:"oversize.count" type:"counter"`
+	} `metric:"stats.buffer"`
 }
 
 // HandleMeasures satisfies the Handler interface.
@@ -47,22 +87,52 @@ func (b *Buffer) HandleMeasures(time time.Time, measures ...Measure) {
 	size := b.bufferSize()
 	b.prepare(size)
 
+	if b.StatsEngine != nil {
+		atomic.AddUint64(&b.measures, uint64(len(measures)))
+	}
+
 	buffer := b.acquireBuffer()
 	length := buffer.len()
 	buffer.append(b.Serializer, time, measures...)
 
-	if buffer.len() >= size {
-		if length == 0 {
-			// When there were no data in the buffer prior to writing the set of
-			// measures we unfortunately have to overflow the configured buffer
-			// size, but the Serializer documentation mentions that this corner
-			// case has to be handled.
-			length = buffer.len()
-		}
-		buffer.flush(b.Serializer, length)
+	if length == 0 && b.FlushInterval > 0 {
+		buffer.touch()
+	}
+
+	// Once the buffer was closed its pooled buffers are not drained anymore,
+	// so the measures are written right away. Checking after acquiring the
+	// buffer guarantees that either Close or this call writes them.
+	closed := atomic.LoadUint32(&b.closed) != 0
+
+	if buffer.len() < size && !closed {
+		buffer.release()
+		return
+	}
+
+	oversize := length == 0 && buffer.len() >= size
+
+	if oversize || closed {
+		// When there were no data in the buffer prior to writing the set of
+		// measures we unfortunately have to overflow the configured buffer
+		// size, but the Serializer documentation mentions that this corner
+		// case has to be handled.
+		// Closed buffers write all their data.
+		length = buffer.len()
+	}
+
+	err := buffer.flush(b.Serializer, length)
+
+	if buffer.len() != 0 && b.FlushInterval > 0 {
+		// The data left in the buffer are the measures that were just
+		// appended.
+		buffer.touch()
 	}
 
 	buffer.release()
+
+	// Errors and metrics are reported after releasing the buffer, the
+	// handlers may be slow or produce measures themselves.
+	b.report(length, oversize, err)
 }
 
 // Flush satisfies the Flusher interface.
@@ -71,18 +141,139 @@ func (b *Buffer) Flush() {
 
 	for i := range b.buffers {
 		if buffer := &b.buffers[i]; buffer.acquire() {
-			buffer.flush(b.Serializer, buffer.len())
-			buffer.release()
+			b.flushBuffer(buffer)
 		}
 	}
 }
 
+// Close stops the background flusher, then writes the measures remaining in
+// every pooled buffer. Measures received after the buffer was closed are
+// written right away.
+//
+// Only the first call to Close has an effect, the method returns the first
+// error that occurred while writing the remaining measures.
+func (b *Buffer) Close() (err error) {
+	b.closeOnce.Do(func() {
+		atomic.StoreUint32(&b.closed, 1)
+		b.prepare(b.bufferSize())
+
+		if b.stop != nil {
+			close(b.stop)
+			b.join.Wait()
+		}
+
+		for i := range b.buffers {
+			buffer := &b.buffers[i]
+
+			// Unlike Flush, Close must not skip the buffers that are in use
+			// or their measures might never be written.
+			for !buffer.acquire() {
+				runtime.Gosched()
+			}
+
+			if e := b.flushBuffer(buffer); err == nil {
+				err = e
+			}
+		}
+	})
+	return
+}
+
+// flushBuffer writes all the data of buffer, which must have been acquired by
+// the caller, and releases it.
+//
+// Flush and Close go through all the pooled buffers, the serializer is not
+// called for the ones that hold no data.
+func (b *Buffer) flushBuffer(buffer *buffer) error {
+	length := buffer.len()
+
+	if length == 0 {
+		buffer.release()
+		return nil
+	}
+
+	err := buffer.flush(b.Serializer, length)
+	buffer.release()
+
+	b.report(length, false, err)
+	return err
+}
+
+// run is the background flusher of buffers configured with a flush interval,
+// it periodically writes the buffers holding measures older than the interval.
+func (b *Buffer) run(interval time.Duration) {
+	defer b.join.Done()
+
+	// Checking the buffers more often than the interval bounds how late the
+	// measures are written.
+	tick := interval / 4
+	if tick == 0 {
+		tick = interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+
+		case now := <-ticker.C:
+			deadline := now.Add(-interval).UnixNano()
+
+			for i := range b.buffers {
+				// Buffers in use are skipped, they are checked again on the
+				// next tick.
+				if buffer := &b.buffers[i]; buffer.acquire() {
+					if buffer.len() != 0 && buffer.since <= deadline {
+						b.flushBuffer(buffer)
+					} else {
+						buffer.release()
+					}
+				}
+			}
+		}
+	}
+}
+
+func (b *Buffer) report(length int, oversize bool, err error) {
+	if err != nil && b.ErrorHandler != nil {
+		b.ErrorHandler(err)
+	}
+
+	if b.StatsEngine == nil {
+		return
+	}
+
+	m := bufferMetrics{}
+	m.buffer.measures = atomic.SwapUint64(&b.measures, 0)
+	m.buffer.bytes = length
+	m.buffer.flushes = 1
+
+	if err != nil {
+		m.buffer.errors = 1
+	}
+
+	if oversize {
+		m.buffer.oversize = 1
+	}
+
+	b.StatsEngine.Report(&m)
+}
+
 func (b *Buffer) prepare(bufferSize int) {
 	b.once.Do(func() {
 		b.buffers = make([]buffer, b.bufferPoolSize())
 		for i := range b.buffers {
 			b.buffers[i].init(bufferSize)
 		}
+
+		if interval := b.FlushInterval; interval > 0 && atomic.LoadUint32(&b.closed) == 0 {
+			b.stop = make(chan struct{})
+			b.join.Add(1)
+			go b.run(interval)
+		}
 	})
 }
 
@@ -130,9 +321,10 @@ type Serializer interface {
 }
 
 type buffer struct {
-	lock uint64
-	data []byte
-	pad  [32]byte // padding to avoid false sharing between threads
+	lock  uint64
+	since int64 // time at which the oldest data were appended, in nanoseconds
+	data  []byte
+	pad   [24]byte // padding to avoid false sharing between threads
 }
 
 func (b *buffer) acquire() bool {
@@ -151,6 +343,10 @@ func (b *buffer) append(s Serializer, t time.Time, m ...Measure) {
 	b.data = s.AppendMeasures(b.data, t, m...)
 }
 
+func (b *buffer) touch() {
+	b.since = time.Now().UnixNano()
+}
+
 func (b *buffer) len() int {
 	return len(b.data)
 }
@@ -159,8 +355,9 @@ func (b *buffer) cap() int {
 	return cap(b.data)
 }
 
-func (b *buffer) flush(w io.Writer, n int) {
-	w.Write(b.data[:n])
+func (b *buffer) flush(w io.Writer, n int) error {
+	_, err := w.Write(b.data[:n])
 	n = copy(b.data, b.data[n:])
 	b.data = b.data[:n]
+	return err
 }
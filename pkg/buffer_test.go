@@ -0,0 +1,222 @@
+package stats_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+// testSerializer is a serializer writing the names of the measures, separated
+// by new lines, and failing the writes if err is set.
+type testSerializer struct {
+	mutex  sync.Mutex
+	err    error
+	writes [][]byte
+}
+
+func (s *testSerializer) AppendMeasures(b []byte, _ time.Time, measures ...stats.Measure) []byte {
+	for _, m := range measures {
+		b = append(b, m.Name...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+func (s *testSerializer) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writes = append(s.writes, append([]byte(nil), b...))
+	return len(b), s.err
+}
+
+func (s *testSerializer) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := ""
+	for _, w := range s.writes {
+		n += string(w)
+	}
+	return n
+}
+
+func TestBufferErrorHandler(t *testing.T) {
+	var errs []error
+
+	s := &testSerializer{err: errors.New("oops")}
+	b := &stats.Buffer{
+		BufferSize:     8,
+		BufferPoolSize: 1,
+		Serializer:     s,
+		ErrorHandler:   func(err error) { errs = append(errs, err) },
+	}
+
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "A"})
+	b.Flush()
+
+	if len(s.writes) != 1 || string(s.writes[0]) != "A\n" {
+		t.Error("bad writes:", s.writes)
+	}
+
+	if len(errs) != 1 || errs[0] != s.err {
+		t.Error("bad errors:", errs)
+	}
+
+	// Flushing an empty buffer doesn't call the serializer, so there are no
+	// errors to report.
+	b.Flush()
+
+	if len(s.writes) != 1 {
+		t.Error("bad writes:", s.writes)
+	}
+
+	if len(errs) != 1 {
+		t.Error("bad errors:", errs)
+	}
+}
+
+func TestBufferStatsEngine(t *testing.T) {
+	h := &statstest.Handler{}
+	s := &testSerializer{}
+	b := &stats.Buffer{
+		BufferSize:     8,
+		BufferPoolSize: 1,
+		Serializer:     s,
+		StatsEngine:    stats.NewEngine("", h),
+	}
+
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "A"}, stats.Measure{Name: "B"})
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "too-large"})
+	s.err = errors.New("oops")
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "C"})
+	b.Flush()
+
+	if len(s.writes) != 3 || string(s.writes[0]) != "A\nB\n" || string(s.writes[1]) != "too-large\n" || string(s.writes[2]) != "C\n" {
+		t.Errorf("bad writes: %q", s.writes)
+	}
+
+	type buffer struct {
+		measures uint64
+		bytes    int64
+		flushes  int64
+		errors   int64
+		oversize int64
+	}
+
+	expect := []buffer{
+		{measures: 3, bytes: 4, flushes: 1},
+		{measures: 1, bytes: 10, flushes: 1, errors: 1},
+		{measures: 0, bytes: 2, flushes: 1, errors: 1},
+	}
+
+	found := h.Measures()
+
+	if len(found) != len(expect) {
+		t.Fatal("bad number of measures:", found)
+	}
+
+	for i, m := range found {
+		if m.Name != "stats.buffer" || len(m.Fields) != 5 {
+			t.Error("bad measure:", m)
+			continue
+		}
+
+		x := buffer{
+			measures: m.Fields[0].Value.Uint(),
+			bytes:    m.Fields[1].Value.Int(),
+			flushes:  m.Fields[2].Value.Int(),
+			errors:   m.Fields[3].Value.Int(),
+			oversize: m.Fields[4].Value.Int(),
+		}
+
+		if x != expect[i] {
+			t.Errorf("bad metrics: %+v != %+v", x, expect[i])
+		}
+	}
+}
+
+func TestBufferStatsEngineOversize(t *testing.T) {
+	h := &statstest.Handler{}
+	b := &stats.Buffer{
+		BufferSize:     4,
+		BufferPoolSize: 1,
+		Serializer:     &testSerializer{},
+		StatsEngine:    stats.NewEngine("", h),
+	}
+
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "too-large"})
+
+	found := h.Measures()
+
+	if len(found) != 1 || found[0].Fields[4] != stats.MakeField("oversize.count", 1, stats.Counter) {
+		t.Error("bad measures:", found)
+	}
+}
+
+func TestBufferFlushInterval(t *testing.T) {
+	s := &testSerializer{}
+	b := &stats.Buffer{
+		BufferSize:    1024,
+		Serializer:    s,
+		FlushInterval: 10 * time.Millisecond,
+	}
+	defer b.Close()
+
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "A"})
+
+	for deadline := time.Now().Add(time.Second); s.String() == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("the measures were not written after the flush interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := s.String(); w != "A\n" {
+		t.Errorf("bad writes: %q", w)
+	}
+}
+
+func TestBufferClose(t *testing.T) {
+	s := &testSerializer{}
+	b := &stats.Buffer{
+		BufferSize:     1024,
+		BufferPoolSize: 4,
+		Serializer:     s,
+		FlushInterval:  time.Hour,
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i != 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j != 100; j++ {
+				b.HandleMeasures(time.Now(), stats.Measure{Name: "A"})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := b.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Closing twice must not write the measures again.
+	b.Close()
+
+	if n := len(s.String()); n != 800 {
+		t.Error("bad number of bytes written:", n)
+	}
+
+	// Measures received after closing the buffer are written right away.
+	b.HandleMeasures(time.Now(), stats.Measure{Name: "B"})
+
+	if n := len(s.String()); n != 802 {
+		t.Error("bad number of bytes written:", n)
+	}
+}
@@ -0,0 +1,253 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCardinalityLimit is the number of tag combinations tracked per
+	// measure by a CardinalityGuard that wasn't configured with a limit.
+	DefaultCardinalityLimit = 1000
+
+	// DefaultCardinalityOverflow is the tag value used by a CardinalityGuard
+	// that wasn't configured with an overflow value.
+	DefaultCardinalityOverflow = "other"
+
+	// DefaultCardinalityMeasure is the name of the measure reporting the
+	// overflows of a CardinalityGuard that wasn't configured with one.
+	DefaultCardinalityMeasure = "stats.cardinality"
+)
+
+// CardinalityGuard is the implementation of a measure handler which protects
+// the handler it forwards measures to from runaway tag values, like the path of
+// HTTP requests or user identifiers ending up in tags by mistake.
+//
+// The guard tracks the distinct combinations of tag values seen for each
+// measure name. Once Limit combinations were seen for a measure, the values of
+// the tags of new combinations are replaced with Overflow, so the number of
+// series that the backends have to handle remains bounded. Combinations that
+// were seen before reaching the limit keep being forwarded as they are.
+//
+// Combinations that didn't receive any measures between two flushes stop being
+// tracked, which makes room for new combinations, like the series of an
+// Aggregator.
+//
+// On every flush, the guard reports the number of measures that it collapsed
+// since the previous flush, as a counter field named "overflows" of a measure
+// named after Measure, tagged with the name of the measure that overflowed in
+// a "measure" tag. A "series" gauge field reports the number of tracked
+// combinations of the measure.
+//
+// Guards are safe to use concurrently from multiple goroutines.
+type CardinalityGuard struct {
+	// The handler that measures are forwarded to.
+	//
+	// This field cannot be nil.
+	Handler Handler
+
+	// Limit is the maximum number of distinct tag combinations forwarded for
+	// each measure name.
+	//
+	// If zero, DefaultCardinalityLimit is used.
+	Limit int
+
+	// Overflow is the value set on the tags of measures that exceeded the
+	// limit.
+	//
+	// If empty, DefaultCardinalityOverflow is used.
+	Overflow string
+
+	// KeepTags is a list of tag names whose values are never replaced, because
+	// they are known to have a low cardinality (the name of the service for
+	// example), so they remain useful on collapsed measures.
+	KeepTags []string
+
+	// Measure is the name of the measure reporting the overflows of the guard.
+	//
+	// If empty, DefaultCardinalityMeasure is used.
+	Measure string
+
+	once     sync.Once
+	mutex    sync.Mutex
+	measures map[string]*cardinalityState
+}
+
+type cardinalityState struct {
+	series    map[uint64]bool // true if the combination was seen since the last flush
+	overflows uint64
+}
+
+// NewCardinalityGuard creates and returns a new guard which forwards measures
+// to handler, limiting the number of tag combinations of each measure.
+func NewCardinalityGuard(handler Handler, limit int) *CardinalityGuard {
+	return &CardinalityGuard{
+		Handler: handler,
+		Limit:   limit,
+	}
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (g *CardinalityGuard) HandleMeasures(time time.Time, measures ...Measure) {
+	g.once.Do(g.init)
+
+	var collapsed []Measure
+	g.mutex.Lock()
+
+	for i := range measures {
+		m := &measures[i]
+
+		if len(m.Tags) == 0 || g.admit(m.Name, m.Tags) {
+			continue
+		}
+
+		if collapsed == nil {
+			// The measures are owned by the caller, they have to be copied
+			// before being modified.
+			collapsed = make([]Measure, len(measures))
+			copy(collapsed, measures)
+		}
+
+		collapsed[i].Tags = g.collapse(m.Tags)
+	}
+
+	g.mutex.Unlock()
+
+	if collapsed != nil {
+		measures = collapsed
+	}
+
+	g.Handler.HandleMeasures(time, measures...)
+}
+
+// Flush satisfies the Flusher interface.
+//
+// The method reports the overflows since the last flush to the guard's handler,
+// stops tracking the combinations of tags that were not seen since the last
+// flush, then flushes the handler.
+func (g *CardinalityGuard) Flush() {
+	g.once.Do(g.init)
+
+	var measures []Measure
+	g.mutex.Lock()
+
+	for name, state := range g.measures {
+		if state.overflows != 0 {
+			measures = append(measures, Measure{
+				Name: g.measure(),
+				Fields: []Field{
+					MakeField("overflows", state.overflows, Counter),
+					MakeField("series", len(state.series), Gauge),
+				},
+				Tags: []Tag{{"measure", name}},
+			})
+			state.overflows = 0
+		}
+
+		for key, seen := range state.series {
+			if seen {
+				state.series[key] = false
+			} else {
+				delete(state.series, key)
+			}
+		}
+
+		if len(state.series) == 0 {
+			delete(g.measures, name)
+		}
+	}
+
+	g.mutex.Unlock()
+
+	if len(measures) != 0 {
+		g.Handler.HandleMeasures(time.Now(), measures...)
+	}
+
+	flush(g.Handler)
+}
+
+func (g *CardinalityGuard) init() {
+	g.measures = make(map[string]*cardinalityState)
+}
+
+// admit returns true if the combination of tags is one of the combinations
+// tracked for the measure, adding it if the limit wasn't reached yet.
+func (g *CardinalityGuard) admit(name string, tags []Tag) bool {
+	state := g.measures[name]
+
+	if state == nil {
+		state = &cardinalityState{series: make(map[uint64]bool)}
+		g.measures[name] = state
+	}
+
+	key := hashTags(tags)
+
+	if _, ok := state.series[key]; ok {
+		state.series[key] = true
+		return true
+	}
+
+	if len(state.series) < g.limit() {
+		state.series[key] = true
+		return true
+	}
+
+	state.overflows++
+	return false
+}
+
+func (g *CardinalityGuard) collapse(tags []Tag) []Tag {
+	collapsed := make([]Tag, len(tags))
+
+	for i, t := range tags {
+		if !g.keep(t.Name) {
+			t.Value = g.overflow()
+		}
+		collapsed[i] = t
+	}
+
+	return collapsed
+}
+
+func (g *CardinalityGuard) keep(name string) bool {
+	for _, tag := range g.KeepTags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *CardinalityGuard) limit() int {
+	if limit := g.Limit; limit > 0 {
+		return limit
+	}
+	return DefaultCardinalityLimit
+}
+
+func (g *CardinalityGuard) overflow() string {
+	if overflow := g.Overflow; len(overflow) != 0 {
+		return overflow
+	}
+	return DefaultCardinalityOverflow
+}
+
+func (g *CardinalityGuard) measure() string {
+	if measure := g.Measure; len(measure) != 0 {
+		return measure
+	}
+	return DefaultCardinalityMeasure
+}
+
+func hashTags(tags []Tag) uint64 {
+	h := uint64(fnvOffset)
+
+	for _, t := range tags {
+		// The names and values are followed by a zero byte so different
+		// splits of the same bytes produce different hashes.
+		h = fnv1a(h, t.Name) * fnvPrime
+		h = fnv1a(h, t.Value) * fnvPrime
+	}
+
+	return h
+}
@@ -0,0 +1,155 @@
+package stats_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	h := &statstest.Handler{}
+	g := stats.NewCardinalityGuard(h, 2)
+	g.KeepTags = []string{"service"}
+
+	eng := stats.NewEngine("test", g, stats.T("service", "api"))
+
+	for _, path := range []string{"/a", "/b", "/c", "/a", "/d"} {
+		eng.Incr("requests", stats.T("path", path))
+	}
+
+	eng.Incr("errors", stats.T("path", "/e"))
+
+	paths := []string{}
+
+	for _, m := range h.Measures() {
+		if m.Tags[1].Value != "api" {
+			t.Error("the value of a kept tag was replaced:", m)
+		}
+		paths = append(paths, m.Name+m.Tags[0].Value)
+	}
+
+	expect := []string{
+		"test.requests/a",
+		"test.requests/b",
+		"test.requests" + stats.DefaultCardinalityOverflow,
+		"test.requests/a",
+		"test.requests" + stats.DefaultCardinalityOverflow,
+		"test.errors/e", // the limit applies to each measure
+	}
+
+	if len(paths) != len(expect) {
+		t.Fatal("bad measures:", paths)
+	}
+
+	for i := range expect {
+		if paths[i] != expect[i] {
+			t.Errorf("bad measure #%d: expected %s, found %s", i, expect[i], paths[i])
+		}
+	}
+
+	h.Clear()
+	g.Flush()
+
+	found := h.Measures()
+
+	if len(found) != 1 {
+		t.Fatal("bad overflow measures:", found)
+	}
+
+	m := found[0]
+
+	if m.Name != stats.DefaultCardinalityMeasure || m.Tags[0] != stats.T("measure", "test.requests") {
+		t.Error("bad overflow measure:", m)
+	}
+
+	if m.Fields[0].Value.Uint() != 2 || m.Fields[1].Value.Int() != 2 {
+		t.Error("bad overflow fields:", m.Fields)
+	}
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+
+	// Overflows are reset on every flush.
+	h.Clear()
+	g.Flush()
+
+	if found := h.Measures(); len(found) != 0 {
+		t.Error("overflows were reported again after a flush:", found)
+	}
+}
+
+func TestCardinalityGuardExpire(t *testing.T) {
+	h := &statstest.Handler{}
+	g := stats.NewCardinalityGuard(h, 1)
+	eng := stats.NewEngine("", g)
+
+	eng.Incr("requests", stats.T("path", "/a"))
+	g.Flush()
+
+	// The combination was seen since the previous flush, it is still tracked.
+	eng.Incr("requests", stats.T("path", "/b"))
+	g.Flush()
+
+	// The combination was idle between the last two flushes, it was removed
+	// and the new one takes its place.
+	eng.Incr("requests", stats.T("path", "/b"))
+	eng.Incr("requests", stats.T("path", "/a"))
+
+	paths := []string{}
+
+	for _, m := range h.Measures() {
+		if m.Name == "requests" {
+			paths = append(paths, m.Tags[0].Value)
+		}
+	}
+
+	expect := []string{"/a", stats.DefaultCardinalityOverflow, "/b", stats.DefaultCardinalityOverflow}
+
+	if len(paths) != len(expect) {
+		t.Fatal("bad measures:", paths)
+	}
+
+	for i := range expect {
+		if paths[i] != expect[i] {
+			t.Errorf("bad measure #%d: expected %s, found %s", i, expect[i], paths[i])
+		}
+	}
+}
+
+func TestCardinalityGuardDoesNotModifyMeasures(t *testing.T) {
+	g := stats.NewCardinalityGuard(stats.Discard, 1)
+	tags := []stats.Tag{stats.T("id", "1")}
+
+	g.HandleMeasures(time.Now(), stats.Measure{Name: "test", Tags: tags})
+
+	tags[0].Value = "2"
+	g.HandleMeasures(time.Now(), stats.Measure{Name: "test", Tags: tags})
+
+	if tags[0].Value != "2" {
+		t.Error("the guard modified the tags of the measure it received:", tags)
+	}
+}
+
+func BenchmarkCardinalityGuard(b *testing.B) {
+	g := stats.NewCardinalityGuard(stats.Discard, 100)
+	t := time.Now()
+	m := make([]stats.Measure, 1000)
+
+	for i := range m {
+		m[i] = stats.Measure{
+			Name:   "test.calls",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("id", strconv.Itoa(i))},
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i != b.N; i++ {
+		g.HandleMeasures(t, m[i%len(m)])
+	}
+}
@@ -6,6 +6,8 @@ import "time"
 //
 // Clocks are useful to measure the duration taken by sequential execution steps
 // and therefore aren't safe to be used concurrently by multiple goroutines.
+// Timers (see Engine.Start) are the alternative to use when the duration and
+// outcome of whole operations need to be reported.
 type Clock struct {
 	name  string
 	first time.Time
@@ -0,0 +1,33 @@
+package stats
+
+import "context"
+
+// ContextWithTags returns a copy of ctx carrying tags, merged with the tags
+// that ctx already carried. When a tag name is set multiple times, the value
+// passed to the innermost call wins.
+//
+// The tags are set on the measures produced by the context-aware methods of
+// engines (like IncrContext or ReportContext), which makes it possible to
+// set request-scoped tags (like a tenant or an endpoint) once and have them
+// inherited by all the metrics produced while serving the request.
+func ContextWithTags(ctx context.Context, tags ...Tag) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextTagsKey{}, mergeTags(concatTags(TagsFromContext(ctx), tags)))
+}
+
+// TagsFromContext returns the sorted list of tags carried by ctx, or nil if
+// ctx carries no tags.
+//
+// The returned slice is shared by all the users of the context, the program
+// must not modify it.
+func TagsFromContext(ctx context.Context) []Tag {
+	if ctx == nil {
+		return nil
+	}
+	tags, _ := ctx.Value(contextTagsKey{}).([]Tag)
+	return tags
+}
+
+type contextTagsKey struct{}
@@ -0,0 +1,64 @@
+package stats_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sniperkit/stats"
+)
+
+func TestContextWithTags(t *testing.T) {
+	ctx := context.Background()
+
+	if tags := stats.TagsFromContext(ctx); tags != nil {
+		t.Error("a context with no tags returned tags:", tags)
+	}
+
+	if c := stats.ContextWithTags(ctx); c != ctx {
+		t.Error("adding no tags must return the original context")
+	}
+
+	c1 := stats.ContextWithTags(ctx, stats.T("tenant", "acme"), stats.T("region", "us-west-2"))
+	c2 := stats.ContextWithTags(c1, stats.T("endpoint", "/users"))
+
+	if tags := stats.TagsFromContext(c1); !reflect.DeepEqual(tags, []stats.Tag{
+		stats.T("region", "us-west-2"),
+		stats.T("tenant", "acme"),
+	}) {
+		t.Error("bad tags:", tags)
+	}
+
+	if tags := stats.TagsFromContext(c2); !reflect.DeepEqual(tags, []stats.Tag{
+		stats.T("endpoint", "/users"),
+		stats.T("region", "us-west-2"),
+		stats.T("tenant", "acme"),
+	}) {
+		t.Error("bad tags:", tags)
+	}
+
+	if tags := stats.TagsFromContext(c1); len(tags) != 2 {
+		t.Error("the tags of the parent context were modified:", tags)
+	}
+}
+
+func TestContextWithTagsOverride(t *testing.T) {
+	c1 := stats.ContextWithTags(context.Background(), stats.T("table", "users"), stats.T("tenant", "acme"))
+	c2 := stats.ContextWithTags(c1, stats.T("table", "orders"))
+	c3 := stats.ContextWithTags(context.Background(), stats.T("a", "1"), stats.T("a", "2"))
+
+	if tags := stats.TagsFromContext(c2); !reflect.DeepEqual(tags, []stats.Tag{
+		stats.T("table", "orders"),
+		stats.T("tenant", "acme"),
+	}) {
+		t.Error("bad tags:", tags)
+	}
+
+	if tags := stats.TagsFromContext(c3); !reflect.DeepEqual(tags, []stats.Tag{stats.T("a", "2")}) {
+		t.Error("bad tags:", tags)
+	}
+
+	if tags := stats.TagsFromContext(c1); tags[0] != stats.T("table", "users") {
+		t.Error("the tags of the parent context were modified:", tags)
+	}
+}
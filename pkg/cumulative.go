@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// cumulativeTimeout is the amount of time after which the cumulative cache
+// forgets series that were not reported, a series reported again after being
+// forgotten establishes a new baseline.
+const cumulativeTimeout = 10 * time.Minute
+
+// cumulativeCache remembers the last values of the fields reporting cumulative
+// totals, to convert them to increments. Series are identified by the name of
+// their measure and field, and their tags.
+//
+// The values are kept in two generations, the current one holding the series
+// reported since the last rotation, and the previous one holding the series
+// reported during the period before. Series which were not reported for two
+// periods are discarded when the generations are rotated, which bounds the size
+// of the cache to the series actively reported by the program.
+type cumulativeCache struct {
+	mutex    sync.Mutex
+	current  map[string]Value
+	previous map[string]Value
+	rotated  time.Time
+	key      []byte
+}
+
+// cumulativeValues returns the cache of cumulative values associated with c,
+// which is created the first time it's needed because most metrics types don't
+// have cumulative fields.
+func (c *measureCache) cumulativeValues() *cumulativeCache {
+	if p := atomic.LoadPointer(&c.cumulative); p != nil {
+		return (*cumulativeCache)(p)
+	}
+
+	atomic.CompareAndSwapPointer(&c.cumulative, nil, unsafe.Pointer(&cumulativeCache{
+		current: make(map[string]Value),
+		rotated: time.Now(),
+	}))
+
+	return (*cumulativeCache)(atomic.LoadPointer(&c.cumulative))
+}
+
+// update replaces the values of the fields of m at the given indexes with the
+// increment since the last call for the same series. The first call for a
+// series has no previous value to compare with, it sets the fields to zero.
+func (c *cumulativeCache) update(m *Measure, fields []int) {
+	c.mutex.Lock()
+
+	if now := time.Now(); now.Sub(c.rotated) >= cumulativeTimeout {
+		c.previous, c.current = c.current, make(map[string]Value, len(c.current))
+		c.rotated = now
+	}
+
+	c.key = appendCumulativeKey(c.key[:0], m)
+	n := len(c.key)
+
+	for _, i := range fields {
+		f := &m.Fields[i]
+		c.key = append(c.key[:n], f.Name...)
+
+		last, ok := c.current[string(c.key)]
+
+		if !ok {
+			last, ok = c.previous[string(c.key)]
+			// The series is moved to the current generation, the entry of
+			// the previous one is not needed anymore.
+			delete(c.previous, string(c.key))
+		}
+
+		c.current[string(c.key)] = f.Value
+
+		if ok {
+			f.Value = deltaValue(last, f.Value)
+		} else {
+			f.Value.bits = 0
+		}
+	}
+
+	c.mutex.Unlock()
+}
+
+// appendCumulativeKey appends to b the part of the key of cumulative series
+// shared by all the fields of m, the key of a series is completed by appending
+// the name of its field.
+func appendCumulativeKey(b []byte, m *Measure) []byte {
+	b = append(b, m.Name...)
+	b = append(b, 0)
+
+	for _, t := range m.Tags {
+		b = append(b, t.Name...)
+		b = append(b, 0)
+		b = append(b, t.Value...)
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// deltaValue returns the increment from last to value. If value is lower than
+// last the counter was reset, and value is returned.
+func deltaValue(last Value, value Value) Value {
+	if last.typ != value.typ {
+		return value
+	}
+
+	switch value.typ {
+	case Int, Duration:
+		if v, l := int64(value.bits), int64(last.bits); v >= l {
+			value.bits = uint64(v - l)
+		}
+
+	case Uint:
+		if value.bits >= last.bits {
+			value.bits -= last.bits
+		}
+
+	case Float:
+		if v, l := math.Float64frombits(value.bits), math.Float64frombits(last.bits); v >= l {
+			value.bits = math.Float64bits(v - l)
+		}
+	}
+
+	return value
+}
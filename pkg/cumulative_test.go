@@ -0,0 +1,71 @@
+package stats_test
+
+import (
+	"testing"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+type cumulativeMetrics struct {
+	total   uint64  `metric:"total"   type:"counter" source:"cumulative"`
+	seconds float64 `metric:"seconds" type:"counter" source:"cumulative"`
+	calls   int     `metric:"calls"   type:"counter"`
+	cpu     []int64 `metric:"cpu"     type:"counter" source:"cumulative" tag:"cpu"`
+	name    string  `tag:"name"`
+}
+
+func TestEngineReportCumulative(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h)
+
+	reports := []cumulativeMetrics{
+		{total: 10, seconds: 1.5, calls: 1, cpu: []int64{100, 200}, name: "A"},
+		{total: 10, seconds: 1.5, calls: 1, cpu: []int64{100, 200}, name: "B"},
+		{total: 15, seconds: 2.5, calls: 1, cpu: []int64{150, 210}, name: "A"},
+		{total: 3, seconds: 3, calls: 1, cpu: []int64{160, 220}, name: "A"}, // reset
+	}
+
+	// The measures of the cpu slice are reported first, then the fields of
+	// the struct.
+	expect := [][]interface{}{
+		{int64(0), int64(0), uint64(0), 0.0, int64(1)},
+		{int64(0), int64(0), uint64(0), 0.0, int64(1)},
+		{int64(50), int64(10), uint64(5), 1.0, int64(1)},
+		{int64(10), int64(10), uint64(3), 0.5, int64(1)},
+	}
+
+	for i, m := range reports {
+		h.Clear()
+		eng.Report(&m)
+
+		found := []interface{}{}
+
+		for _, m := range h.Measures() {
+			for _, f := range m.Fields {
+				found = append(found, f.Value.Interface())
+			}
+		}
+
+		if len(found) != len(expect[i]) {
+			t.Errorf("report #%d: bad values: %v", i, found)
+			continue
+		}
+
+		for j := range found {
+			if found[j] != expect[i][j] {
+				t.Errorf("report #%d: bad value #%d: expected %v but found %v", i, j, expect[i][j], found[j])
+			}
+		}
+	}
+}
+
+func TestMakeMeasuresCumulative(t *testing.T) {
+	m := cumulativeMetrics{total: 10}
+
+	for i := 0; i != 2; i++ {
+		if v := stats.MakeMeasures("test", m)[0].Fields[0].Value.Uint(); v != 10 {
+			t.Error("MakeMeasures did not return the total of a cumulative field:", v)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package stats
 
 import (
+	"context"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 // An Engine carries the context for producing metrics, it is configured by
@@ -14,9 +18,13 @@ import (
 //
 // The program must not modify the engine's handler, prefix, or tags after it
 // started using it (by calling some of its methods). If changes need to be made
-// new engines must be created by calls to
+// new engines must be created by calls to WithPrefix or WithTags, or the
+// handler must be replaced by calls to SwapHandler or Register.
 type Engine struct {
 	// The measure handler that the engine forwards measures to.
+	//
+	// The field is not used anymore once SwapHandler or Register were called
+	// on the engine or on any of the engines sharing its handler.
 	Handler Handler
 
 	// A prefix set on all metric names produced by the engine.
@@ -29,7 +37,33 @@ type Engine struct {
 	// that manipulates this field directly has to respect this requirement.
 	Tags []Tag
 
+	// The rate at which counters, histograms and distributions produced by the
+	// engine are sampled, a value between 0 and 1. Sampled fields carry their
+	// rate so handlers can scale the totals they compute.
+	//
+	// If zero or one, the engine doesn't sample the measures it produces.
+	SampleRate float64
+
 	cache measureCache
+
+	// The handler shared by the engine and the engines derived from it, which
+	// is created the first time it's needed (a *handlerRef).
+	ref unsafe.Pointer
+}
+
+// handlerRef holds the handler that replaced the Handler field of engines. The
+// handler is stored behind an atomic pointer so it can be swapped while other
+// goroutines are producing measures.
+type handlerRef struct {
+	handler unsafe.Pointer // *Handler
+}
+
+func (r *handlerRef) load() *Handler {
+	return (*Handler)(atomic.LoadPointer(&r.handler))
+}
+
+func (r *handlerRef) compareAndSwap(old *Handler, handler Handler) bool {
+	return atomic.CompareAndSwapPointer(&r.handler, unsafe.Pointer(old), unsafe.Pointer(&handler))
 }
 
 // NewEngine creates and returns a new engine configured with prefix, handler,
@@ -38,22 +72,76 @@ func NewEngine(prefix string, handler Handler, tags ...Tag) *Engine {
 	return &Engine{
 		Handler: handler,
 		Prefix:  prefix,
-		Tags:    SortTags(copyTags(tags)),
+		Tags:    mergeTags(copyTags(tags)),
 	}
 }
 
-// Register adds handler to eng.
+// Register adds handler to eng. The change is visible to every engine sharing
+// the handler of eng, see SwapHandler.
 func (eng *Engine) Register(handler Handler) {
-	if eng.Handler == Discard {
-		eng.Handler = handler
-	} else {
-		eng.Handler = MultiHandler(eng.Handler, handler)
-	}
+	eng.updateHandler(func(old Handler) Handler {
+		if old == nil || old == Discard {
+			return handler
+		}
+		return MultiHandler(old, handler)
+	})
+}
+
+// SwapHandler replaces the handler of eng, and of all the engines sharing it
+// (created by WithPrefix, WithTags, or WithSampleRate on eng or the engine it
+// was derived from), and returns the previous one.
+//
+// The method is safe to call while other goroutines are using the engines, it
+// is intended to be used when a program reloads its configuration or when a
+// backend becomes available after the program started. The caller is in charge
+// of flushing or closing the previous handler.
+func (eng *Engine) SwapHandler(handler Handler) Handler {
+	return eng.updateHandler(func(Handler) Handler { return handler })
 }
 
 // Flush flushes eng's handler (if it implements the Flusher interface).
 func (eng *Engine) Flush() {
-	flush(eng.Handler)
+	flush(eng.handler())
+}
+
+// handler returns the handler that the engine forwards measures to.
+func (eng *Engine) handler() Handler {
+	if ref := (*handlerRef)(atomic.LoadPointer(&eng.ref)); ref != nil {
+		if h := ref.load(); h != nil {
+			return *h
+		}
+	}
+	return eng.Handler
+}
+
+// handlerRef returns the handler shared by eng and the engines derived from it.
+func (eng *Engine) handlerRef() *handlerRef {
+	if ref := atomic.LoadPointer(&eng.ref); ref != nil {
+		return (*handlerRef)(ref)
+	}
+	atomic.CompareAndSwapPointer(&eng.ref, nil, unsafe.Pointer(&handlerRef{}))
+	return (*handlerRef)(atomic.LoadPointer(&eng.ref))
+}
+
+// updateHandler atomically replaces the handler of eng with the one returned by
+// update, and returns the previous one.
+func (eng *Engine) updateHandler(update func(Handler) Handler) Handler {
+	ref := eng.handlerRef()
+
+	for {
+		var old Handler
+		ptr := ref.load()
+
+		if ptr != nil {
+			old = *ptr
+		} else {
+			old = eng.Handler
+		}
+
+		if ref.compareAndSwap(ptr, update(old)) {
+			return old
+		}
+	}
 }
 
 // WithPrefix returns a copy of the engine with prefix appended to eng's current
@@ -61,9 +149,11 @@ func (eng *Engine) Flush() {
 // argument. Both eng and the returned engine share the same handler.
 func (eng *Engine) WithPrefix(prefix string, tags ...Tag) *Engine {
 	return &Engine{
-		Handler: eng.Handler,
-		Prefix:  eng.makeName(prefix),
-		Tags:    eng.makeTags(tags),
+		Handler:    eng.Handler,
+		Prefix:     eng.makeName(prefix),
+		Tags:       eng.makeTags(tags),
+		SampleRate: eng.SampleRate,
+		ref:        unsafe.Pointer(eng.handlerRef()),
 	}
 }
 
@@ -74,6 +164,14 @@ func (eng *Engine) WithTags(tags ...Tag) *Engine {
 	return eng.WithPrefix("", tags...)
 }
 
+// WithSampleRate returns a copy of the engine with its sample rate set to rate.
+// Both eng and the returned engine share the same handler.
+func (eng *Engine) WithSampleRate(rate float64) *Engine {
+	cpy := eng.WithPrefix("")
+	cpy.SampleRate = rate
+	return cpy
+}
+
 // Incr increments by one the counter identified by name and tags.
 func (eng *Engine) Incr(name string, tags ...Tag) {
 	eng.Add(name, 1, tags...)
@@ -81,17 +179,71 @@ func (eng *Engine) Incr(name string, tags ...Tag) {
 
 // Add increments by value the counter identified by name and tags.
 func (eng *Engine) Add(name string, value interface{}, tags ...Tag) {
-	eng.measure(name, value, Counter, tags...)
+	eng.measure(name, value, Counter, eng.SampleRate, tags...)
+}
+
+// IncrSampled is like Incr but only reports the increment with a probability
+// of rate, overriding the sample rate of the engine.
+func (eng *Engine) IncrSampled(name string, rate float64, tags ...Tag) {
+	eng.AddSampled(name, 1, rate, tags...)
+}
+
+// AddSampled is like Add but only reports the increment with a probability of
+// rate, overriding the sample rate of the engine.
+func (eng *Engine) AddSampled(name string, value interface{}, rate float64, tags ...Tag) {
+	eng.measure(name, value, Counter, rate, tags...)
 }
 
 // Set sets to value the gauge identified by name and tags.
 func (eng *Engine) Set(name string, value interface{}, tags ...Tag) {
-	eng.measure(name, value, Gauge, tags...)
+	eng.measure(name, value, Gauge, 1, tags...)
 }
 
 // Observe reports value for the histogram identified by name and tags.
 func (eng *Engine) Observe(name string, value interface{}, tags ...Tag) {
-	eng.measure(name, value, Histogram, tags...)
+	eng.measure(name, value, Histogram, eng.SampleRate, tags...)
+}
+
+// ObserveSampled is like Observe but only reports the value with a probability
+// of rate, overriding the sample rate of the engine.
+func (eng *Engine) ObserveSampled(name string, value interface{}, rate float64, tags ...Tag) {
+	eng.measure(name, value, Histogram, rate, tags...)
+}
+
+// Distribute reports value for the distribution identified by name and tags.
+func (eng *Engine) Distribute(name string, value interface{}, tags ...Tag) {
+	eng.measure(name, value, Distribution, eng.SampleRate, tags...)
+}
+
+// Unique reports value as a member of the set identified by name and tags, the
+// set counts the number of distinct values it received. Strings are reported by
+// their hash, see HashString.
+func (eng *Engine) Unique(name string, value interface{}, tags ...Tag) {
+	if s, ok := value.(string); ok {
+		value = HashString(s)
+	}
+	eng.measure(name, value, UniqueSet, 1, tags...)
+}
+
+// IncrContext is like Incr but also sets the tags carried by ctx on the measure.
+func (eng *Engine) IncrContext(ctx context.Context, name string, tags ...Tag) {
+	eng.AddContext(ctx, name, 1, tags...)
+}
+
+// AddContext is like Add but also sets the tags carried by ctx on the measure.
+func (eng *Engine) AddContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureContext(ctx, name, value, Counter, eng.SampleRate, tags...)
+}
+
+// SetContext is like Set but also sets the tags carried by ctx on the measure.
+func (eng *Engine) SetContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureContext(ctx, name, value, Gauge, 1, tags...)
+}
+
+// ObserveContext is like Observe but also sets the tags carried by ctx on the
+// measure.
+func (eng *Engine) ObserveContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureContext(ctx, name, value, Histogram, eng.SampleRate, tags...)
 }
 
 // Clock returns a new clock identified by name and tags.
@@ -108,21 +260,27 @@ func (eng *Engine) Clock(name string, tags ...Tag) *Clock {
 	}
 }
 
-func (eng *Engine) measure(name string, value interface{}, ftype FieldType, tags ...Tag) {
+func (eng *Engine) measure(name string, value interface{}, ftype FieldType, rate float64, tags ...Tag) {
+	if !sample(rate) {
+		return
+	}
+
 	name, field := splitMeasureField(name)
 	mp := measureArrayPool.Get().(*[1]Measure)
 
 	m := &(*mp)[0]
 	m.Name = eng.makeName(name) // TODO: figure out how to optimize this
-	m.Fields = append(m.Fields[:0], MakeField(field, value, ftype))
+	m.Fields = append(m.Fields[:0], MakeField(field, value, ftype).WithRate(rate))
 	m.Tags = append(m.Tags[:0], eng.Tags...)
 	m.Tags = append(m.Tags, tags...)
 
-	if len(tags) != 0 && !TagsAreSorted(m.Tags) {
-		SortTags(m.Tags)
+	if len(tags) != 0 {
+		// The tags of the engine come first so they are overridden by the
+		// tags passed explicitly.
+		m.Tags = mergeTags(m.Tags)
 	}
 
-	eng.Handler.HandleMeasures(time.Now(), (*mp)[:]...)
+	eng.handler().HandleMeasures(time.Now(), (*mp)[:]...)
 
 	for i := range m.Fields {
 		m.Fields[i] = Field{}
@@ -136,12 +294,37 @@ func (eng *Engine) measure(name string, value interface{}, ftype FieldType, tags
 	measureArrayPool.Put(mp)
 }
 
+// sample returns true if a measure sampled at rate should be reported.
+func sample(rate float64) bool {
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate
+}
+
+func (eng *Engine) measureContext(ctx context.Context, name string, value interface{}, ftype FieldType, rate float64, tags ...Tag) {
+	ctxTags := TagsFromContext(ctx)
+
+	if len(ctxTags) == 0 {
+		eng.measure(name, value, ftype, rate, tags...)
+		return
+	}
+
+	// The tags passed explicitly override the tags of the context.
+	tb := tagsPool.Get().(*tagsBuffer)
+	tb.append(ctxTags...)
+	tb.append(tags...)
+	tb.tags = mergeTags(tb.tags)
+
+	eng.measure(name, value, ftype, rate, tb.tags...)
+
+	tb.reset()
+	tagsPool.Put(tb)
+}
+
 func (eng *Engine) makeName(name string) string {
 	return concat(eng.Prefix, name)
 }
 
 func (eng *Engine) makeTags(tags []Tag) []Tag {
-	return SortTags(concatTags(eng.Tags, tags))
+	return mergeTags(concatTags(eng.Tags, tags))
 }
 
 var measureArrayPool = sync.Pool{
@@ -163,10 +346,11 @@ func (eng *Engine) ReportAt(time time.Time, metrics interface{}, tags ...Tag) {
 		// fast path for the common case where there are no dynamic tags
 		tags = eng.Tags
 	} else {
+		// The tags of the engine are overridden by the tags passed explicitly.
 		tb = tagsPool.Get().(*tagsBuffer)
-		tb.append(tags...)
 		tb.append(eng.Tags...)
-		tb.sort()
+		tb.append(tags...)
+		tb.tags = mergeTags(tb.tags)
 		tags = tb.tags
 	}
 
@@ -174,7 +358,7 @@ func (eng *Engine) ReportAt(time time.Time, metrics interface{}, tags ...Tag) {
 	mb.measures = appendMeasures(mb.measures[:0], &eng.cache, eng.Prefix, reflect.ValueOf(metrics), tags...)
 
 	ms := mb.measures
-	eng.Handler.HandleMeasures(time, ms...)
+	eng.handler().HandleMeasures(time, ms...)
 
 	for i := range ms {
 		ms[i].reset()
@@ -188,6 +372,59 @@ func (eng *Engine) ReportAt(time time.Time, metrics interface{}, tags ...Tag) {
 	measurePool.Put(mb)
 }
 
+// ReportContext is like Report but also sets the tags carried by ctx on the
+// measures.
+func (eng *Engine) ReportContext(ctx context.Context, metrics interface{}, tags ...Tag) {
+	eng.ReportAtContext(ctx, time.Now(), metrics, tags...)
+}
+
+// ReportAtContext is like ReportAt but also sets the tags carried by ctx on the
+// measures.
+func (eng *Engine) ReportAtContext(ctx context.Context, time time.Time, metrics interface{}, tags ...Tag) {
+	ctxTags := TagsFromContext(ctx)
+
+	if len(ctxTags) == 0 {
+		eng.ReportAt(time, metrics, tags...)
+		return
+	}
+
+	// The tags passed explicitly override the tags of the context.
+	tb := tagsPool.Get().(*tagsBuffer)
+	tb.append(ctxTags...)
+	tb.append(tags...)
+	tb.tags = mergeTags(tb.tags)
+
+	eng.ReportAt(time, metrics, tb.tags...)
+
+	tb.reset()
+	tagsPool.Put(tb)
+}
+
+// RegisterType validates the type of metrics, as ValidateMetrics does, and
+// prepares eng to report values of that type, so the first calls to Report
+// don't have to pay for it.
+func (eng *Engine) RegisterType(metrics interface{}) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+
+	typ := metricsElemType(reflect.TypeOf(metrics))
+
+	if _, ok := eng.cache.lookup(typ); !ok {
+		eng.cache.set(typ, makeMeasureFuncs(typ, eng.Prefix))
+	}
+
+	return nil
+}
+
+// MustRegisterType is like RegisterType but panics if the type of metrics is
+// invalid. It is intended to be called during the initialization of programs.
+func (eng *Engine) MustRegisterType(metrics interface{}) {
+	if err := eng.RegisterType(metrics); err != nil {
+		panic(err)
+	}
+}
+
 // DefaultEngine is the engine used by global helper functions.
 var DefaultEngine = NewEngine(progname(), Discard)
 
@@ -196,6 +433,12 @@ func Register(handler Handler) {
 	DefaultEngine.Register(handler)
 }
 
+// SwapHandler replaces the handler of the default engine, and of the engines
+// derived from it, and returns the previous one.
+func SwapHandler(handler Handler) Handler {
+	return DefaultEngine.SwapHandler(handler)
+}
+
 // Flush flushes the default engine.
 func Flush() {
 	DefaultEngine.Flush()
@@ -236,6 +479,58 @@ func Observe(name string, value interface{}, tags ...Tag) {
 	DefaultEngine.Observe(name, value, tags...)
 }
 
+// IncrSampled increments by one the counter identified by name and tags, with
+// a probability of rate.
+func IncrSampled(name string, rate float64, tags ...Tag) {
+	DefaultEngine.IncrSampled(name, rate, tags...)
+}
+
+// AddSampled increments by value the counter identified by name and tags, with
+// a probability of rate.
+func AddSampled(name string, value interface{}, rate float64, tags ...Tag) {
+	DefaultEngine.AddSampled(name, value, rate, tags...)
+}
+
+// ObserveSampled reports value for the histogram identified by name and tags,
+// with a probability of rate.
+func ObserveSampled(name string, value interface{}, rate float64, tags ...Tag) {
+	DefaultEngine.ObserveSampled(name, value, rate, tags...)
+}
+
+// Distribute reports value for the distribution identified by name and tags.
+func Distribute(name string, value interface{}, tags ...Tag) {
+	DefaultEngine.Distribute(name, value, tags...)
+}
+
+// Unique reports value as a member of the set identified by name and tags.
+func Unique(name string, value interface{}, tags ...Tag) {
+	DefaultEngine.Unique(name, value, tags...)
+}
+
+// IncrContext increments by one the counter identified by name, tags, and the
+// tags carried by ctx.
+func IncrContext(ctx context.Context, name string, tags ...Tag) {
+	DefaultEngine.IncrContext(ctx, name, tags...)
+}
+
+// AddContext increments by value the counter identified by name, tags, and the
+// tags carried by ctx.
+func AddContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.AddContext(ctx, name, value, tags...)
+}
+
+// SetContext sets to value the gauge identified by name, tags, and the tags
+// carried by ctx.
+func SetContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.SetContext(ctx, name, value, tags...)
+}
+
+// ObserveContext reports value for the histogram identified by name, tags, and
+// the tags carried by ctx.
+func ObserveContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.ObserveContext(ctx, name, value, tags...)
+}
+
 // Report is a helper function that delegates to DefaultEngine.
 func Report(metrics interface{}, tags ...Tag) {
 	DefaultEngine.Report(metrics, tags...)
@@ -246,6 +541,26 @@ func ReportAt(time time.Time, metrics interface{}, tags ...Tag) {
 	DefaultEngine.ReportAt(time, metrics, tags...)
 }
 
+// ReportContext is a helper function that delegates to DefaultEngine.
+func ReportContext(ctx context.Context, metrics interface{}, tags ...Tag) {
+	DefaultEngine.ReportContext(ctx, metrics, tags...)
+}
+
+// ReportAtContext is a helper function that delegates to DefaultEngine.
+func ReportAtContext(ctx context.Context, time time.Time, metrics interface{}, tags ...Tag) {
+	DefaultEngine.ReportAtContext(ctx, time, metrics, tags...)
+}
+
+// RegisterType is a helper function that delegates to DefaultEngine.
+func RegisterType(metrics interface{}) error {
+	return DefaultEngine.RegisterType(metrics)
+}
+
+// MustRegisterType is a helper function that delegates to DefaultEngine.
+func MustRegisterType(metrics interface{}) {
+	DefaultEngine.MustRegisterType(metrics)
+}
+
 func progname() (name string) {
 	if args := os.Args; len(args) != 0 {
 		name = filepath.Base(args[0])
@@ -1,6 +1,7 @@
 package stats_test
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"reflect"
@@ -32,6 +33,18 @@ func TestEngine(t *testing.T) {
 			scenario: "calling Engine.Flush calls Flush the handler's Flush method",
 			function: testEngineFlush,
 		},
+		{
+			scenario: "calling Engine.SwapHandler replaces the handler of the engine and of the engines derived from it",
+			function: testEngineSwapHandler,
+		},
+		{
+			scenario: "calling Engine.Register adds the handler to the engine and to the engines derived from it",
+			function: testEngineRegister,
+		},
+		{
+			scenario: "calling Engine.SwapHandler while other goroutines produce measures doesn't lose any of them",
+			function: testEngineSwapHandlerConcurrent,
+		},
 		{
 			scenario: "calling Engine.Incr produces a counter increment of one",
 			function: testEngineIncr,
@@ -48,6 +61,34 @@ func TestEngine(t *testing.T) {
 			scenario: "calling Engine.Observe produces the expected histogram value",
 			function: testEngineObserve,
 		},
+		{
+			scenario: "calling Engine.Distribute produces the expected distribution value",
+			function: testEngineDistribute,
+		},
+		{
+			scenario: "calling Engine.Unique produces the expected set value",
+			function: testEngineUnique,
+		},
+		{
+			scenario: "calling Engine.AddSampled produces counters carrying the sample rate",
+			function: testEngineSampled,
+		},
+		{
+			scenario: "setting Engine.SampleRate samples counters and histograms but not gauges",
+			function: testEngineSampleRate,
+		},
+		{
+			scenario: "calling Engine.IncrContext merges the context tags into the measure tags",
+			function: testEngineIncrContext,
+		},
+		{
+			scenario: "calling Engine.ReportContext merges the context tags into the measure tags",
+			function: testEngineReportContext,
+		},
+		{
+			scenario: "the tags passed explicitly or set on the context override the tags of the engine with the same name",
+			function: testEngineOverrideTags,
+		},
 		{
 			scenario: "calling Engine.Report produces the expected measures",
 			function: testEngineReport,
@@ -122,6 +163,74 @@ func testEngineFlush(t *testing.T, eng *stats.Engine) {
 	}
 }
 
+func testEngineSwapHandler(t *testing.T, eng *stats.Engine) {
+	e2 := eng.WithPrefix("subtest")
+	h := &statstest.Handler{}
+
+	if old := eng.SwapHandler(h); old != eng.Handler {
+		t.Error("bad previous handler:", old)
+	}
+
+	eng.Incr("A")
+	e2.Incr("B")
+	e2.WithTags(stats.T("a", "1")).Incr("C")
+	e2.Flush()
+
+	if n := len(measures(t, eng)); n != 0 {
+		t.Error("the previous handler received measures after being replaced:", n)
+	}
+
+	found := h.Measures()
+
+	if len(found) != 3 || found[0].Name != "test.A" || found[1].Name != "test.subtest.B" || found[2].Name != "test.subtest.C" {
+		t.Error("bad measures:", found)
+	}
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func testEngineRegister(t *testing.T, eng *stats.Engine) {
+	e2 := eng.WithTags(stats.T("a", "1"))
+	h := &statstest.Handler{}
+
+	e2.Register(h)
+	eng.Incr("A")
+
+	if n := len(measures(t, eng)); n != 1 {
+		t.Error("bad number of measures received by the original handler:", n)
+	}
+
+	if n := len(h.Measures()); n != 1 {
+		t.Error("bad number of measures received by the registered handler:", n)
+	}
+}
+
+func testEngineSwapHandlerConcurrent(t *testing.T, eng *stats.Engine) {
+	h1 := &statstest.Handler{}
+	h2 := &statstest.Handler{}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for i := 0; i != 1000; i++ {
+			eng.WithTags(stats.T("i", "1")).Incr("A")
+		}
+	}()
+
+	for i := 0; i != 100; i++ {
+		eng.SwapHandler(h1)
+		eng.SwapHandler(h2)
+	}
+
+	<-done
+
+	if n := len(measures(t, eng)) + len(h1.Measures()) + len(h2.Measures()); n != 1000 {
+		t.Error("bad number of measures:", n)
+	}
+}
+
 func testEngineIncr(t *testing.T, eng *stats.Engine) {
 	eng.Incr("measure.count")
 	eng.Incr("measure.count", stats.T("type", "testing"))
@@ -194,6 +303,193 @@ func testEngineObserve(t *testing.T, eng *stats.Engine) {
 	)
 }
 
+func testEngineDistribute(t *testing.T, eng *stats.Engine) {
+	eng.Distribute("measure.size", 42)
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.size",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Distribution)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+	)
+}
+
+func testEngineUnique(t *testing.T, eng *stats.Engine) {
+	eng.Unique("measure.users", 42)
+	eng.Unique("measure.users", "alice")
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.users",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.UniqueSet)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.users",
+			Fields: []stats.Field{stats.MakeField("", stats.HashString("alice"), stats.UniqueSet)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+	)
+}
+
+func testEngineSampled(t *testing.T, eng *stats.Engine) {
+	eng.AddSampled("measure.count", 2, 1)
+	eng.ObserveSampled("measure.size", 42, 0)
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 2, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.size",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Histogram)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+	)
+
+	h := eng.Handler.(*statstest.Handler)
+	h.Clear()
+
+	for i := 0; i != 1000; i++ {
+		eng.IncrSampled("measure.count", 0.5)
+	}
+
+	found := measures(t, eng)
+
+	if n := len(found); n < 400 || n > 600 {
+		t.Error("bad number of sampled measures:", n)
+	}
+
+	for _, m := range found {
+		if rate := m.Fields[0].Rate(); rate != 0.5 {
+			t.Error("bad sample rate:", rate)
+			break
+		}
+	}
+}
+
+func testEngineSampleRate(t *testing.T, eng *stats.Engine) {
+	e2 := eng.WithSampleRate(0.25)
+
+	if e2.WithTags().SampleRate != 0.25 {
+		t.Error("the sample rate was not inherited by the sub-engine")
+	}
+
+	for i := 0; i != 1000; i++ {
+		e2.Incr("measure.count")
+		e2.Observe("measure.size", 1)
+		e2.Set("measure.level", 1)
+	}
+
+	counts := map[stats.FieldType]int{}
+
+	for _, m := range measures(t, eng) {
+		f := m.Fields[0]
+		counts[f.Type()]++
+
+		if rate := f.Rate(); (f.Type() == stats.Gauge) != (rate == 1) {
+			t.Errorf("bad sample rate of %s field: %g", f.Type(), rate)
+			break
+		}
+	}
+
+	if n := counts[stats.Gauge]; n != 1000 {
+		t.Error("gauges must not be sampled:", n)
+	}
+
+	for _, ftype := range []stats.FieldType{stats.Counter, stats.Histogram} {
+		if n := counts[ftype]; n < 150 || n > 350 {
+			t.Errorf("bad number of sampled %s measures: %d", ftype, n)
+		}
+	}
+}
+
+func testEngineIncrContext(t *testing.T, eng *stats.Engine) {
+	ctx := stats.ContextWithTags(context.Background(), stats.T("tenant", "acme"))
+	eng.IncrContext(ctx, "measure.count", stats.T("type", "testing"))
+	eng.IncrContext(context.Background(), "measure.count")
+	eng.IncrContext(ctx, "measure.count", stats.T("tenant", "other"))
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "acme"), stats.T("type", "testing")},
+		},
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "other")},
+		},
+	)
+}
+
+func testEngineReportContext(t *testing.T, eng *stats.Engine) {
+	m := struct {
+		Count int `metric:"count" type:"counter"`
+	}{42}
+
+	ctx := stats.ContextWithTags(context.Background(), stats.T("tenant", "acme"))
+	eng.ReportContext(ctx, m, stats.T("type", "testing"))
+	eng.ReportContext(ctx, m, stats.T("tenant", "other"))
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test",
+			Fields: []stats.Field{stats.MakeField("count", 42, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "acme"), stats.T("type", "testing")},
+		},
+		stats.Measure{
+			Name:   "test",
+			Fields: []stats.Field{stats.MakeField("count", 42, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "other")},
+		},
+	)
+}
+
+func testEngineOverrideTags(t *testing.T, eng *stats.Engine) {
+	m := struct {
+		Count int `metric:"count" type:"counter"`
+	}{42}
+
+	ctx := stats.ContextWithTags(context.Background(), stats.T("service", "context-service"))
+	eng.IncrContext(ctx, "measure.count")
+	eng.Incr("measure.count", stats.T("service", "other-service"))
+	eng.Report(m, stats.T("service", "other-service"))
+	eng.WithTags(stats.T("service", "other-service")).Incr("measure.count")
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "context-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "other-service")},
+		},
+		stats.Measure{
+			Name:   "test",
+			Fields: []stats.Field{stats.MakeField("count", 42, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "other-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "other-service")},
+		},
+	)
+}
+
 func testEngineReport(t *testing.T, eng *stats.Engine) {
 	m := struct {
 		Count int `metric:"count" type:"counter"`
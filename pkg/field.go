@@ -17,9 +17,46 @@ func MakeField(name string, value interface{}, ftype FieldType) Field {
 
 // Type returns the type of f.
 func (f Field) Type() FieldType {
-	return FieldType(f.Value.pad)
+	return FieldType(f.Value.pad & fieldTypeMask)
 }
 
+// Rate returns the sample rate of f, which is a value between 0 and 1. Fields
+// that were not sampled have a rate of 1.
+//
+// A field with a rate of 0.1 represents one of every 10 values that the program
+// produced, handlers that compute totals (like counter sums or histogram
+// counts) must scale the value of the field by 1/rate.
+func (f Field) Rate() float64 {
+	if r := uint32(f.Value.pad) >> fieldRateShift; r != 0 {
+		return float64(r) / fieldRateScale
+	}
+	return 1
+}
+
+// WithRate returns a copy of f with its sample rate set to rate. Rates are
+// recorded with a precision of one millionth, values that are not between 0
+// and 1 (exclusive) mean that the field was not sampled.
+func (f Field) WithRate(rate float64) Field {
+	var r uint32
+
+	if rate > 0 && rate < 1 {
+		if r = uint32(rate*fieldRateScale + 0.5); r == 0 {
+			r = 1
+		}
+	}
+
+	f.Value.pad = int32(uint32(f.Value.pad)&fieldTypeMask | r<<fieldRateShift)
+	return f
+}
+
+const (
+	// The field type occupies the low bits of the value's padding space, the
+	// sample rate is stored in the high bits as a number of millionths.
+	fieldTypeMask  = 0xff
+	fieldRateShift = 8
+	fieldRateScale = 1e6
+)
+
 func (f *Field) setType(t FieldType) {
 	// We pack the field type into the value's padding space to make copies and
 	// assignments of fields more time efficent.
@@ -32,11 +69,17 @@ func (f *Field) setType(t FieldType) {
 	// BenchmarkAssign32BytesStruct-4   	2000000000	         0.31 ns/op
 	//
 	// There's an order of magnitude difference, so the optimization is worth it.
-	f.Value.pad = int32(t)
+	f.Value.pad = int32(uint32(f.Value.pad)&^fieldTypeMask | uint32(t)&fieldTypeMask)
 }
 
 func (f Field) String() string {
-	return f.Type().String() + ":" + f.Name + "=" + f.Value.String()
+	s := f.Type().String() + ":" + f.Name + "=" + f.Value.String()
+
+	if rate := f.Rate(); rate != 1 {
+		s += "@" + strconv.FormatFloat(rate, 'g', -1, 64)
+	}
+
+	return s
 }
 
 // FieldType is an enumeration of the different metric types that may be set on
@@ -53,6 +96,18 @@ const (
 
 	// Histogram represents metrics to observe the distribution of values.
 	Histogram
+
+	// Distribution represents metrics to observe the distribution of values
+	// across all the hosts reporting them. Unlike histograms, which backends
+	// may summarize on each host, distributions are meant to be aggregated
+	// globally (like the "d" metrics of dogstatsd). Backends that don't have
+	// this concept treat distributions like histograms.
+	Distribution
+
+	// UniqueSet represents metrics counting the number of distinct values that
+	// were observed (like the "s" metrics of dogstatsd). Backends that
+	// aggregate metrics estimate the count with a HyperLogLog.
+	UniqueSet
 )
 
 func (t FieldType) String() string {
@@ -63,6 +118,10 @@ func (t FieldType) String() string {
 		return "gauge"
 	case Histogram:
 		return "histogram"
+	case Distribution:
+		return "distribution"
+	case UniqueSet:
+		return "set"
 	}
 	return ""
 }
@@ -75,6 +134,10 @@ func (t FieldType) GoString() string {
 		return "stats.Gauge"
 	case Histogram:
 		return "stats.Histogram"
+	case Distribution:
+		return "stats.Distribution"
+	case UniqueSet:
+		return "stats.UniqueSet"
 	default:
 		return "stats.FieldType(" + strconv.Itoa(int(t)) + ")"
 	}
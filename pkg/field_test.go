@@ -10,6 +10,45 @@ func TestFieldSize(t *testing.T) {
 	t.Log("field size:", size)
 }
 
+func TestFieldRate(t *testing.T) {
+	tests := []struct {
+		rate   float64
+		expect float64
+	}{
+		{rate: 0, expect: 1},
+		{rate: 1, expect: 1},
+		{rate: 2, expect: 1},
+		{rate: -1, expect: 1},
+		{rate: 0.5, expect: 0.5},
+		{rate: 0.1, expect: 0.1},
+		{rate: 0.001, expect: 0.001},
+		{rate: 1e-9, expect: 1e-6},
+	}
+
+	for _, test := range tests {
+		f := MakeField("count", 1, Histogram).WithRate(test.rate)
+
+		if rate := f.Rate(); rate != test.expect {
+			t.Errorf("bad rate for %g: expected %g, found %g", test.rate, test.expect, rate)
+		}
+
+		if ftype := f.Type(); ftype != Histogram {
+			t.Errorf("setting the rate changed the field type to %s", ftype)
+		}
+	}
+
+	f := MakeField("count", 1, Counter).WithRate(0.5)
+	f.setType(Gauge)
+
+	if rate := f.Rate(); rate != 0.5 {
+		t.Error("setting the type changed the field rate to", rate)
+	}
+
+	if s := f.String(); s != "gauge:count=1@0.5" {
+		t.Error("bad string representation:", s)
+	}
+}
+
 func BenchmarkAssign40BytesStruct(b *testing.B) {
 	type S struct {
 		a string
@@ -0,0 +1,166 @@
+package stats
+
+import "time"
+
+// CounterHandle is a pre-bound counter, created by calling the Counter method of
+// an engine.
+//
+// Handles resolve the name, field and tags of the measures they produce once,
+// when they are created, so the methods reporting values don't have to split
+// the name, prefix it or sort the tags on every call. Programs that report the
+// same metrics in hot code paths should prefer handles over the string-based
+// methods of engines. The methods accepting values of any type convert them
+// to interfaces, which may allocate when the compiler cannot keep the values on
+// the stack, the typed methods (like AddInt or ObserveDuration) never do.
+//
+// Handles are safe to use concurrently from multiple goroutines.
+type CounterHandle struct {
+	handle
+}
+
+// Counter returns a handle to the counter identified by name and tags. Like
+// with other methods of engines, name may be of the form "measure:field".
+func (eng *Engine) Counter(name string, tags ...Tag) *CounterHandle {
+	return &CounterHandle{eng.makeHandle(name, Counter, eng.SampleRate, tags)}
+}
+
+// Incr increments the counter by one.
+func (c *CounterHandle) Incr() {
+	c.measure(intValue(1))
+}
+
+// Add increments the counter by value.
+func (c *CounterHandle) Add(value interface{}) {
+	c.measure(ValueOf(value))
+}
+
+// AddInt increments the counter by value.
+func (c *CounterHandle) AddInt(value int64) {
+	c.measure(int64Value(value))
+}
+
+// AddFloat increments the counter by value.
+func (c *CounterHandle) AddFloat(value float64) {
+	c.measure(float64Value(value))
+}
+
+// AddDuration increments the counter by value.
+func (c *CounterHandle) AddDuration(value time.Duration) {
+	c.measure(durationValue(value))
+}
+
+// GaugeHandle is a pre-bound gauge, created by calling the Gauge method of an
+// engine. See CounterHandle for details about handles.
+type GaugeHandle struct {
+	handle
+}
+
+// Gauge returns a handle to the gauge identified by name and tags. Like with
+// other methods of engines, name may be of the form "measure:field".
+func (eng *Engine) Gauge(name string, tags ...Tag) *GaugeHandle {
+	return &GaugeHandle{eng.makeHandle(name, Gauge, 1, tags)}
+}
+
+// Set sets the gauge to value.
+func (g *GaugeHandle) Set(value interface{}) {
+	g.measure(ValueOf(value))
+}
+
+// SetInt sets the gauge to value.
+func (g *GaugeHandle) SetInt(value int64) {
+	g.measure(int64Value(value))
+}
+
+// SetFloat sets the gauge to value.
+func (g *GaugeHandle) SetFloat(value float64) {
+	g.measure(float64Value(value))
+}
+
+// SetDuration sets the gauge to value.
+func (g *GaugeHandle) SetDuration(value time.Duration) {
+	g.measure(durationValue(value))
+}
+
+// HistogramHandle is a pre-bound histogram, created by calling the Histogram
+// method of an engine. See CounterHandle for details about handles.
+type HistogramHandle struct {
+	handle
+}
+
+// Histogram returns a handle to the histogram identified by name and tags. Like
+// with other methods of engines, name may be of the form "measure:field".
+func (eng *Engine) Histogram(name string, tags ...Tag) *HistogramHandle {
+	return &HistogramHandle{eng.makeHandle(name, Histogram, eng.SampleRate, tags)}
+}
+
+// Observe reports value for the histogram.
+func (h *HistogramHandle) Observe(value interface{}) {
+	h.measure(ValueOf(value))
+}
+
+// ObserveInt reports value for the histogram.
+func (h *HistogramHandle) ObserveInt(value int64) {
+	h.measure(int64Value(value))
+}
+
+// ObserveFloat reports value for the histogram.
+func (h *HistogramHandle) ObserveFloat(value float64) {
+	h.measure(float64Value(value))
+}
+
+// ObserveDuration reports value for the histogram.
+func (h *HistogramHandle) ObserveDuration(value time.Duration) {
+	h.measure(durationValue(value))
+}
+
+type handle struct {
+	eng   *Engine
+	name  string
+	field string
+	ftype FieldType
+	rate  float64
+	tags  []Tag
+}
+
+func (eng *Engine) makeHandle(name string, ftype FieldType, rate float64, tags []Tag) handle {
+	name, field := splitMeasureField(name)
+	return handle{
+		eng:   eng,
+		name:  eng.makeName(name),
+		field: field,
+		ftype: ftype,
+		rate:  rate,
+		tags:  eng.makeTags(tags),
+	}
+}
+
+func (h *handle) measure(value Value) {
+	if !sample(h.rate) {
+		return
+	}
+
+	mp := measureArrayPool.Get().(*[1]Measure)
+
+	// The tags are copied to the pooled measure because handlers may retain
+	// or reorder the slices they receive for the duration of the call.
+	m := &(*mp)[0]
+	f := Field{Name: h.field, Value: value}
+	f.setType(h.ftype)
+
+	m.Name = h.name
+	m.Fields = append(m.Fields[:0], f.WithRate(h.rate))
+	m.Tags = append(m.Tags[:0], h.tags...)
+
+	h.eng.handler().HandleMeasures(time.Now(), (*mp)[:]...)
+
+	for i := range m.Fields {
+		m.Fields[i] = Field{}
+	}
+
+	for i := range m.Tags {
+		m.Tags[i] = Tag{}
+	}
+
+	m.Name = ""
+	measureArrayPool.Put(mp)
+}
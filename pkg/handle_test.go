@@ -0,0 +1,186 @@
+package stats_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestHandle(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h, stats.T("service", "test-service"))
+
+	c := eng.Counter("measure:count", stats.T("type", "testing"), stats.T("a", "b"))
+	c.Incr()
+	c.Add(41)
+	c.AddInt(2)
+
+	g := eng.Gauge("measure:level")
+	g.Set(0.5)
+	g.SetFloat(0.25)
+
+	hist := eng.Histogram("measure", stats.T("type", "testing"))
+	hist.Observe(time.Second)
+	hist.ObserveDuration(time.Millisecond)
+
+	tags := []stats.Tag{stats.T("a", "b"), stats.T("service", "test-service"), stats.T("type", "testing")}
+
+	expected := []stats.Measure{
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			Tags:   tags,
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("count", 41, stats.Counter)},
+			Tags:   tags,
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("count", 2, stats.Counter)},
+			Tags:   tags,
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("level", 0.5, stats.Gauge)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("level", 0.25, stats.Gauge)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("", time.Second, stats.Histogram)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("type", "testing")},
+		},
+		{
+			Name:   "test.measure",
+			Fields: []stats.Field{stats.MakeField("", time.Millisecond, stats.Histogram)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("type", "testing")},
+		},
+	}
+
+	if found := h.Measures(); !reflect.DeepEqual(found, expected) {
+		t.Error("bad measures:")
+		t.Logf("expected: %#v", expected)
+		t.Logf("found:    %#v", found)
+	}
+}
+
+func TestHandleSampleRate(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h).WithSampleRate(0.5)
+
+	c := eng.Counter("calls")
+	g := eng.Gauge("level")
+
+	for i := 0; i != 1000; i++ {
+		c.Incr()
+		g.Set(i)
+	}
+
+	counters, gauges := 0, 0
+
+	for _, m := range h.Measures() {
+		switch f := m.Fields[0]; f.Type() {
+		case stats.Counter:
+			if f.Rate() != 0.5 {
+				t.Error("bad counter rate:", f.Rate())
+			}
+			counters++
+		case stats.Gauge:
+			gauges++
+		}
+	}
+
+	if counters == 0 || counters == 1000 {
+		t.Error("counters were not sampled:", counters)
+	}
+
+	if gauges != 1000 {
+		t.Error("gauges were sampled:", gauges)
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	eng := stats.NewEngine("test", stats.Discard, stats.T("service", "test-service"))
+	tags := []stats.Tag{stats.T("type", "testing"), stats.T("a", "b")}
+
+	// The values vary between iterations, constants would be boxed without
+	// allocating and hide the cost of the methods accepting interfaces.
+
+	b.Run("Engine.Add", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			eng.Add("measure:count", i, tags...)
+		}
+	})
+
+	b.Run("CounterHandle.Add", func(b *testing.B) {
+		c := eng.Counter("measure:count", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			c.Add(i)
+		}
+	})
+
+	b.Run("CounterHandle.AddInt", func(b *testing.B) {
+		c := eng.Counter("measure:count", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			c.AddInt(int64(i))
+		}
+	})
+
+	b.Run("Engine.Set", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			eng.Set("measure:level", float64(i), tags...)
+		}
+	})
+
+	b.Run("GaugeHandle.Set", func(b *testing.B) {
+		g := eng.Gauge("measure:level", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			g.Set(float64(i))
+		}
+	})
+
+	b.Run("GaugeHandle.SetFloat", func(b *testing.B) {
+		g := eng.Gauge("measure:level", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			g.SetFloat(float64(i))
+		}
+	})
+
+	b.Run("Engine.Observe", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			eng.Observe("measure:rtt", time.Duration(i), tags...)
+		}
+	})
+
+	b.Run("HistogramHandle.Observe", func(b *testing.B) {
+		h := eng.Histogram("measure:rtt", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			h.Observe(time.Duration(i))
+		}
+	})
+
+	b.Run("HistogramHandle.ObserveDuration", func(b *testing.B) {
+		h := eng.Histogram("measure:rtt", tags...)
+		b.ReportAllocs()
+		for i := 0; i != b.N; i++ {
+			h.ObserveDuration(time.Duration(i))
+		}
+	})
+}
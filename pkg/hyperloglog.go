@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// HyperLogLogPrecision is the number of bits of the hashed values used to
+	// select a register of a HyperLogLog, it has 2^HyperLogLogPrecision
+	// registers and estimates cardinalities with a standard error of about
+	// 1.04/sqrt(2^HyperLogLogPrecision), which is 1.6%.
+	HyperLogLogPrecision = 12
+
+	hllRegisters = 1 << HyperLogLogPrecision
+)
+
+// HyperLogLog is a mergeable data structure which estimates the number of
+// distinct values that were added to it, using a fixed amount of memory.
+//
+// It is used to implement the UniqueSet field type in handlers that aggregate
+// measures, since keeping all the distinct values in memory would be unbounded.
+//
+// The zero-value is a valid HyperLogLog with no values, registers are only
+// allocated when the first value is added. HyperLogLogs are not safe to use
+// concurrently from multiple goroutines.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+// Add adds v to the set of values tracked by h.
+func (h *HyperLogLog) Add(v Value) {
+	hash := hashValue(v)
+
+	if h.registers == nil {
+		h.registers = make([]uint8, hllRegisters)
+	}
+
+	i := hash >> (64 - HyperLogLogPrecision)
+	// Setting the last bit guarantees that the rank is never greater than the
+	// number of bits left after removing the index.
+	r := uint8(bits.LeadingZeros64(hash<<HyperLogLogPrecision|1<<(HyperLogLogPrecision-1))) + 1
+
+	if r > h.registers[i] {
+		h.registers[i] = r
+	}
+}
+
+// Count returns an estimation of the number of distinct values in h.
+func (h *HyperLogLog) Count() uint64 {
+	if h.registers == nil {
+		return 0
+	}
+
+	const m = float64(hllRegisters)
+	const alpha = 0.7213 / (1 + 1.079/m)
+
+	sum, zeros := 0.0, 0
+
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// The raw estimate is biased for small cardinalities, in which case linear
+	// counting of the empty registers is more accurate.
+	if estimate <= 2.5*m && zeros != 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the values of other to h.
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.registers == nil {
+		return
+	}
+
+	if h.registers == nil {
+		h.registers = make([]uint8, hllRegisters)
+	}
+
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Reset removes all values from h, retaining the allocated memory.
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+// HashString returns a 64 bits hash of s, which can be used to report strings
+// as values of UniqueSet fields.
+func HashString(s string) uint64 {
+	return fnv1a(fnvOffset, s)
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// fnv1a adds s to the FNV-1a hash h, it is inlined to avoid the allocations of
+// the hash/fnv package.
+func fnv1a(h uint64, s string) uint64 {
+	for i := 0; i != len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+func hashValue(v Value) uint64 {
+	// The bits of the values are mixed with the finalizer of splitmix64 since
+	// small integers, which are the most common values in sets, would otherwise
+	// all fall into the same register.
+	h := v.bits ^ uint64(v.typ)<<56
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
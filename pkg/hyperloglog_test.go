@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			h := HyperLogLog{}
+
+			for i := 0; i != n; i++ {
+				// Adding each value twice verifies that duplicates are not
+				// counted.
+				h.Add(ValueOf(i))
+				h.Add(ValueOf(i))
+			}
+
+			checkHyperLogLogCount(t, &h, n)
+		})
+	}
+}
+
+func TestHyperLogLogStrings(t *testing.T) {
+	h := HyperLogLog{}
+
+	for i := 0; i != 1000; i++ {
+		h.Add(ValueOf(HashString("user-" + strconv.Itoa(i%500))))
+	}
+
+	checkHyperLogLogCount(t, &h, 500)
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	h1 := HyperLogLog{}
+	h2 := HyperLogLog{}
+
+	for i := 0; i != 1000; i++ {
+		h1.Add(ValueOf(i))
+		h2.Add(ValueOf(i + 500))
+	}
+
+	h1.Merge(&h2)
+	checkHyperLogLogCount(t, &h1, 1500)
+
+	h1.Merge(&HyperLogLog{})
+	checkHyperLogLogCount(t, &h1, 1500)
+}
+
+func TestHyperLogLogReset(t *testing.T) {
+	h := HyperLogLog{}
+	h.Add(ValueOf(1))
+	h.Reset()
+
+	if n := h.Count(); n != 0 {
+		t.Error("bad count after reset:", n)
+	}
+}
+
+func checkHyperLogLogCount(t *testing.T, h *HyperLogLog, expected int) {
+	found := h.Count()
+
+	// Allow 3 standard errors, plus one for rounding on small counts.
+	if math.Abs(float64(found)-float64(expected)) > 3*0.0163*float64(expected)+1 {
+		t.Errorf("bad count: expected ~%d, found %d", expected, found)
+	}
+}
+
+func BenchmarkHyperLogLogAdd(b *testing.B) {
+	h := HyperLogLog{}
+
+	for i := 0; i != b.N; i++ {
+		h.Add(ValueOf(i))
+	}
+}
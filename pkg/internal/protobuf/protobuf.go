@@ -0,0 +1,119 @@
+// Package protobuf implements the subset of the protocol buffers encoding used
+// by the backends to produce their messages, which avoids depending on a
+// protobuf runtime for the few message types that they need.
+//
+// https://developers.google.com/protocol-buffers/docs/encoding
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types of the fields of protobuf messages.
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+)
+
+// AppendString appends the string field s to b.
+func AppendString(b []byte, field int, s string) []byte {
+	b = AppendKey(b, field, Bytes)
+	b = AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// AppendBytes appends the length-delimited field m to b, m is usually an
+// embedded message.
+func AppendBytes(b []byte, field int, m []byte) []byte {
+	b = AppendKey(b, field, Bytes)
+	b = AppendUvarint(b, uint64(len(m)))
+	return append(b, m...)
+}
+
+// AppendDouble appends the double field f to b.
+func AppendDouble(b []byte, field int, f float64) []byte {
+	return AppendFixed64(b, field, math.Float64bits(f))
+}
+
+// AppendFixed64 appends the fixed64 field v to b.
+func AppendFixed64(b []byte, field int, v uint64) []byte {
+	b = AppendKey(b, field, Fixed64)
+	return AppendUint64(b, v)
+}
+
+// AppendVarint appends the varint field v to b.
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	b = AppendKey(b, field, Varint)
+	return AppendUvarint(b, v)
+}
+
+// AppendKey appends the key of a field of the given number and wire type to b.
+func AppendKey(b []byte, field int, wireType int) []byte {
+	return AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+// AppendUint64 appends v to b in the little-endian encoding of fixed64 values,
+// without a key, as found in packed repeated fields.
+func AppendUint64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+// AppendUvarint appends v to b in the varint encoding, without a key, as found
+// in packed repeated fields and length prefixes.
+func AppendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+// Decode calls f with the number and value of each field of the message b, in
+// the order they appear. Length-delimited values are passed in v, varint and
+// fixed64 values in u.
+//
+// Decoding stops at the first error returned by f.
+func Decode(b []byte, f func(field int, v []byte, u uint64) error) error {
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("protobuf: malformed key")
+		}
+		b = b[n:]
+
+		var v []byte
+		var u uint64
+
+		switch key & 7 {
+		case Varint:
+			if u, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("protobuf: malformed varint")
+			}
+			b = b[n:]
+
+		case Fixed64:
+			if len(b) < 8 {
+				return errors.New("protobuf: malformed fixed64")
+			}
+			u, b = binary.LittleEndian.Uint64(b), b[8:]
+
+		case Bytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errors.New("protobuf: malformed length-delimited value")
+			}
+			v, b = b[n:n+int(size)], b[n+int(size):]
+
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type: %d", key&7)
+		}
+
+		if err := f(int(key>>3), v, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package protobuf
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAppendDecode(t *testing.T) {
+	type field struct {
+		number int
+		v      string
+		u      uint64
+	}
+
+	b := AppendString(nil, 1, "hello")
+	b = AppendBytes(b, 2, AppendVarint(nil, 1, 42))
+	b = AppendDouble(b, 3, 0.5)
+	b = AppendFixed64(b, 4, 1<<60)
+	b = AppendVarint(b, 5, 300)
+
+	var found []field
+
+	if err := Decode(b, func(number int, v []byte, u uint64) error {
+		found = append(found, field{number, string(v), u})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []field{
+		{1, "hello", 0},
+		{2, "\x08\x2a", 0},
+		{3, "", math.Float64bits(0.5)},
+		{4, "", 1 << 60},
+		{5, "", 300},
+	}
+
+	if !reflect.DeepEqual(found, expect) {
+		t.Error("fields mismatch")
+		t.Logf("expected: %v", expect)
+		t.Logf("found:    %v", found)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, b := range [][]byte{
+		{0x80},                 // truncated key
+		{0x08, 0x80},           // truncated varint
+		{0x09, 0x01, 0x02},     // truncated fixed64
+		{0x0a, 0x05, 'a', 'b'}, // truncated length-delimited value
+		{0x0b},                 // start group
+	} {
+		if err := Decode(b, func(int, []byte, uint64) error { return nil }); err == nil {
+			t.Errorf("%q: no error was returned", b)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHandlerMeasure is the name of the measure reporting the failures of the
+// handlers of an IsolatedHandler that wasn't configured with one.
+const DefaultHandlerMeasure = "stats.handler"
+
+// ErrFlushTimeout is the error reported when flushing a handler takes longer
+// than the flush timeout of an IsolatedHandler.
+var ErrFlushTimeout = errors.New("stats: flush timed out")
+
+// HandlerError is the type of the errors reported by an IsolatedHandler when
+// one of its handlers fails.
+type HandlerError struct {
+	// Index of the failing handler in the list of handlers.
+	Index int
+
+	// The failing handler.
+	Handler Handler
+
+	// The error, either ErrFlushTimeout or an error carrying the value that
+	// the handler panicked with.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%s (handler #%d, %T)", e.Err, e.Index, e.Handler)
+}
+
+// IsolatedHandler is the implementation of a measure handler which dispatches
+// measures to multiple handlers, like MultiHandler, but prevents a failing
+// handler from affecting the others:
+//
+// - panics are recovered separately for each handler,
+//
+// - when QueueSize is set, each handler receives the measures on its own
+// bounded queue (see AsyncHandler), so a slow handler doesn't delay the others,
+//
+// - when FlushTimeout is set, Flush doesn't wait longer than the timeout for
+// the handlers to be flushed.
+//
+// On every flush, the handler reports the failures of each handler on the
+// StatsEngine, as "panics" and "timeouts" counter fields of a measure named
+// after Measure, with tags identifying the handler by its "index" and its
+// "handler" type. Only the counters that are not zero are reported.
+//
+// Isolated handlers are safe to use concurrently from multiple goroutines. When
+// QueueSize is set, the program must call Close to release the queues.
+// Measures received after the handler was closed are forwarded synchronously.
+type IsolatedHandler struct {
+	// The handlers that measures are dispatched to.
+	Handlers []Handler
+
+	// QueueSize is the maximum number of batches of measures waiting to be
+	// forwarded to each handler.
+	//
+	// If zero, the measures are forwarded to the handlers synchronously.
+	QueueSize int
+
+	// Policy is the behavior of the queues when they are full.
+	Policy QueuePolicy
+
+	// FlushTimeout is the maximum amount of time that Flush waits for the
+	// handlers to be flushed. The handlers that didn't return in time keep
+	// being flushed in the background, and are not flushed again until they
+	// did.
+	//
+	// If zero, Flush waits for all the handlers.
+	FlushTimeout time.Duration
+
+	// ErrorHandler is called with a *HandlerError when a handler panics or
+	// times out. If nil, the failures are only reported on the StatsEngine.
+	ErrorHandler func(error)
+
+	// StatsEngine is the engine on which the failures of handlers are
+	// reported. If nil, the failures are only reported to the ErrorHandler.
+	//
+	// The engine must not forward measures to the isolated handler itself, or
+	// the failures would also be reported to the failing handlers.
+	StatsEngine *Engine
+
+	// Measure is the name of the measure reporting the failures of handlers.
+	//
+	// If empty, DefaultHandlerMeasure is used.
+	Measure string
+
+	once    sync.Once
+	entries []*isolatedEntry
+	closed  uint32
+}
+
+// IsolatedMultiHandler creates and returns a new isolated handler which
+// dispatches measures to all given handlers.
+func IsolatedMultiHandler(handlers ...Handler) *IsolatedHandler {
+	h := &IsolatedHandler{}
+
+	for _, handler := range handlers {
+		if handler != nil {
+			if m, ok := handler.(*multiHandler); ok {
+				h.Handlers = append(h.Handlers, m.handlers...) // flatten multi handlers
+			} else {
+				h.Handlers = append(h.Handlers, handler)
+			}
+		}
+	}
+
+	return h
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (h *IsolatedHandler) HandleMeasures(time time.Time, measures ...Measure) {
+	h.once.Do(h.init)
+
+	for _, e := range h.entries {
+		h.target(e).HandleMeasures(time, measures...)
+	}
+}
+
+// Flush satisfies the Flusher interface.
+//
+// The method flushes the handlers concurrently, then reports their failures.
+func (h *IsolatedHandler) Flush() {
+	h.once.Do(h.init)
+	defer h.report()
+
+	done := make([]chan struct{}, len(h.entries))
+
+	for i, e := range h.entries {
+		if !atomic.CompareAndSwapUint32(&e.flushing, 0, 1) {
+			// The handler is still being flushed since a previous call which
+			// timed out.
+			e.timedOut()
+			continue
+		}
+
+		done[i] = make(chan struct{})
+
+		go func(target Handler, e *isolatedEntry, done chan<- struct{}) {
+			defer close(done)
+			defer atomic.StoreUint32(&e.flushing, 0)
+			flush(target)
+		}(h.target(e), e, done[i])
+	}
+
+	var timeout <-chan time.Time
+	var expired bool
+
+	if h.FlushTimeout > 0 {
+		timer := time.NewTimer(h.FlushTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for i, ch := range done {
+		if ch == nil {
+			continue
+		}
+
+		if !expired {
+			select {
+			case <-ch:
+				continue
+			case <-timeout:
+				expired = true
+			}
+		}
+
+		select {
+		case <-ch:
+		default:
+			h.entries[i].timedOut()
+		}
+	}
+}
+
+// Close releases the queues of the handlers after forwarding the measures they
+// contain, then flushes the handlers like Flush does, waiting at most for the
+// flush timeout.
+//
+// The method always returns a nil error, it has this signature to satisfy the
+// io.Closer interface.
+func (h *IsolatedHandler) Close() error {
+	h.once.Do(h.init)
+
+	if !atomic.CompareAndSwapUint32(&h.closed, 0, 1) {
+		return nil
+	}
+
+	now := time.Now()
+
+	for _, e := range h.entries {
+		if a, ok := e.target.(*AsyncHandler); ok {
+			if m, ok := a.drain(); ok {
+				e.HandleMeasures(now, m)
+			}
+		}
+	}
+
+	h.Flush()
+	return nil
+}
+
+// target returns the handler that measures are forwarded to for e, the queues
+// are bypassed once the handler was closed.
+func (h *IsolatedHandler) target(e *isolatedEntry) Handler {
+	if atomic.LoadUint32(&h.closed) != 0 {
+		return e
+	}
+	return e.target
+}
+
+func (h *IsolatedHandler) init() {
+	h.entries = make([]*isolatedEntry, len(h.Handlers))
+
+	for i, handler := range h.Handlers {
+		e := &isolatedEntry{
+			handler: handler,
+			errors:  h.ErrorHandler,
+			index:   i,
+			tags: []Tag{
+				T("handler", strings.TrimPrefix(fmt.Sprintf("%T", handler), "*")),
+				T("index", strconv.Itoa(i)),
+			},
+		}
+
+		e.target = e
+
+		if h.QueueSize > 0 {
+			e.target = &AsyncHandler{
+				Handler:   e,
+				QueueSize: h.QueueSize,
+				Policy:    h.Policy,
+			}
+		}
+
+		h.entries[i] = e
+	}
+}
+
+// report reports the failures of the handlers on the stats engine, and resets
+// the counters.
+func (h *IsolatedHandler) report() {
+	name := h.measure()
+
+	for _, e := range h.entries {
+		panics := atomic.SwapUint64(&e.panics, 0)
+		timeouts := atomic.SwapUint64(&e.timeouts, 0)
+
+		if eng := h.StatsEngine; eng != nil {
+			if panics != 0 {
+				eng.Add(name+":panics", panics, e.tags...)
+			}
+			if timeouts != 0 {
+				eng.Add(name+":timeouts", timeouts, e.tags...)
+			}
+		}
+	}
+}
+
+func (h *IsolatedHandler) measure() string {
+	if measure := h.Measure; len(measure) != 0 {
+		return measure
+	}
+	return DefaultHandlerMeasure
+}
+
+// isolatedEntry wraps one of the handlers of an IsolatedHandler, it recovers
+// the panics of the handler and counts its failures.
+type isolatedEntry struct {
+	handler  Handler
+	target   Handler // the entry itself, or the async handler wrapping it
+	errors   func(error)
+	index    int
+	tags     []Tag
+	panics   uint64
+	timeouts uint64
+	flushing uint32
+}
+
+func (e *isolatedEntry) HandleMeasures(time time.Time, measures ...Measure) {
+	defer e.recover("HandleMeasures")
+	e.handler.HandleMeasures(time, measures...)
+}
+
+func (e *isolatedEntry) Flush() {
+	defer e.recover("Flush")
+	flush(e.handler)
+}
+
+func (e *isolatedEntry) recover(method string) {
+	if x := recover(); x != nil {
+		atomic.AddUint64(&e.panics, 1)
+		e.fail(fmt.Errorf("stats: %s panicked: %v", method, x))
+	}
+}
+
+func (e *isolatedEntry) timedOut() {
+	atomic.AddUint64(&e.timeouts, 1)
+	e.fail(ErrFlushTimeout)
+}
+
+func (e *isolatedEntry) fail(err error) {
+	if e.errors != nil {
+		e.errors(&HandlerError{Index: e.index, Handler: e.handler, Err: err})
+	}
+}
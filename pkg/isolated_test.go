@@ -0,0 +1,209 @@
+package stats_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+// panicHandler is a measure handler which panics when it receives measures or
+// is flushed.
+type panicHandler struct{}
+
+func (panicHandler) HandleMeasures(time.Time, ...stats.Measure) { panic("oops") }
+
+func (panicHandler) Flush() { panic("oops") }
+
+// slowFlushHandler is a measure handler which blocks when flushed, until it is
+// released.
+type slowFlushHandler struct {
+	statstest.Handler
+	release chan struct{}
+}
+
+func (h *slowFlushHandler) Flush() {
+	<-h.release
+	h.Handler.Flush()
+}
+
+// failures returns the number of panics and timeouts reported for each handler
+// index in the measures.
+func failures(measures []stats.Measure) map[string][2]uint64 {
+	f := make(map[string][2]uint64)
+
+	for _, m := range measures {
+		if m.Name != stats.DefaultHandlerMeasure {
+			continue
+		}
+		for _, tag := range m.Tags {
+			if tag.Name == "index" {
+				x := f[tag.Value]
+				for _, field := range m.Fields {
+					switch field.Name {
+					case "panics":
+						x[0] += field.Value.Uint()
+					case "timeouts":
+						x[1] += field.Value.Uint()
+					}
+				}
+				f[tag.Value] = x
+			}
+		}
+	}
+
+	return f
+}
+
+func TestIsolatedHandlerPanic(t *testing.T) {
+	var mutex sync.Mutex
+	var errs []error
+
+	h := &statstest.Handler{}
+	s := &statstest.Handler{}
+	m := stats.IsolatedMultiHandler(panicHandler{}, h)
+	m.StatsEngine = stats.NewEngine("", s)
+	m.ErrorHandler = func(err error) {
+		mutex.Lock()
+		errs = append(errs, err)
+		mutex.Unlock()
+	}
+
+	m.HandleMeasures(time.Now(), stats.Measure{Name: "A"})
+	m.Flush()
+
+	// The failures are not reported to the handlers.
+	if found := h.Measures(); len(found) != 1 || found[0].Name != "A" {
+		t.Fatal("bad measures:", found)
+	}
+
+	// Only the handler that failed is reported.
+	found := s.Measures()
+
+	if f := failures(found); len(f) != 1 || f["0"] != [2]uint64{2, 0} {
+		t.Error("bad failures:", f)
+	}
+
+	if found[0].Tags[0] != stats.T("handler", "stats_test.panicHandler") {
+		t.Error("bad tags:", found[0].Tags)
+	}
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+
+	if len(errs) != 2 {
+		t.Fatal("bad errors:", errs)
+	}
+
+	if err, ok := errs[0].(*stats.HandlerError); !ok || err.Index != 0 {
+		t.Error("bad error:", errs[0])
+	}
+}
+
+func TestIsolatedHandlerFlushTimeout(t *testing.T) {
+	h1 := &slowFlushHandler{release: make(chan struct{})}
+	h2 := &statstest.Handler{}
+	s := &statstest.Handler{}
+
+	m := stats.IsolatedMultiHandler(h1, h2)
+	m.FlushTimeout = 10 * time.Millisecond
+	m.StatsEngine = stats.NewEngine("", s)
+
+	m.Flush()
+	m.Flush() // h1 is still being flushed, it is not flushed again
+
+	close(h1.release)
+
+	for h1.FlushCalls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Flush()
+
+	if f := failures(s.Measures()); len(f) != 1 || f["0"] != [2]uint64{0, 2} {
+		t.Error("bad failures:", f)
+	}
+
+	if n := h2.FlushCalls(); n != 3 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func TestIsolatedHandlerQueue(t *testing.T) {
+	h1 := newBlockingHandler()
+	h2 := &statstest.Handler{}
+	s := &statstest.Handler{}
+
+	m := stats.IsolatedMultiHandler(h1, panicHandler{}, h2)
+	m.QueueSize = 10
+	m.StatsEngine = stats.NewEngine("", s)
+
+	// The blocked handler doesn't delay the delivery to the other handler.
+	m.HandleMeasures(time.Now(), stats.Measure{Name: "A"})
+	<-h1.started
+
+	for len(h2.Measures()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	h1.unblock()
+	m.Close()
+
+	// Closing the handler flushes it, which reports the failures of handlers.
+	var names []string
+
+	for _, m := range h1.Measures() {
+		if m.Name != stats.DefaultQueueMeasure {
+			names = append(names, m.Name)
+		}
+	}
+
+	if len(names) != 1 || names[0] != "A" {
+		t.Error("bad measures:", names)
+	}
+
+	// The panicking handler received A, the final state of its queue, and was
+	// flushed.
+	if f := failures(s.Measures()); len(f) != 1 || f["1"] != [2]uint64{3, 0} {
+		t.Error("bad failures:", f)
+	}
+
+	if n := h2.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func TestIsolatedHandlerClose(t *testing.T) {
+	h1 := &slowFlushHandler{release: make(chan struct{})}
+	h2 := &statstest.Handler{}
+	s := &statstest.Handler{}
+	defer close(h1.release)
+
+	m := stats.IsolatedMultiHandler(h1, h2)
+	m.FlushTimeout = 10 * time.Millisecond
+	m.StatsEngine = stats.NewEngine("", s)
+
+	done := make(chan struct{})
+
+	go func() {
+		m.Close()
+		m.Close() // closing twice is fine
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("closing the handler did not honor the flush timeout")
+	}
+
+	if f := failures(s.Measures()); len(f) != 1 || f["0"] != [2]uint64{0, 1} {
+		t.Error("the failures were not reported when closing the handler:", f)
+	}
+
+	if n := h2.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
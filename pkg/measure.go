@@ -3,6 +3,7 @@ package stats
 import (
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -66,15 +67,37 @@ func stringTags(tags []Tag) []string {
 //  int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
 //  float32, float64, or time.Duration, and represent fields of the measures.
 //  The struct fields may also define a 'type' tag with a value of "counter",
-//  "gauge" or "histogram" to tune the behavior of the measure handlers.
+//  "gauge", "histogram", "distribution" or "set" to tune the behavior of the
+//  measure handlers.
 //
-//  2. All fields exposing a 'tag' tag are expected to be of type string and
+//  2. All fields exposing a 'tag' tag are expected to be of type string, bool,
+//  or one of the integer or floating point types (which are formatted), and
 //  represent tags of the measures.
 //
 //  3. All struct fields are searched recursively for fields matching rule (1)
 //  and (2). Tags found within a struct are inherited by measures generated from
 //  sub-fields, they may also be overwritten.
 //
+//  4. Counter fields may define a 'source' tag with a value of "cumulative" to
+//  declare that they hold monotonically increasing totals (like the counters
+//  exposed by the kernel or the Go runtime). Engines remember the last value
+//  of each series and report the increment since the previous report instead,
+//  the first report of a series establishes the baseline and reports zero (the
+//  increment cannot be known without a previous value, and reporting the total
+//  would count everything that happened before the program started reporting
+//  it). A value lower than the previous one is interpreted as a reset of the
+//  counter, the value itself is reported then. Series that are not reported
+//  for more than ten minutes are forgotten, and establish a new baseline when
+//  reported again. MakeMeasures, which has no state, returns the totals.
+//
+//  5. Fields exposing a 'metric' tag may also be pointers to structs, which are
+//  skipped when nil, or maps with string keys and slices or arrays of structs,
+//  pointers to structs, or values of the types listed in rule (1). Maps and
+//  slices expand into one set of measures per element, tagged with the key of
+//  the element or its index. The name of the tag is set by the 'tag' tag of
+//  the field, and defaults to "key" for maps and "index" for slices and
+//  arrays.
+//
 func MakeMeasures(prefix string, value interface{}, tags ...Tag) []Measure {
 	if !TagsAreSorted(tags) {
 		SortTags(tags)
@@ -127,6 +150,10 @@ func appendMeasures(m []Measure, cache *measureCache, prefix string, v reflect.V
 		}
 	}
 
+	return appendMeasuresWith(m, cache, mf, ptr, tags...)
+}
+
+func appendMeasuresWith(m []Measure, cache *measureCache, mf []measureFuncs, ptr unsafe.Pointer, tags ...Tag) []Measure {
 	used := len(m)
 	free := cap(m) - used
 	need := len(mf)
@@ -135,10 +162,25 @@ func appendMeasures(m []Measure, cache *measureCache, prefix string, v reflect.V
 		m = append(make([]Measure, 0, used+need), m...)
 	}
 
-	m = m[:used+need]
-
 	for i := range mf {
-		m[used+i].set(ptr, mf[i], tags...)
+		if expand := mf[i].expand; expand != nil {
+			m = expand(m, cache, ptr, tags)
+			continue
+		}
+
+		// Reslicing instead of appending reuses the fields and tags of the
+		// measures that were left in the buffer by previous calls.
+		if n := len(m); n < cap(m) {
+			m = m[:n+1]
+		} else {
+			m = append(m, Measure{})
+		}
+
+		m[len(m)-1].set(ptr, mf[i], tags...)
+
+		if len(mf[i].cumulative) != 0 && cache != nil {
+			cache.cumulativeValues().update(&m[len(m)-1], mf[i].cumulative)
+		}
 	}
 
 	return m
@@ -168,10 +210,11 @@ func (m *Measure) set(ptr unsafe.Pointer, mf measureFuncs, tags ...Tag) {
 		m.Tags = m.Tags[:n3]
 	}
 
+	i := 0
 	i1 := 0
 	i2 := 0
 
-	for i := range m.Tags {
+	for ; i1 != n1 || i2 != n2; i++ {
 		switch {
 		case i1 == n1:
 			m.Tags[i] = tags[i2]
@@ -185,15 +228,24 @@ func (m *Measure) set(ptr unsafe.Pointer, mf measureFuncs, tags ...Tag) {
 			t1 := mf.tags[i1](ptr)
 			t2 := tags[i2]
 
-			if t1.Name < t2.Name {
+			switch {
+			case t1.Name < t2.Name:
+				m.Tags[i] = t1
+				i1++
+			case t1.Name == t2.Name:
+				// Tags of the struct overwrite the inherited tags of the
+				// same name.
 				m.Tags[i] = t1
 				i1++
-			} else {
+				i2++
+			default:
 				m.Tags[i] = t2
 				i2++
 			}
 		}
 	}
+
+	m.Tags = m.Tags[:i]
 }
 
 func (m *Measure) reset() {
@@ -214,6 +266,14 @@ type measureFuncs struct {
 	name   string
 	fields []func(unsafe.Pointer) Field
 	tags   []func(unsafe.Pointer) Tag
+
+	// When set, the function appends to m the measures of a pointer, map, or
+	// slice field, which cannot be resolved statically.
+	expand func(m []Measure, cache *measureCache, ptr unsafe.Pointer, tags []Tag) []Measure
+
+	// Indexes of the fields reporting cumulative totals, which are converted
+	// to increments when the measures are reported by an engine.
+	cumulative []int
 }
 
 func makeMeasureFuncs(typ reflect.Type, prefix string) []measureFuncs {
@@ -230,14 +290,13 @@ func appendMeasureFuncs(measures []measureFuncs, typ reflect.Type, name string,
 	for i, n := 0, typ.NumField(); i != n; i++ {
 		field := typ.Field(i)
 
-		if tag := field.Tag.Get("tag"); len(tag) != 0 {
-			switch field.Type {
-			case stringType:
-				sf := structField{typ: field.Type, off: offset + field.Offset}
-				tags[tag] = makeTagFunc(sf, tag)
-			default:
+		if tag := field.Tag.Get("tag"); len(tag) != 0 && !isExpandedKind(field.Type.Kind()) {
+			sf := structField{typ: field.Type, off: offset + field.Offset}
+			f := makeTagFunc(sf, tag)
+			if f == nil {
 				panic("unsupported value type found for metric tags of " + concat(name, tag) + ": " + field.Type.String())
 			}
+			tags[tag] = f
 		}
 	}
 
@@ -245,11 +304,16 @@ func appendMeasureFuncs(measures []measureFuncs, typ reflect.Type, name string,
 
 	for i, n := 0, typ.NumField(); i != n; i++ {
 		field := typ.Field(i)
-		metric := field.Tag.Get("metric")
+		metric, hasMetric := field.Tag.Lookup("metric")
 
-		switch field.Type.Kind() {
-		case reflect.Struct:
+		switch kind := field.Type.Kind(); {
+		case kind == reflect.Struct:
 			measures = appendMeasureFuncs(measures, field.Type, concat(name, metric), tags, offset+field.Offset)
+		case isExpandedKind(kind) && hasMetric:
+			sf := structField{typ: field.Type, off: offset + field.Offset}
+			measures = append(measures, measureFuncs{
+				expand: makeExpandFunc(sf, field.Tag, concat(name, metric), name, metric, mf.tags),
+			})
 		default:
 			if len(metric) != 0 {
 				sf := structField{typ: field.Type, off: offset + field.Offset}
@@ -258,6 +322,9 @@ func appendMeasureFuncs(measures []measureFuncs, typ reflect.Type, name string,
 				if f == nil {
 					panic("unsupported value type found for metric " + concat(name, metric) + ": " + field.Type.String())
 				}
+				if isCumulative(field.Tag) {
+					mf.cumulative = append(mf.cumulative, len(mf.fields))
+				}
 				mf.fields = append(mf.fields, f)
 			}
 		}
@@ -375,12 +442,22 @@ func makeAnyFieldFunc(name string, ftype FieldType, valueOf func(unsafe.Pointer)
 	}
 }
 
+// isCumulative returns true if the struct tag declares a field reporting a
+// cumulative total (with a 'source' tag set to "cumulative").
+func isCumulative(stag reflect.StructTag) bool {
+	return stag.Get("source") == "cumulative"
+}
+
 func makeFieldType(mtype string) FieldType {
 	switch mtype {
 	case "counter":
 		return Counter
 	case "gauge":
 		return Gauge
+	case "distribution":
+		return Distribution
+	case "set":
+		return UniqueSet
 	default:
 		return Histogram
 	}
@@ -427,7 +504,259 @@ type namedTagFunc struct {
 }
 
 func makeTagFunc(sf structField, name string) func(unsafe.Pointer) Tag {
-	return func(ptr unsafe.Pointer) Tag { return Tag{Name: name, Value: sf.string(ptr)} }
+	switch sf.typ.Kind() {
+	case reflect.String:
+		return func(ptr unsafe.Pointer) Tag { return Tag{Name: name, Value: sf.string(ptr)} }
+	case reflect.Bool:
+		return func(ptr unsafe.Pointer) Tag { return Tag{Name: name, Value: strconv.FormatBool(sf.bool(ptr))} }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return func(ptr unsafe.Pointer) Tag { return Tag{Name: name, Value: formatTagValue(sf.value(ptr).Elem())} }
+	default:
+		return nil
+	}
+}
+
+func formatTagValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	default:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	}
+}
+
+// isExpandedKind returns true if struct fields of the given kind are expanded
+// dynamically into measures when they expose a 'metric' tag.
+func isExpandedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// makeExpandFunc returns the function expanding the pointer, map, slice or
+// array field sf into measures. Measures of struct elements are named after
+// name, elements of other types are fields named after metric of a measure
+// named after parent. The tags of the parent struct are inherited by all the
+// measures.
+func makeExpandFunc(sf structField, stag reflect.StructTag, name string, parent string, metric string, parentTags []func(unsafe.Pointer) Tag) func([]Measure, *measureCache, unsafe.Pointer, []Tag) []Measure {
+	typ := sf.typ
+	key := stag.Get("tag")
+
+	if typ.Kind() == reflect.Ptr {
+		if typ.Elem().Kind() != reflect.Struct {
+			panic("unsupported value type found for metric " + name + ": " + typ.String())
+		}
+
+		elem := &lazyMeasureFuncs{typ: typ.Elem(), name: name}
+
+		return func(m []Measure, cache *measureCache, ptr unsafe.Pointer, tags []Tag) []Measure {
+			p := *(*unsafe.Pointer)(sf.pointer(ptr))
+
+			if p == nil {
+				return m
+			}
+
+			tb := inheritTags(ptr, parentTags, tags)
+			m = appendMeasuresWith(m, cache, elem.get(), p, tb.tags...)
+			tb.reset()
+			tagsPool.Put(tb)
+			return m
+		}
+	}
+
+	if typ.Kind() == reflect.Map && typ.Key().Kind() != reflect.String {
+		panic("unsupported key type found for metric " + name + ": " + typ.String())
+	}
+
+	if len(key) == 0 {
+		if typ.Kind() == reflect.Map {
+			key = "key"
+		} else {
+			key = "index"
+		}
+	}
+
+	elemType := typ.Elem()
+	elemPtr := elemType.Kind() == reflect.Ptr
+	var elemFuncs func() []measureFuncs
+
+	switch {
+	case elemType.Kind() == reflect.Struct:
+		elemFuncs = (&lazyMeasureFuncs{typ: elemType, name: name}).get
+	case elemPtr && elemType.Elem().Kind() == reflect.Struct:
+		elemFuncs = (&lazyMeasureFuncs{typ: elemType.Elem(), name: name}).get
+	default:
+		f := makeFieldFunc(structField{typ: elemType}, metric, makeFieldType(stag.Get("type")))
+		if f == nil {
+			panic("unsupported value type found for metric " + name + ": " + typ.String())
+		}
+		mf := []measureFuncs{{name: parent, fields: []func(unsafe.Pointer) Field{f}}}
+		if isCumulative(stag) {
+			mf[0].cumulative = []int{0}
+		}
+		elemFuncs = func() []measureFuncs { return mf }
+	}
+
+	// appendElem appends the measures of a single element, v must be
+	// addressable unless it's a pointer.
+	appendElem := func(m []Measure, cache *measureCache, v reflect.Value, keyValue string, tags []Tag) []Measure {
+		var p unsafe.Pointer
+
+		if elemPtr {
+			if v.IsNil() {
+				return m
+			}
+			p = unsafe.Pointer(v.Pointer())
+		} else {
+			p = unsafe.Pointer(v.Addr().Pointer())
+		}
+
+		// The tag carrying the key of the element overwrites the inherited
+		// tags of the same name.
+		kb := tagsPool.Get().(*tagsBuffer)
+		kb.append(tags...)
+		kb.append(Tag{Name: key, Value: keyValue})
+		kb.tags = mergeTags(kb.tags)
+		m = appendMeasuresWith(m, cache, elemFuncs(), p, kb.tags...)
+		kb.reset()
+		tagsPool.Put(kb)
+		return m
+	}
+
+	if typ.Kind() == reflect.Map {
+		pool := sync.Pool{
+			New: func() interface{} { return newMapBuffer(typ) },
+		}
+
+		return func(m []Measure, cache *measureCache, ptr unsafe.Pointer, tags []Tag) []Measure {
+			v := sf.value(ptr).Elem()
+
+			if v.Len() == 0 {
+				return m
+			}
+
+			buf := pool.Get().(*mapBuffer)
+			buf.load(v)
+			sort.Sort(buf)
+
+			tb := inheritTags(ptr, parentTags, tags)
+
+			for _, i := range buf.order {
+				m = appendElem(m, cache, buf.values.Index(i), buf.keys[i], tb.tags)
+			}
+
+			tb.reset()
+			tagsPool.Put(tb)
+			buf.reset()
+			pool.Put(buf)
+			return m
+		}
+	}
+
+	return func(m []Measure, cache *measureCache, ptr unsafe.Pointer, tags []Tag) []Measure {
+		v := sf.value(ptr).Elem()
+
+		if v.Len() == 0 {
+			return m
+		}
+
+		tb := inheritTags(ptr, parentTags, tags)
+
+		for i, n := 0, v.Len(); i != n; i++ {
+			m = appendElem(m, cache, v.Index(i), strconv.Itoa(i), tb.tags)
+		}
+
+		tb.reset()
+		tagsPool.Put(tb)
+		return m
+	}
+}
+
+// inheritTags returns a buffer of the sorted tags inherited by the measures of
+// elements of a struct, made of the tags of the struct at ptr and tags.
+func inheritTags(ptr unsafe.Pointer, fns []func(unsafe.Pointer) Tag, tags []Tag) *tagsBuffer {
+	tb := tagsPool.Get().(*tagsBuffer)
+	tb.append(tags...)
+
+	for _, f := range fns {
+		tb.append(f(ptr))
+	}
+
+	tb.tags = mergeTags(tb.tags)
+	return tb
+}
+
+// mapBuffer holds copies of the keys and values of a map field, sorted by key,
+// while the measures of its elements are set. Map values are not addressable,
+// copying them to a slice which is reused across reports avoids allocating
+// temporary values.
+type mapBuffer struct {
+	iter   reflect.MapIter
+	key    reflect.Value
+	zero   reflect.Value
+	values reflect.Value
+	keys   []string
+	order  []int
+}
+
+func newMapBuffer(typ reflect.Type) *mapBuffer {
+	return &mapBuffer{
+		key:    reflect.New(typ.Key()).Elem(),
+		zero:   reflect.Zero(typ.Elem()),
+		values: reflect.MakeSlice(reflect.SliceOf(typ.Elem()), 0, 0),
+	}
+}
+
+func (buf *mapBuffer) load(m reflect.Value) {
+	if n := m.Len(); n > buf.values.Len() {
+		buf.values = reflect.MakeSlice(buf.values.Type(), n, n)
+	}
+
+	buf.iter.Reset(m)
+
+	for i := 0; buf.iter.Next(); i++ {
+		buf.key.SetIterKey(&buf.iter)
+		buf.values.Index(i).SetIterValue(&buf.iter)
+		buf.keys = append(buf.keys, buf.key.String())
+		buf.order = append(buf.order, i)
+	}
+}
+
+func (buf *mapBuffer) reset() {
+	for i := range buf.keys {
+		buf.values.Index(i).Set(buf.zero)
+		buf.keys[i] = ""
+	}
+	buf.keys = buf.keys[:0]
+	buf.order = buf.order[:0]
+	buf.iter.Reset(reflect.Value{})
+}
+
+func (buf *mapBuffer) Len() int               { return len(buf.order) }
+func (buf *mapBuffer) Less(i int, j int) bool { return buf.keys[buf.order[i]] < buf.keys[buf.order[j]] }
+func (buf *mapBuffer) Swap(i int, j int)      { buf.order[i], buf.order[j] = buf.order[j], buf.order[i] }
+
+// lazyMeasureFuncs holds the measure functions of the elements of pointer, map
+// or slice fields. They are only constructed the first time they are needed,
+// which supports recursive types.
+type lazyMeasureFuncs struct {
+	once  sync.Once
+	typ   reflect.Type
+	name  string
+	funcs []measureFuncs
+}
+
+func (l *lazyMeasureFuncs) get() []measureFuncs {
+	l.once.Do(func() { l.funcs = appendMeasureFuncs(nil, l.typ, l.name, nil, 0) })
+	return l.funcs
 }
 
 type tagFuncByName []namedTagFunc
@@ -457,7 +786,8 @@ var measurePool = sync.Pool{
 }
 
 type measureCache struct {
-	cache unsafe.Pointer
+	cache      unsafe.Pointer
+	cumulative unsafe.Pointer
 }
 
 func (c *measureCache) lookup(typ reflect.Type) ([]measureFuncs, bool) {
@@ -119,3 +119,175 @@ func TestMakeMeasures(t *testing.T) {
 		t.Logf("founc:    %#v", measures)
 	}
 }
+
+type testShard struct {
+	Queries int  `metric:"queries" type:"counter"`
+	Leader  bool `tag:"leader"`
+}
+
+type testNode struct {
+	Depth int       `metric:"depth" type:"gauge"`
+	Next  *testNode `metric:""`
+}
+
+type testExpandedMetrics struct {
+	Port   int     `tag:"port"`
+	Secure bool    `tag:"secure"`
+	Ratio  float64 `tag:"ratio"`
+
+	Conn    *testShard           `metric:"conn"`
+	Missing *testShard           `metric:"missing"`
+	Shards  []testShard          `metric:"shard" tag:"shard"`
+	Pools   map[string]testShard `metric:"pool"`
+	CPU     [2]uint64            `metric:"cpu.time" type:"counter" tag:"cpu"`
+	Node    testNode             `metric:"node"`
+}
+
+func TestMakeMeasuresExpanded(t *testing.T) {
+	m := testExpandedMetrics{
+		Port:   8080,
+		Secure: true,
+		Ratio:  0.5,
+		Conn:   &testShard{Queries: 1},
+		Shards: []testShard{{Queries: 2, Leader: true}, {Queries: 3}},
+		Pools:  map[string]testShard{"b": {Queries: 5}, "a": {Queries: 4}},
+		CPU:    [2]uint64{6, 7},
+		Node:   testNode{Depth: 0, Next: &testNode{Depth: 1}},
+	}
+
+	tags := func(extra ...Tag) []Tag {
+		return SortTags(append([]Tag{{"port", "8080"}, {"ratio", "0.5"}, {"secure", "true"}}, extra...))
+	}
+
+	expect := []Measure{
+		{Name: "test.conn", Fields: []Field{MakeField("queries", 1, Counter)}, Tags: tags(Tag{"leader", "false"})},
+		{Name: "test.shard", Fields: []Field{MakeField("queries", 2, Counter)}, Tags: tags(Tag{"leader", "true"}, Tag{"shard", "0"})},
+		{Name: "test.shard", Fields: []Field{MakeField("queries", 3, Counter)}, Tags: tags(Tag{"leader", "false"}, Tag{"shard", "1"})},
+		{Name: "test.pool", Fields: []Field{MakeField("queries", 4, Counter)}, Tags: tags(Tag{"key", "a"}, Tag{"leader", "false"})},
+		{Name: "test.pool", Fields: []Field{MakeField("queries", 5, Counter)}, Tags: tags(Tag{"key", "b"}, Tag{"leader", "false"})},
+		{Name: "test", Fields: []Field{MakeField("cpu.time", uint64(6), Counter)}, Tags: tags(Tag{"cpu", "0"})},
+		{Name: "test", Fields: []Field{MakeField("cpu.time", uint64(7), Counter)}, Tags: tags(Tag{"cpu", "1"})},
+		{Name: "test.node", Fields: []Field{MakeField("depth", 1, Gauge)}, Tags: tags()},
+		{Name: "test.node", Fields: []Field{MakeField("depth", 0, Gauge)}, Tags: tags()},
+	}
+
+	cache := &measureCache{}
+
+	// The second iteration goes through the cached measure functions.
+	for i := 0; i != 2; i++ {
+		measures := makeMeasures(cache, "test", reflect.ValueOf(&m))
+
+		if !reflect.DeepEqual(measures, expect) {
+			t.Errorf("#%d: bad measures:", i)
+			for _, m := range expect {
+				t.Logf("expected: %s", m)
+			}
+			for _, m := range measures {
+				t.Logf("found:    %s", m)
+			}
+		}
+	}
+}
+
+func TestMakeMeasuresTagOverwrite(t *testing.T) {
+	measures := MakeMeasures("test", struct {
+		Count int    `metric:"count" type:"counter"`
+		Host  string `tag:"host"`
+	}{1, "localhost"}, Tag{"host", "remote"})
+
+	if len(measures) != 1 || !reflect.DeepEqual(measures[0].Tags, []Tag{{"host", "localhost"}}) {
+		t.Error("struct tags did not overwrite the inherited tags:", measures)
+	}
+}
+
+func TestMeasureSetTagOverride(t *testing.T) {
+	type pool struct {
+		Size int    `metric:"size" type:"gauge"`
+		Host string `tag:"host"`
+	}
+
+	metrics := struct {
+		Pools map[string]pool `metric:"pool" tag:"zone"`
+		Zone  string          `tag:"zone"`
+	}{
+		Pools: map[string]pool{"eu": {Size: 1, Host: "local"}},
+		Zone:  "us",
+	}
+
+	// The measures have a single tag of each name, the innermost value wins:
+	// the key of the map overrides the tag of the parent struct, and the tags
+	// of the element override the tags passed by the caller.
+	measures := MakeMeasures("test", metrics, Tag{"host", "remote"}, Tag{"zone", "ap"})
+
+	expect := []Tag{{"host", "local"}, {"zone", "eu"}}
+
+	if len(measures) != 1 || !reflect.DeepEqual(measures[0].Tags, expect) {
+		t.Error("bad tags:", measures)
+	}
+}
+
+func TestMakeMeasuresMapAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes sync.Pool drop items")
+	}
+
+	m := testExpandedMetrics{
+		Pools: map[string]testShard{"b": {Queries: 5}, "a": {Queries: 4}, "c": {Queries: 6}},
+	}
+
+	cache := &measureCache{}
+	measures := make([]Measure, 0, 16)
+	v := reflect.ValueOf(&m)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		measures = appendMeasures(measures[:0], cache, "test", v)
+	})
+
+	if allocs != 0 {
+		t.Error("reporting maps allocates:", allocs)
+	}
+}
+
+func TestCumulativeCache(t *testing.T) {
+	c := &cumulativeCache{current: make(map[string]Value), rotated: time.Now()}
+
+	update := func(tag string, value int) int64 {
+		m := Measure{
+			Name:   "test",
+			Fields: []Field{MakeField("total", value, Counter)},
+			Tags:   []Tag{T("id", tag)},
+		}
+		c.update(&m, []int{0})
+		return m.Fields[0].Value.Int()
+	}
+
+	steps := []struct {
+		rotate bool
+		tag    string
+		value  int
+		delta  int64
+	}{
+		{tag: "1", value: 10, delta: 0},
+		{tag: "2", value: 5, delta: 0}, // series are identified by their tags
+		{tag: "1", value: 15, delta: 5},
+		{rotate: true, tag: "1", value: 20, delta: 5}, // found in the previous generation
+		{rotate: true, tag: "1", value: 22, delta: 2}, // series 2 expired
+		{tag: "2", value: 7, delta: 0},
+	}
+
+	for i, s := range steps {
+		if s.rotate {
+			c.rotated = c.rotated.Add(-cumulativeTimeout)
+		}
+
+		if delta := update(s.tag, s.value); delta != s.delta {
+			t.Errorf("step #%d: bad delta: expected %d but found %d", i, s.delta, delta)
+		}
+
+		if i == 4 {
+			if n := len(c.current) + len(c.previous); n != 1 {
+				t.Error("expired series were not removed:", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package stats
+
+// raceEnabled is true when the tests run with the race detector, which makes
+// sync.Pool drop items randomly and breaks the allocation checks.
+const raceEnabled = true
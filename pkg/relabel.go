@@ -0,0 +1,297 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RelabelAction is an enumeration of the operations that relabeling rules can
+// apply to measures.
+type RelabelAction int
+
+const (
+	// RelabelRenameTag renames the tag named Tag to Target.
+	RelabelRenameTag RelabelAction = iota
+
+	// RelabelDropTag removes the tag named Tag, or the tags with a name that
+	// matches Regex when it is set.
+	RelabelDropTag
+
+	// RelabelSetTag sets the tag named Target to Replacement, adding it if the
+	// measure didn't have it.
+	RelabelSetTag
+
+	// RelabelReplace rewrites the value of the tag named Tag when it matches
+	// Regex. The tag named Target (or Tag if Target is empty) is set to the
+	// expansion of Replacement, which may reference the capture groups of the
+	// regular expression like "$1".
+	RelabelReplace
+
+	// RelabelDropMeasure drops the measures with a name that matches Regex.
+	RelabelDropMeasure
+
+	// RelabelKeepMeasure drops the measures with a name that doesn't match
+	// Regex.
+	RelabelKeepMeasure
+)
+
+func (a RelabelAction) String() string {
+	switch a {
+	case RelabelRenameTag:
+		return "rename-tag"
+	case RelabelDropTag:
+		return "drop-tag"
+	case RelabelSetTag:
+		return "set-tag"
+	case RelabelReplace:
+		return "replace"
+	case RelabelDropMeasure:
+		return "drop-measure"
+	case RelabelKeepMeasure:
+		return "keep-measure"
+	default:
+		return "<unknown>"
+	}
+}
+
+// RelabelRule represents a single operation applied by a Relabeler, the fields
+// that need to be set depend on the action, see RelabelAction for details.
+//
+// Regular expressions are anchored, they must match the whole tag value or
+// measure name.
+type RelabelRule struct {
+	Action      RelabelAction
+	Tag         string
+	Target      string
+	Regex       string
+	Replacement string
+}
+
+// Relabeler is the implementation of a measure handler which rewrites the tags
+// of the measures it receives, or drops measures, before forwarding them to
+// another handler. It plays the same role as the relabeling configuration of
+// prometheus, and is typically used to remove or rename tags that a backend
+// should not receive.
+//
+// Rules are applied in order, each rule seeing the result of the previous ones.
+// The tags of the forwarded measures are sorted, which is what handlers expect.
+//
+// Relabelers are safe to use concurrently from multiple goroutines.
+type Relabeler struct {
+	handler Handler
+	rules   []relabelRule
+}
+
+type relabelRule struct {
+	RelabelRule
+	regex *regexp.Regexp
+}
+
+// NewRelabeler creates and returns a new relabeler which applies rules to the
+// measures it forwards to handler. An error is returned if one of the rules is
+// invalid.
+func NewRelabeler(handler Handler, rules ...RelabelRule) (*Relabeler, error) {
+	r := &Relabeler{
+		handler: handler,
+		rules:   make([]relabelRule, len(rules)),
+	}
+
+	for i, rule := range rules {
+		if err := r.rules[i].compile(rule); err != nil {
+			return nil, fmt.Errorf("stats: relabeling rule #%d (%s): %s", i, rule.Action, err)
+		}
+	}
+
+	return r, nil
+}
+
+func (rule *relabelRule) compile(config RelabelRule) (err error) {
+	rule.RelabelRule = config
+
+	switch config.Action {
+	case RelabelRenameTag:
+		if len(config.Tag) == 0 || len(config.Target) == 0 {
+			return fmt.Errorf("both the tag and target must be set")
+		}
+
+	case RelabelDropTag:
+		if len(config.Tag) == 0 && len(config.Regex) == 0 {
+			return fmt.Errorf("either the tag or regex must be set")
+		}
+
+	case RelabelSetTag:
+		if len(config.Target) == 0 {
+			return fmt.Errorf("the target must be set")
+		}
+
+	case RelabelReplace:
+		if len(config.Tag) == 0 || len(config.Regex) == 0 {
+			return fmt.Errorf("both the tag and regex must be set")
+		}
+		if len(rule.Target) == 0 {
+			rule.Target = config.Tag
+		}
+
+	case RelabelDropMeasure, RelabelKeepMeasure:
+		if len(config.Regex) == 0 {
+			return fmt.Errorf("the regex must be set")
+		}
+
+	default:
+		return fmt.Errorf("unsupported action")
+	}
+
+	if len(config.Regex) != 0 {
+		rule.regex, err = regexp.Compile("^(?:" + config.Regex + ")$")
+	}
+
+	return
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (r *Relabeler) HandleMeasures(time time.Time, measures ...Measure) {
+	buf := relabelPool.Get().(*relabelBuffer)
+
+	for _, m := range measures {
+		if !r.keep(m.Name) {
+			continue
+		}
+
+		// The tags of the measures are owned by the caller, they are copied
+		// to the buffer before being rewritten.
+		offset := len(buf.tags)
+		buf.tags = append(buf.tags, m.Tags...)
+		buf.tags = append(buf.tags[:offset], r.relabel(buf.tags[offset:])...)
+		buf.offsets = append(buf.offsets, offset)
+		buf.measures = append(buf.measures, m)
+	}
+
+	// The tags buffer may have been reallocated while it was growing, the
+	// slices of tags are only set on the measures once it's complete.
+	for i := range buf.measures {
+		end := len(buf.tags)
+
+		if i+1 < len(buf.offsets) {
+			end = buf.offsets[i+1]
+		}
+
+		buf.measures[i].Tags = SortTags(buf.tags[buf.offsets[i]:end:end])
+	}
+
+	if len(buf.measures) != 0 {
+		r.handler.HandleMeasures(time, buf.measures...)
+	}
+
+	buf.reset()
+	relabelPool.Put(buf)
+}
+
+// Flush satisfies the Flusher interface.
+func (r *Relabeler) Flush() {
+	flush(r.handler)
+}
+
+func (r *Relabeler) keep(name string) bool {
+	for i := range r.rules {
+		switch rule := &r.rules[i]; rule.Action {
+		case RelabelDropMeasure:
+			if rule.regex.MatchString(name) {
+				return false
+			}
+		case RelabelKeepMeasure:
+			if !rule.regex.MatchString(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *Relabeler) relabel(tags []Tag) []Tag {
+	for i := range r.rules {
+		switch rule := &r.rules[i]; rule.Action {
+		case RelabelRenameTag:
+			if j := tagIndex(tags, rule.Tag); j >= 0 {
+				value := tags[j].Value
+				tags = setTag(removeTag(tags, j), rule.Target, value)
+			}
+
+		case RelabelDropTag:
+			for j := 0; j < len(tags); {
+				if rule.matchName(tags[j].Name) {
+					tags = removeTag(tags, j)
+				} else {
+					j++
+				}
+			}
+
+		case RelabelSetTag:
+			tags = setTag(tags, rule.Target, rule.Replacement)
+
+		case RelabelReplace:
+			if j := tagIndex(tags, rule.Tag); j >= 0 {
+				if match := rule.regex.FindStringSubmatchIndex(tags[j].Value); match != nil {
+					value := string(rule.regex.ExpandString(nil, rule.Replacement, tags[j].Value, match))
+					tags = setTag(tags, rule.Target, value)
+				}
+			}
+		}
+	}
+	return tags
+}
+
+func (rule *relabelRule) matchName(name string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(name)
+	}
+	return name == rule.Tag
+}
+
+func tagIndex(tags []Tag, name string) int {
+	for i, t := range tags {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func setTag(tags []Tag, name string, value string) []Tag {
+	if i := tagIndex(tags, name); i >= 0 {
+		tags[i].Value = value
+		return tags
+	}
+	return append(tags, Tag{name, value})
+}
+
+func removeTag(tags []Tag, i int) []Tag {
+	copy(tags[i:], tags[i+1:])
+	tags[len(tags)-1] = Tag{}
+	return tags[:len(tags)-1]
+}
+
+type relabelBuffer struct {
+	measures []Measure
+	tags     []Tag
+	offsets  []int
+}
+
+func (buf *relabelBuffer) reset() {
+	for i := range buf.measures {
+		buf.measures[i] = Measure{}
+	}
+
+	for i := range buf.tags {
+		buf.tags[i] = Tag{}
+	}
+
+	buf.measures = buf.measures[:0]
+	buf.tags = buf.tags[:0]
+	buf.offsets = buf.offsets[:0]
+}
+
+var relabelPool = sync.Pool{
+	New: func() interface{} { return &relabelBuffer{} },
+}
@@ -0,0 +1,190 @@
+package stats_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestRelabeler(t *testing.T) {
+	tests := []struct {
+		scenario string
+		rules    []stats.RelabelRule
+		measures []stats.Measure
+		expected []stats.Measure
+	}{
+		{
+			scenario: "renaming a tag keeps the tags sorted",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelRenameTag, Tag: "a", Target: "z"},
+			},
+			measures: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"a", "1"}, {"b", "2"}}},
+			},
+			expected: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"b", "2"}, {"z", "1"}}},
+			},
+		},
+		{
+			scenario: "renaming a tag overwrites the target",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelRenameTag, Tag: "a", Target: "b"},
+			},
+			measures: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"a", "1"}, {"b", "2"}}},
+			},
+			expected: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"b", "1"}}},
+			},
+		},
+		{
+			scenario: "dropping tags by name or regex",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelDropTag, Tag: "a"},
+				{Action: stats.RelabelDropTag, Regex: "http_.*"},
+			},
+			measures: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"a", "1"}, {"b", "2"}, {"http_req_id", "3"}, {"http_req_path", "/"}}},
+			},
+			expected: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"b", "2"}}},
+			},
+		},
+		{
+			scenario: "setting static tags",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelSetTag, Target: "a", Replacement: "0"},
+				{Action: stats.RelabelSetTag, Target: "env", Replacement: "prod"},
+			},
+			measures: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"a", "1"}, {"z", "2"}}},
+				{Name: "n"},
+			},
+			expected: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"a", "0"}, {"env", "prod"}, {"z", "2"}}},
+				{Name: "n", Tags: []stats.Tag{{"a", "0"}, {"env", "prod"}}},
+			},
+		},
+		{
+			scenario: "replacing tag values with regular expressions",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelReplace, Tag: "path", Regex: "/users/[0-9]+(/.*)?", Replacement: "/users/:id$1"},
+				{Action: stats.RelabelReplace, Tag: "host", Regex: "([^.]+)\\..*", Target: "shortname", Replacement: "$1"},
+			},
+			measures: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"host", "web-1.example.com"}, {"path", "/users/42/posts"}}},
+				{Name: "m", Tags: []stats.Tag{{"host", "localhost"}, {"path", "/"}}},
+			},
+			expected: []stats.Measure{
+				{Name: "m", Tags: []stats.Tag{{"host", "web-1.example.com"}, {"path", "/users/:id/posts"}, {"shortname", "web-1"}}},
+				{Name: "m", Tags: []stats.Tag{{"host", "localhost"}, {"path", "/"}}},
+			},
+		},
+		{
+			scenario: "dropping and keeping measures by name",
+			rules: []stats.RelabelRule{
+				{Action: stats.RelabelKeepMeasure, Regex: "http\\..*"},
+				{Action: stats.RelabelDropMeasure, Regex: ".*\\.debug"},
+			},
+			measures: []stats.Measure{
+				{Name: "http.req"},
+				{Name: "http.req.debug"},
+				{Name: "redis.query"},
+			},
+			expected: []stats.Measure{
+				{Name: "http.req"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			h := &statstest.Handler{}
+			r, err := stats.NewRelabeler(h, test.rules...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r.HandleMeasures(time.Now(), test.measures...)
+			found := h.Measures()
+
+			for i := range found {
+				if len(found[i].Tags) == 0 {
+					found[i].Tags = nil
+				}
+			}
+
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Error("bad measures:")
+				t.Logf("expected: %v", test.expected)
+				t.Logf("found:    %v", found)
+			}
+		})
+	}
+}
+
+func TestRelabelerDoesNotModifyMeasures(t *testing.T) {
+	r, _ := stats.NewRelabeler(stats.Discard,
+		stats.RelabelRule{Action: stats.RelabelRenameTag, Tag: "a", Target: "z"},
+		stats.RelabelRule{Action: stats.RelabelSetTag, Target: "b", Replacement: "0"},
+	)
+
+	tags := []stats.Tag{{"a", "1"}, {"b", "2"}}
+	r.HandleMeasures(time.Now(), stats.Measure{Name: "m", Tags: tags})
+
+	if !reflect.DeepEqual(tags, []stats.Tag{{"a", "1"}, {"b", "2"}}) {
+		t.Error("the relabeler modified the tags of the measure it received:", tags)
+	}
+}
+
+func TestRelabelerInvalidRules(t *testing.T) {
+	rules := []stats.RelabelRule{
+		{Action: stats.RelabelRenameTag, Tag: "a"},
+		{Action: stats.RelabelDropTag},
+		{Action: stats.RelabelSetTag, Replacement: "a"},
+		{Action: stats.RelabelReplace, Tag: "a"},
+		{Action: stats.RelabelReplace, Tag: "a", Regex: "("},
+		{Action: stats.RelabelDropMeasure},
+		{Action: stats.RelabelAction(-1)},
+	}
+
+	for _, rule := range rules {
+		if _, err := stats.NewRelabeler(stats.Discard, rule); err == nil {
+			t.Errorf("expected an error for an invalid rule: %+v", rule)
+		}
+	}
+}
+
+func TestRelabelerFlush(t *testing.T) {
+	h := &statstest.Handler{}
+	r, _ := stats.NewRelabeler(h)
+	r.Flush()
+
+	if n := h.FlushCalls(); n != 1 {
+		t.Error("bad number of calls to Flush:", n)
+	}
+}
+
+func BenchmarkRelabeler(b *testing.B) {
+	r, _ := stats.NewRelabeler(stats.Discard,
+		stats.RelabelRule{Action: stats.RelabelDropTag, Tag: "http_req_id"},
+		stats.RelabelRule{Action: stats.RelabelRenameTag, Tag: "host", Target: "instance"},
+		stats.RelabelRule{Action: stats.RelabelSetTag, Target: "env", Replacement: "prod"},
+	)
+
+	t := time.Now()
+	m := stats.Measure{
+		Name:   "http.req",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{{"host", "localhost"}, {"http_req_id", "42"}, {"http_req_method", "GET"}},
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.HandleMeasures(t, m)
+		}
+	})
+}
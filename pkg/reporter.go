@@ -0,0 +1,61 @@
+package stats
+
+import "log"
+
+// ClientReporter reports the failures of the clients sending measures to
+// monitoring backends, it is used by the clients of the backend packages so
+// they all report their errors and metrics the same way.
+//
+// Errors are passed to the error handler, or logged if there is none. The
+// failed attempts that are retried are reported as errors as well, and counted
+// in a "retries.count" counter field of a measure named "stats.client" on the
+// stats engine. The engine usually also receives the metrics of the buffer of
+// the client (see Buffer.StatsEngine), the metrics are tagged with "client" set
+// to the name of the client. The engine must not forward measures to the
+// client, which would report its own failures to itself.
+//
+// The methods call the error handler and the engine synchronously, clients
+// must not call them while holding locks.
+type ClientReporter struct {
+	client string
+	errors func(error)
+	stats  *Engine
+}
+
+// NewClientReporter creates and returns a new reporter for the client with the
+// given name. The errors function and the engine may be nil, in which case the
+// errors are logged and no metrics are reported.
+func NewClientReporter(client string, errors func(error), eng *Engine) *ClientReporter {
+	r := &ClientReporter{
+		client: client,
+		errors: errors,
+	}
+
+	if eng != nil {
+		r.stats = eng.WithTags(T("client", client))
+	}
+
+	return r
+}
+
+// Engine returns the engine on which the metrics of the client are reported, or
+// nil if it has none.
+func (r *ClientReporter) Engine() *Engine {
+	return r.stats
+}
+
+// HandleError reports err.
+func (r *ClientReporter) HandleError(err error) {
+	if r.errors != nil {
+		r.errors(err)
+	} else {
+		log.Printf("stats/%s: %s", r.client, err)
+	}
+}
+
+// Retried reports that the client retried sending metrics after a failure.
+func (r *ClientReporter) Retried() {
+	if r.stats != nil {
+		r.stats.Incr("stats.client:retries.count")
+	}
+}
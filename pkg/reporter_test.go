@@ -0,0 +1,41 @@
+package stats_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestClientReporter(t *testing.T) {
+	var errs []error
+
+	h := &statstest.Handler{}
+	r := stats.NewClientReporter("test", func(err error) { errs = append(errs, err) }, stats.NewEngine("", h))
+
+	r.HandleError(errors.New("oops"))
+	r.Retried()
+
+	if len(errs) != 1 || errs[0].Error() != "oops" {
+		t.Error("bad errors:", errs)
+	}
+
+	expect := []stats.Measure{{
+		Name:   "stats.client",
+		Fields: []stats.Field{stats.MakeField("retries.count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("client", "test")},
+	}}
+
+	if found := h.Measures(); !reflect.DeepEqual(found, expect) {
+		t.Error("bad measures:", found)
+	}
+
+	if r.Engine() == nil {
+		t.Error("the reporter has no engine")
+	}
+
+	// Without an engine, retries are not reported.
+	stats.NewClientReporter("test", nil, nil).Retried()
+}
@@ -0,0 +1,260 @@
+package stats
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route represents a rule of a Router, measures matching all the conditions
+// set on the route are forwarded to its handler.
+type Route struct {
+	// Name is the pattern that the names of measures must match, where "*"
+	// matches any sequence of characters (for example "http.*" matches the
+	// measures with a name starting with "http."). If empty, all names match.
+	Name string
+
+	// Tag is the name of a tag that measures must have. If empty, measures
+	// match regardless of their tags.
+	Tag string
+
+	// Value is the value that the tag named Tag must have. If empty, the tag
+	// may have any value.
+	Value string
+
+	// Handler is the handler that matching measures are forwarded to.
+	//
+	// This field cannot be nil.
+	Handler Handler
+
+	// Continue controls whether measures matching the route are also matched
+	// against the next routes. By default, the first matching route wins.
+	Continue bool
+}
+
+// Router is the implementation of a measure handler which dispatches measures
+// to different handlers depending on their name and tags, for example to send
+// measures produced by HTTP servers to one backend and business metrics to
+// another one.
+//
+// Routes are matched in order, measures that didn't match any route are
+// forwarded to the default handler of the router. Each handler receives the
+// measures routed to it in a single call to HandleMeasures, and never receives
+// the same measure twice.
+//
+// Routers are safe to use concurrently from multiple goroutines.
+type Router struct {
+	routes   []route
+	handlers []Handler // distinct handlers, indexed by the routes
+	fallback int       // index of the default handler, or -1
+	pool     sync.Pool
+}
+
+type route struct {
+	Route
+	name    namePattern
+	handler int
+}
+
+// NewRouter creates and returns a new router which forwards measures to the
+// handler of the first route they match, or to fallback if they didn't match
+// any route. If fallback is nil, the measures that didn't match are dropped.
+// An error is returned if one of the routes is invalid.
+func NewRouter(fallback Handler, routes ...Route) (*Router, error) {
+	r := &Router{
+		routes:   make([]route, len(routes)),
+		fallback: -1,
+	}
+
+	for i, config := range routes {
+		if config.Handler == nil {
+			return nil, fmt.Errorf("stats: route #%d (%s): the handler must be set", i, config.Name)
+		}
+
+		if len(config.Value) != 0 && len(config.Tag) == 0 {
+			return nil, fmt.Errorf("stats: route #%d (%s): the tag must be set when the value is", i, config.Name)
+		}
+
+		r.routes[i] = route{
+			Route:   config,
+			name:    compileNamePattern(config.Name),
+			handler: r.addHandler(config.Handler),
+		}
+	}
+
+	if fallback != nil {
+		r.fallback = r.addHandler(fallback)
+	}
+
+	n := len(r.handlers)
+	r.pool.New = func() interface{} {
+		return &routerBuffer{
+			batches: make([][]Measure, n),
+			last:    make([]int, n),
+		}
+	}
+
+	return r, nil
+}
+
+// addHandler returns the index of h in the list of handlers of the router,
+// adding it if it wasn't there yet.
+//
+// Only pointer handlers are deduplicated. Comparing other handlers may panic,
+// for example when they are structs holding a HandlerFunc in an interface
+// field, so they are always considered distinct.
+func (r *Router) addHandler(h Handler) int {
+	if v := reflect.ValueOf(h); v.Kind() == reflect.Ptr {
+		for i, x := range r.handlers {
+			if w := reflect.ValueOf(x); w.Type() == v.Type() && w.Pointer() == v.Pointer() {
+				return i
+			}
+		}
+	}
+	r.handlers = append(r.handlers, h)
+	return len(r.handlers) - 1
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (r *Router) HandleMeasures(time time.Time, measures ...Measure) {
+	buf := r.pool.Get().(*routerBuffer)
+
+	for i := range buf.last {
+		buf.last[i] = -1
+	}
+
+	for i, m := range measures {
+		matched := false
+
+		for j := range r.routes {
+			if rt := &r.routes[j]; rt.match(&m) {
+				buf.add(rt.handler, i, m)
+				matched = true
+
+				if !rt.Continue {
+					break
+				}
+			}
+		}
+
+		if !matched && r.fallback >= 0 {
+			buf.add(r.fallback, i, m)
+		}
+	}
+
+	for i, batch := range buf.batches {
+		if len(batch) != 0 {
+			r.handlers[i].HandleMeasures(time, batch...)
+		}
+	}
+
+	buf.reset()
+	r.pool.Put(buf)
+}
+
+// Flush satisfies the Flusher interface, it flushes each of the handlers that
+// measures are routed to.
+func (r *Router) Flush() {
+	for _, h := range r.handlers {
+		flush(h)
+	}
+}
+
+func (rt *route) match(m *Measure) bool {
+	if !rt.name.match(m.Name) {
+		return false
+	}
+
+	if len(rt.Tag) != 0 {
+		i := tagIndex(m.Tags, rt.Tag)
+
+		if i < 0 || (len(rt.Value) != 0 && m.Tags[i].Value != rt.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namePattern is the compiled form of the glob patterns matched against the
+// names of measures. The pattern is split on "*" characters, names must start
+// with the prefix, end with the suffix, and contain the parts in between in
+// order.
+type namePattern struct {
+	exact  bool
+	prefix string
+	suffix string
+	parts  []string
+}
+
+func compileNamePattern(pattern string) namePattern {
+	if len(pattern) == 0 {
+		return namePattern{}
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	if len(parts) == 1 {
+		return namePattern{exact: true, prefix: pattern}
+	}
+
+	p := namePattern{
+		prefix: parts[0],
+		suffix: parts[len(parts)-1],
+	}
+
+	for _, part := range parts[1 : len(parts)-1] {
+		if len(part) != 0 {
+			p.parts = append(p.parts, part)
+		}
+	}
+
+	return p
+}
+
+func (p *namePattern) match(name string) bool {
+	if p.exact {
+		return name == p.prefix
+	}
+
+	if len(name) < len(p.prefix)+len(p.suffix) ||
+		!strings.HasPrefix(name, p.prefix) ||
+		!strings.HasSuffix(name, p.suffix) {
+		return false
+	}
+
+	name = name[len(p.prefix) : len(name)-len(p.suffix)]
+
+	for _, part := range p.parts {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+
+	return true
+}
+
+type routerBuffer struct {
+	batches [][]Measure
+	last    []int // index of the last measure added to each batch
+}
+
+func (buf *routerBuffer) add(handler int, index int, m Measure) {
+	if buf.last[handler] != index {
+		buf.last[handler] = index
+		buf.batches[handler] = append(buf.batches[handler], m)
+	}
+}
+
+func (buf *routerBuffer) reset() {
+	for i, batch := range buf.batches {
+		for j := range batch {
+			batch[j] = Measure{}
+		}
+		buf.batches[i] = batch[:0]
+	}
+}
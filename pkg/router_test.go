@@ -0,0 +1,153 @@
+package stats_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func names(measures []stats.Measure) []string {
+	n := make([]string, len(measures))
+	for i, m := range measures {
+		n[i] = m.Name
+	}
+	return n
+}
+
+func TestRouter(t *testing.T) {
+	prom := &statstest.Handler{}
+	influx := &statstest.Handler{}
+	datadog := &statstest.Handler{}
+	other := &statstest.Handler{}
+
+	r, err := stats.NewRouter(other,
+		stats.Route{Handler: datadog, Continue: true},
+		stats.Route{Name: "http.*", Handler: prom},
+		stats.Route{Tag: "kpi", Value: "true", Handler: influx},
+		stats.Route{Name: "*.orders.*.count", Tag: "shop", Handler: influx},
+		stats.Route{Name: "api", Handler: prom, Continue: true},
+		stats.Route{Name: "api", Handler: prom},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.HandleMeasures(time.Now(),
+		stats.Measure{Name: "http.requests"},
+		stats.Measure{Name: "signups", Tags: []stats.Tag{stats.T("kpi", "true")}},
+		stats.Measure{Name: "logins", Tags: []stats.Tag{stats.T("kpi", "false")}},
+		stats.Measure{Name: "eu.orders.paid.count", Tags: []stats.Tag{stats.T("shop", "1")}},
+		stats.Measure{Name: "eu.orders.paid.count"},
+		stats.Measure{Name: "api"},
+	)
+
+	tests := []struct {
+		handler *statstest.Handler
+		names   []string
+	}{
+		{prom, []string{"http.requests", "api"}},
+		{influx, []string{"signups", "eu.orders.paid.count"}},
+		{datadog, []string{"http.requests", "signups", "logins", "eu.orders.paid.count", "eu.orders.paid.count", "api"}},
+		{other, nil},
+	}
+
+	for i, test := range tests {
+		if found := names(test.handler.Measures()); len(found) != len(test.names) || (len(found) != 0 && !reflect.DeepEqual(found, test.names)) {
+			t.Errorf("handler #%d: bad measures: %v", i, found)
+		}
+	}
+
+	r.Flush()
+
+	for i, test := range tests {
+		if n := test.handler.FlushCalls(); n != 1 {
+			t.Errorf("handler #%d: bad number of calls to Flush: %d", i, n)
+		}
+	}
+}
+
+// wrappedHandler is a comparable handler type which holds a handler that may
+// not be comparable.
+type wrappedHandler struct {
+	stats.Handler
+}
+
+func TestRouterNonComparableHandlers(t *testing.T) {
+	var calls int
+
+	f := stats.HandlerFunc(func(time.Time, ...stats.Measure) { calls++ })
+
+	r, err := stats.NewRouter(nil,
+		stats.Route{Name: "a", Handler: wrappedHandler{f}},
+		stats.Route{Name: "b", Handler: wrappedHandler{f}},
+		stats.Route{Name: "c", Handler: f},
+		stats.Route{Name: "d", Handler: f},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.HandleMeasures(time.Now(),
+		stats.Measure{Name: "a"},
+		stats.Measure{Name: "b"},
+		stats.Measure{Name: "c"},
+		stats.Measure{Name: "d"},
+	)
+
+	if calls != 4 {
+		t.Error("bad number of calls to the handlers:", calls)
+	}
+}
+
+func TestRouterFallback(t *testing.T) {
+	prom := &statstest.Handler{}
+	other := &statstest.Handler{}
+
+	r, err := stats.NewRouter(other, stats.Route{Name: "http.*", Handler: prom})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.HandleMeasures(time.Now(), stats.Measure{Name: "http.requests"}, stats.Measure{Name: "signups"})
+
+	if found := names(other.Measures()); !reflect.DeepEqual(found, []string{"signups"}) {
+		t.Error("bad measures:", found)
+	}
+}
+
+func TestRouterInvalid(t *testing.T) {
+	routes := []stats.Route{
+		{Name: "http.*"},
+		{Value: "true", Handler: stats.Discard},
+	}
+
+	for _, route := range routes {
+		if _, err := stats.NewRouter(nil, route); err == nil {
+			t.Errorf("no error returned for invalid route %+v", route)
+		}
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	r, _ := stats.NewRouter(stats.Discard,
+		stats.Route{Name: "http.*", Handler: stats.Discard},
+		stats.Route{Tag: "kpi", Handler: stats.Discard},
+	)
+
+	t := time.Now()
+	m := []stats.Measure{
+		{Name: "http.requests", Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)}},
+		{Name: "signups", Tags: []stats.Tag{stats.T("kpi", "true")}},
+		{Name: "logins"},
+	}
+
+	for i := 0; i != b.N; i++ {
+		r.HandleMeasures(t, m...)
+	}
+}
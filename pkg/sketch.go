@@ -0,0 +1,404 @@
+package stats
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// DefaultSketchAccuracy is the relative accuracy used by sketches that
+	// were not configured with an explicit one.
+	DefaultSketchAccuracy = 0.01
+
+	// MaxSketchBuckets is the maximum number of buckets that a sketch keeps for
+	// positive and negative values. When the limit is reached the buckets of
+	// the values closest to zero are merged together, trading accuracy on the
+	// lowest quantiles for a bounded memory footprint.
+	MaxSketchBuckets = 2048
+)
+
+var (
+	errSketchAccuracyMismatch = errors.New("stats: cannot merge sketches with different relative accuracies")
+	errSketchMalformed        = errors.New("stats: malformed sketch encoding")
+)
+
+// Sketch is a mergeable data structure which estimates quantiles of a stream of
+// values within a configured relative accuracy, using the DDSketch algorithm.
+//
+// Values are counted in logarithmically sized buckets, which means that a
+// quantile estimated from a sketch with a relative accuracy of 1% will be
+// within 1% of the exact value. Sketches with the same relative accuracy can be
+// merged together, for example to combine the sketches serialized by multiple
+// processes.
+//
+// The zero-value is a valid sketch using DefaultSketchAccuracy. Sketches are
+// not safe to use concurrently from multiple goroutines.
+type Sketch struct {
+	accuracy   float64
+	gamma      float64
+	logGamma   float64
+	count      uint64
+	zeros      uint64
+	sum        float64
+	min        float64
+	max        float64
+	positive   sketchStore
+	negative   sketchStore
+	maxBuckets int
+}
+
+// NewSketch creates and returns a new sketch which estimates quantiles within
+// the given relative accuracy, which must be a value between 0 and 1.
+func NewSketch(accuracy float64) *Sketch {
+	s := &Sketch{}
+	s.init(accuracy)
+	return s
+}
+
+func (s *Sketch) init(accuracy float64) {
+	if !(accuracy > 0 && accuracy < 1) {
+		panic("stats.NewSketch: relative accuracy must be between 0 and 1")
+	}
+	s.accuracy = accuracy
+	s.gamma = (1 + accuracy) / (1 - accuracy)
+	s.logGamma = math.Log(s.gamma)
+	s.maxBuckets = MaxSketchBuckets
+}
+
+func (s *Sketch) prepare() {
+	if s.gamma == 0 {
+		s.init(DefaultSketchAccuracy)
+	}
+}
+
+// Accuracy returns the relative accuracy of s.
+func (s *Sketch) Accuracy() float64 {
+	s.prepare()
+	return s.accuracy
+}
+
+// Add adds v to the set of values observed by s.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+
+	s.prepare()
+
+	switch {
+	case v > 0:
+		s.positive.add(s.index(v), 1, s.maxBuckets)
+	case v < 0:
+		s.negative.add(s.index(-v), 1, s.maxBuckets)
+	default:
+		s.zeros++
+	}
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+
+	s.count++
+	s.sum += v
+}
+
+// Merge adds all values observed by other to s. Both sketches must have the
+// same relative accuracy.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.count == 0 {
+		return nil
+	}
+
+	s.prepare()
+	other.prepare()
+
+	if s.gamma != other.gamma {
+		return errSketchAccuracyMismatch
+	}
+
+	for i, n := range other.positive.counts {
+		if n != 0 {
+			s.positive.add(other.positive.offset+i, n, s.maxBuckets)
+		}
+	}
+
+	for i, n := range other.negative.counts {
+		if n != 0 {
+			s.negative.add(other.negative.offset+i, n, s.maxBuckets)
+		}
+	}
+
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+
+	if s.count == 0 || other.max > s.max {
+		s.max = other.max
+	}
+
+	s.zeros += other.zeros
+	s.count += other.count
+	s.sum += other.sum
+	return nil
+}
+
+// Quantile returns an estimation of the value at quantile q, which must be
+// between 0 and 1. The method returns zero if s has not observed any values.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	switch {
+	case q <= 0:
+		return s.min
+	case q >= 1:
+		return s.max
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	seen := uint64(0)
+
+	// Negative values are stored by magnitude, the lowest values are in the
+	// buckets with the highest indexes.
+	for i := len(s.negative.counts) - 1; i >= 0; i-- {
+		if seen += s.negative.counts[i]; seen > rank {
+			return s.clamp(-s.value(s.negative.offset + i))
+		}
+	}
+
+	if seen += s.zeros; seen > rank {
+		return 0
+	}
+
+	for i, n := range s.positive.counts {
+		if seen += n; seen > rank {
+			return s.clamp(s.value(s.positive.offset + i))
+		}
+	}
+
+	return s.max
+}
+
+// Count returns the number of values observed by s.
+func (s *Sketch) Count() uint64 { return s.count }
+
+// Sum returns the sum of values observed by s.
+func (s *Sketch) Sum() float64 { return s.sum }
+
+// Min returns the lowest value observed by s.
+func (s *Sketch) Min() float64 { return s.min }
+
+// Max returns the highest value observed by s.
+func (s *Sketch) Max() float64 { return s.max }
+
+// Reset clears the values observed by s, retaining the memory it had allocated
+// so it can be reused.
+func (s *Sketch) Reset() {
+	s.positive.reset()
+	s.negative.reset()
+	s.count = 0
+	s.zeros = 0
+	s.sum = 0
+	s.min = 0
+	s.max = 0
+}
+
+// MarshalBinary satisfies the encoding.BinaryMarshaler interface.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	return s.AppendBinary(nil), nil
+}
+
+// AppendBinary appends the binary representation of s to b and returns the
+// extended buffer.
+func (s *Sketch) AppendBinary(b []byte) []byte {
+	s.prepare()
+	b = appendSketchFloat(b, s.accuracy)
+	b = appendSketchUvarint(b, s.count)
+	b = appendSketchUvarint(b, s.zeros)
+	b = appendSketchFloat(b, s.sum)
+	b = appendSketchFloat(b, s.min)
+	b = appendSketchFloat(b, s.max)
+	b = s.positive.appendBinary(b)
+	b = s.negative.appendBinary(b)
+	return b
+}
+
+// UnmarshalBinary satisfies the encoding.BinaryUnmarshaler interface.
+func (s *Sketch) UnmarshalBinary(b []byte) error {
+	var accuracy float64
+	var ok bool
+
+	if accuracy, b, ok = readSketchFloat(b); !ok || !(accuracy > 0 && accuracy < 1) {
+		return errSketchMalformed
+	}
+
+	s.init(accuracy)
+	s.Reset()
+
+	if s.count, b, ok = readSketchUvarint(b); !ok {
+		return errSketchMalformed
+	}
+	if s.zeros, b, ok = readSketchUvarint(b); !ok {
+		return errSketchMalformed
+	}
+	if s.sum, b, ok = readSketchFloat(b); !ok {
+		return errSketchMalformed
+	}
+	if s.min, b, ok = readSketchFloat(b); !ok {
+		return errSketchMalformed
+	}
+	if s.max, b, ok = readSketchFloat(b); !ok {
+		return errSketchMalformed
+	}
+	if b, ok = s.positive.readBinary(b); !ok {
+		return errSketchMalformed
+	}
+	if b, ok = s.negative.readBinary(b); !ok {
+		return errSketchMalformed
+	}
+	if len(b) != 0 {
+		return errSketchMalformed
+	}
+	return nil
+}
+
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+func (s *Sketch) value(i int) float64 {
+	// Returns the value in the middle of the bucket (in relative terms), which
+	// is what guarantees the relative accuracy of the estimations.
+	return 2 * math.Pow(s.gamma, float64(i)) / (1 + s.gamma)
+}
+
+func (s *Sketch) clamp(v float64) float64 {
+	switch {
+	case v < s.min:
+		return s.min
+	case v > s.max:
+		return s.max
+	default:
+		return v
+	}
+}
+
+type sketchStore struct {
+	offset int
+	counts []uint64
+}
+
+func (s *sketchStore) add(index int, count uint64, maxBuckets int) {
+	switch {
+	case len(s.counts) == 0:
+		s.offset = index
+		s.counts = append(s.counts[:0], 0)
+
+	case index < s.offset:
+		if n := s.offset - index; len(s.counts)+n > maxBuckets {
+			// The value falls below the range of buckets that the store can
+			// grow to, it gets counted in the lowest bucket.
+			if len(s.counts) >= maxBuckets {
+				s.counts[0] += count
+				return
+			}
+			index = s.offset - (maxBuckets - len(s.counts))
+		}
+		n := s.offset - index
+		s.counts = append(s.counts, make([]uint64, n)...)
+		copy(s.counts[n:], s.counts[:len(s.counts)-n])
+		for i := 0; i != n; i++ {
+			s.counts[i] = 0
+		}
+		s.offset = index
+
+	case index >= s.offset+len(s.counts):
+		s.counts = append(s.counts, make([]uint64, index-s.offset-len(s.counts)+1)...)
+
+		if n := len(s.counts) - maxBuckets; n > 0 {
+			// Collapse the buckets closest to zero to make room for the new
+			// highest bucket.
+			for i := 0; i != n; i++ {
+				s.counts[n] += s.counts[i]
+			}
+			s.counts = s.counts[:copy(s.counts, s.counts[n:])]
+			s.offset += n
+		}
+	}
+
+	s.counts[index-s.offset] += count
+}
+
+func (s *sketchStore) reset() {
+	for i := range s.counts {
+		s.counts[i] = 0
+	}
+}
+
+func (s *sketchStore) appendBinary(b []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	b = append(b, tmp[:binary.PutVarint(tmp[:], int64(s.offset))]...)
+	b = appendSketchUvarint(b, uint64(len(s.counts)))
+	for _, n := range s.counts {
+		b = appendSketchUvarint(b, n)
+	}
+	return b
+}
+
+func (s *sketchStore) readBinary(b []byte) ([]byte, bool) {
+	offset, n := binary.Varint(b)
+	if n <= 0 {
+		return b, false
+	}
+	b = b[n:]
+
+	size, b, ok := readSketchUvarint(b)
+	if !ok || size > uint64(len(b)) {
+		return b, false
+	}
+
+	s.offset = int(offset)
+	s.counts = s.counts[:0]
+
+	for i := uint64(0); i != size; i++ {
+		var count uint64
+		if count, b, ok = readSketchUvarint(b); !ok {
+			return b, false
+		}
+		s.counts = append(s.counts, count)
+	}
+
+	return b, true
+}
+
+func appendSketchFloat(b []byte, f float64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(f))
+	return append(b, tmp[:]...)
+}
+
+func readSketchFloat(b []byte) (float64, []byte, bool) {
+	if len(b) < 8 {
+		return 0, b, false
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:], true
+}
+
+func appendSketchUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func readSketchUvarint(b []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, b, false
+	}
+	return v, b[n:], true
+}
@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSketchQuantiles(t *testing.T) {
+	tests := []struct {
+		scenario string
+		values   func(int) float64
+	}{
+		{
+			scenario: "positive values",
+			values:   func(i int) float64 { return float64(i + 1) },
+		},
+		{
+			scenario: "negative values",
+			values:   func(i int) float64 { return -float64(i + 1) },
+		},
+		{
+			scenario: "mixed values",
+			values:   func(i int) float64 { return float64(i - 500) },
+		},
+		{
+			scenario: "random values",
+			values:   func(int) float64 { return rand.ExpFloat64() },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			s := NewSketch(0.01)
+			v := make([]float64, 1000)
+
+			for i := range v {
+				v[i] = test.values(i)
+				s.Add(v[i])
+			}
+
+			sort.Float64s(v)
+			checkSketchQuantiles(t, s, v)
+		})
+	}
+}
+
+func TestSketchZeroValue(t *testing.T) {
+	s := Sketch{}
+	s.Add(1)
+	s.Add(2)
+	s.Add(3)
+
+	if a := s.Accuracy(); a != DefaultSketchAccuracy {
+		t.Error("bad default accuracy:", a)
+	}
+
+	if n := s.Count(); n != 3 {
+		t.Error("bad count:", n)
+	}
+
+	if sum := s.Sum(); sum != 6 {
+		t.Error("bad sum:", sum)
+	}
+
+	if min, max := s.Min(), s.Max(); min != 1 || max != 3 {
+		t.Error("bad min and max:", min, max)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	s1 := NewSketch(0.01)
+	s2 := NewSketch(0.01)
+	v := make([]float64, 0, 2000)
+
+	for i := 0; i != 1000; i++ {
+		v1 := float64(i)
+		v2 := float64(i) * 10
+		s1.Add(v1)
+		s2.Add(v2)
+		v = append(v, v1, v2)
+	}
+
+	if err := s1.Merge(s2); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Float64s(v)
+	checkSketchQuantiles(t, s1, v)
+
+	if err := s1.Merge(NewSketch(0.05)); err != nil {
+		t.Error("merging an empty sketch must not fail:", err)
+	}
+
+	s3 := NewSketch(0.05)
+	s3.Add(1)
+
+	if err := s1.Merge(s3); err != errSketchAccuracyMismatch {
+		t.Error("bad error when merging sketches with different accuracies:", err)
+	}
+}
+
+func TestSketchBinaryEncoding(t *testing.T) {
+	s1 := NewSketch(0.02)
+
+	for i := -100; i != 1000; i++ {
+		s1.Add(float64(i) / 3)
+	}
+
+	b, err := s1.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := &Sketch{}
+
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1} {
+		if q1, q2 := s1.Quantile(q), s2.Quantile(q); q1 != q2 {
+			t.Errorf("quantile %g mismatch after decoding: %g != %g", q, q1, q2)
+		}
+	}
+
+	if err := s2.UnmarshalBinary(b[:len(b)-1]); err != errSketchMalformed {
+		t.Error("bad error when decoding a truncated sketch:", err)
+	}
+}
+
+func TestSketchMaxBuckets(t *testing.T) {
+	s := NewSketch(0.01)
+	s.maxBuckets = 10
+
+	for i := 0; i != 1000; i++ {
+		s.Add(math.Pow(2, float64(i%100)))
+	}
+
+	if n := len(s.positive.counts); n > 10 {
+		t.Error("too many buckets:", n)
+	}
+
+	if n := s.Count(); n != 1000 {
+		t.Error("bad count:", n)
+	}
+
+	if q := s.Quantile(0.995); math.Abs(q-math.Pow(2, 99))/math.Pow(2, 99) > 0.01 {
+		t.Error("bad estimation of the highest quantiles:", q)
+	}
+}
+
+func checkSketchQuantiles(t *testing.T, s *Sketch, sorted []float64) {
+	for _, q := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99} {
+		exact := sorted[int(q*float64(len(sorted)-1))]
+		found := s.Quantile(q)
+
+		if math.Abs(found-exact) > math.Abs(exact)*s.Accuracy() {
+			t.Errorf("quantile %g is out of bounds: expected %g, found %g", q, exact, found)
+		}
+	}
+}
+
+func BenchmarkSketchAdd(b *testing.B) {
+	s := NewSketch(0.01)
+
+	for i := 0; i != b.N; i++ {
+		s.Add(float64(i % 1000))
+	}
+}
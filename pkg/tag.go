@@ -37,12 +37,13 @@ func TagsAreSorted(tags []Tag) bool {
 	return true
 }
 
-// SortTags sorts the slice of tags.
+// SortTags sorts the slice of tags. The sort is stable, tags with the same name
+// remain in the order they had in the slice.
 func SortTags(tags []Tag) []Tag {
 	// Insertion sort since these arrays are very small and allocation is the
 	// primary enemy of performance here.
 	if len(tags) >= 20 {
-		sort.Sort(tagsByName(tags))
+		sort.Stable(tagsByName(tags))
 	} else {
 		for i := 0; i < len(tags); i++ {
 			for j := i; j > 0 && tags[j-1].Name > tags[j].Name; j-- {
@@ -59,6 +60,28 @@ func (t tagsByName) Len() int               { return len(t) }
 func (t tagsByName) Less(i int, j int) bool { return t[i].Name < t[j].Name }
 func (t tagsByName) Swap(i int, j int)      { t[i], t[j] = t[j], t[i] }
 
+// mergeTags sorts tags and removes the tags with the same name as one of the
+// tags that follow them, so the last value set for a tag wins. The slice is
+// modified in place.
+func mergeTags(tags []Tag) []Tag {
+	SortTags(tags)
+	n := 0
+
+	for i := range tags {
+		if i+1 < len(tags) && tags[i+1].Name == tags[i].Name {
+			continue
+		}
+		tags[n] = tags[i]
+		n++
+	}
+
+	for i := n; i < len(tags); i++ {
+		tags[i] = Tag{}
+	}
+
+	return tags[:n]
+}
+
 func concatTags(t1 []Tag, t2 []Tag) []Tag {
 	n := len(t1) + len(t2)
 	if n == 0 {
@@ -90,10 +113,6 @@ func (b *tagsBuffer) reset() {
 	b.tags = b.tags[:0]
 }
 
-func (b *tagsBuffer) sort() {
-	sort.Sort(&b.tags)
-}
-
 func (b *tagsBuffer) append(tags ...Tag) {
 	b.tags = append(b.tags, tags...)
 }
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// OutcomeSuccess is the value of the "outcome" tag of timers that ended
+	// without an error.
+	OutcomeSuccess = "success"
+
+	// OutcomeError is the value of the "outcome" tag of timers that ended with
+	// an error.
+	OutcomeError = "error"
+)
+
+// The Timer type is used to report the duration and outcome of operations,
+// like database queries or calls to remote services.
+//
+// Timers are created by calls to Engine.Start, which returns a context carrying
+// the tags of the timer, so the operations nested in the one being measured
+// (the timers they start or the measures they produce with the context-aware
+// methods of engines) share the same tags. The typical pattern is:
+//
+//	func query(ctx context.Context) (err error) {
+//		ctx, t := stats.Start(ctx, "db.query", stats.T("table", "users"))
+//		defer t.End(&err)
+//		...
+//	}
+//
+// When the timer ends, it produces a measure named after the operation with a
+// "duration" histogram field and a "count" counter field, tagged with "outcome"
+// set to OutcomeSuccess or OutcomeError.
+//
+// Unlike clocks, timers are safe to use concurrently from multiple goroutines.
+type Timer struct {
+	eng   *Engine
+	ctx   context.Context
+	name  string
+	start time.Time
+	tags  []Tag
+	ended uint32
+}
+
+// Start starts a timer measuring the operation identified by name, tags, and
+// the tags carried by ctx. The method returns a copy of ctx carrying tags, that
+// should be passed to the nested operations, and the timer.
+func (eng *Engine) Start(ctx context.Context, name string, tags ...Tag) (context.Context, *Timer) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx = ContextWithTags(ctx, tags...)
+
+	// The tags of the context override the tags of the engine, and the outcome
+	// tag is always the one set when the timer ends.
+	timerTags := mergeTags(concatTags(eng.Tags, TagsFromContext(ctx)))
+
+	if i := tagIndex(timerTags, "outcome"); i >= 0 {
+		timerTags = append(timerTags[:i], timerTags[i+1:]...)
+	}
+
+	return ctx, &Timer{
+		eng:   eng,
+		ctx:   ctx,
+		name:  eng.makeName(name),
+		start: time.Now(),
+		tags:  timerTags,
+	}
+}
+
+// Start starts a child timer of t, measuring the operation identified by name
+// and tags, which inherits the tags of t (tags passed to the child override the
+// tags of t with the same name). It is equivalent to calling Start on the
+// engine of t with the context returned when t was started.
+func (t *Timer) Start(name string, tags ...Tag) (context.Context, *Timer) {
+	return t.eng.Start(t.ctx, name, tags...)
+}
+
+// Context returns the context carrying the tags of t.
+func (t *Timer) Context() context.Context {
+	return t.ctx
+}
+
+// End stops t and reports the duration of the operation. The outcome of the
+// operation is an error if err points to a non-nil error, err may be nil when
+// the operation cannot fail.
+//
+// Only the first call to End or EndAt has an effect.
+func (t *Timer) End(err *error) {
+	t.EndAt(time.Now(), err)
+}
+
+// EndAt is like End but reports the duration of the operation as if it ended at
+// the given time.
+func (t *Timer) EndAt(now time.Time, err *error) {
+	if !atomic.CompareAndSwapUint32(&t.ended, 0, 1) {
+		return
+	}
+
+	rate := t.eng.SampleRate
+
+	if !sample(rate) {
+		return
+	}
+
+	outcome := OutcomeSuccess
+
+	if err != nil && *err != nil {
+		outcome = OutcomeError
+	}
+
+	mp := measureArrayPool.Get().(*[1]Measure)
+
+	m := &(*mp)[0]
+	m.Name = t.name
+	m.Fields = append(m.Fields[:0],
+		MakeField("duration", now.Sub(t.start), Histogram).WithRate(rate),
+		MakeField("count", 1, Counter).WithRate(rate),
+	)
+	m.Tags = append(m.Tags[:0], t.tags...)
+	m.Tags = append(m.Tags, T("outcome", outcome))
+	SortTags(m.Tags)
+
+	t.eng.handler().HandleMeasures(now, (*mp)[:]...)
+
+	for i := range m.Fields {
+		m.Fields[i] = Field{}
+	}
+
+	for i := range m.Tags {
+		m.Tags[i] = Tag{}
+	}
+
+	m.Name = ""
+	measureArrayPool.Put(mp)
+}
+
+// Start starts a timer on the default engine, see Engine.Start for details.
+func Start(ctx context.Context, name string, tags ...Tag) (context.Context, *Timer) {
+	return DefaultEngine.Start(ctx, name, tags...)
+}
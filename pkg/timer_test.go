@@ -0,0 +1,131 @@
+package stats_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+func TestTimer(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h, stats.T("service", "api"))
+
+	ctx := stats.ContextWithTags(context.Background(), stats.T("tenant", "acme"))
+	start := time.Now()
+
+	query := func(ctx context.Context, fail bool) (err error) {
+		_, timer := eng.Start(ctx, "db.query", stats.T("table", "users"))
+		defer timer.EndAt(start.Add(time.Second), &err)
+
+		if fail {
+			err = errors.New("oops")
+		}
+		return
+	}
+
+	ctx, timer := eng.Start(ctx, "request", stats.T("path", "/"))
+	query(ctx, false)
+	query(ctx, true)
+	eng.IncrContext(ctx, "cache.hits")
+	timer.End(nil)
+	timer.End(nil) // ending a timer twice has no effect
+
+	found := h.Measures()
+
+	if len(found) != 4 {
+		t.Fatal("bad number of measures:", found)
+	}
+
+	expectTags := [][]stats.Tag{
+		{stats.T("outcome", "success"), stats.T("path", "/"), stats.T("service", "api"), stats.T("table", "users"), stats.T("tenant", "acme")},
+		{stats.T("outcome", "error"), stats.T("path", "/"), stats.T("service", "api"), stats.T("table", "users"), stats.T("tenant", "acme")},
+		{stats.T("path", "/"), stats.T("service", "api"), stats.T("tenant", "acme")},
+		{stats.T("outcome", "success"), stats.T("path", "/"), stats.T("service", "api"), stats.T("tenant", "acme")},
+	}
+
+	expectNames := []string{"test.db.query", "test.db.query", "test.cache.hits", "test.request"}
+
+	for i, m := range found {
+		if m.Name != expectNames[i] {
+			t.Errorf("measure #%d: bad name: %s", i, m.Name)
+		}
+
+		if !reflect.DeepEqual(m.Tags, expectTags[i]) {
+			t.Errorf("measure #%d: bad tags: %v", i, m.Tags)
+		}
+	}
+
+	for _, m := range found[:2] {
+		if len(m.Fields) != 2 ||
+			m.Fields[0].Name != "duration" || m.Fields[0].Type() != stats.Histogram ||
+			m.Fields[1] != stats.MakeField("count", 1, stats.Counter) {
+			t.Error("bad fields:", m.Fields)
+		}
+
+		if d := m.Fields[0].Value.Duration(); d <= 0 || d > time.Second {
+			t.Error("bad duration:", d)
+		}
+	}
+}
+
+func TestTimerChild(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("", h)
+
+	_, parent := eng.Start(nil, "request", stats.T("path", "/"))
+	_, child := parent.Start("render")
+	child.End(nil)
+
+	found := h.Measures()
+
+	if len(found) != 1 || found[0].Name != "render" || !reflect.DeepEqual(found[0].Tags, []stats.Tag{
+		stats.T("outcome", "success"),
+		stats.T("path", "/"),
+	}) {
+		t.Error("bad measures:", found)
+	}
+
+	if tags := stats.TagsFromContext(parent.Context()); !reflect.DeepEqual(tags, []stats.Tag{stats.T("path", "/")}) {
+		t.Error("bad context tags:", tags)
+	}
+}
+
+func TestTimerTagOverride(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("", h)
+
+	ctx, parent := eng.Start(context.Background(), "request", stats.T("table", "users"), stats.T("outcome", "x"))
+	_, child := eng.Start(ctx, "db.query", stats.T("table", "orders"))
+	child.End(nil)
+	parent.End(nil)
+
+	found := h.Measures()
+
+	if len(found) != 2 {
+		t.Fatal("bad number of measures:", found)
+	}
+
+	if !reflect.DeepEqual(found[0].Tags, []stats.Tag{stats.T("outcome", "success"), stats.T("table", "orders")}) {
+		t.Error("bad tags of the child timer:", found[0].Tags)
+	}
+
+	if !reflect.DeepEqual(found[1].Tags, []stats.Tag{stats.T("outcome", "success"), stats.T("table", "users")}) {
+		t.Error("bad tags of the parent timer:", found[1].Tags)
+	}
+}
+
+func BenchmarkTimer(b *testing.B) {
+	eng := stats.NewEngine("test", stats.Discard)
+	ctx := context.Background()
+
+	for i := 0; i != b.N; i++ {
+		var err error
+		_, t := eng.Start(ctx, "db.query", stats.T("table", "users"))
+		t.End(&err)
+	}
+}
@@ -0,0 +1,267 @@
+package stats
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MetricsError is the error type returned by ValidateMetrics when a metrics
+// type is invalid, it lists all the problems found in the type.
+type MetricsError struct {
+	Type     reflect.Type
+	Problems []MetricsProblem
+}
+
+// MetricsProblem describes an issue with a single field of a metrics type.
+type MetricsProblem struct {
+	// The path to the struct field, made of the names of the fields leading
+	// to it from the metrics type, separated by dots.
+	Path string
+
+	// A description of the issue.
+	Reason string
+}
+
+// Error satisfies the error interface.
+func (e *MetricsError) Error() string {
+	s := make([]string, len(e.Problems))
+
+	for i, p := range e.Problems {
+		s[i] = p.String()
+	}
+
+	name := "<nil>"
+
+	if e.Type != nil {
+		name = e.Type.String()
+	}
+
+	return "stats: invalid metrics type " + name + ": " + strings.Join(s, ", ")
+}
+
+func (p MetricsProblem) String() string {
+	if len(p.Path) == 0 {
+		return p.Reason
+	}
+	return p.Path + ": " + p.Reason
+}
+
+// ValidateMetrics checks that the type of v can be used to report metrics with
+// MakeMeasures or the Report methods of engines, which panic when they are
+// given invalid types.
+//
+// The function checks the 'metric', 'tag', 'type' and 'source' struct tags of
+// all fields of the type, recursively, and returns a *MetricsError listing all
+// problems it found, or nil if the type is valid. Programs typically call it
+// from unit tests to catch mistakes in metrics types before they reach
+// production.
+//
+// The v argument may be a struct, a pointer to a struct, or a slice or array of
+// one of those, a nil pointer of the right type is enough.
+func ValidateMetrics(v interface{}) error {
+	typ := reflect.TypeOf(v)
+
+	if typ == nil {
+		return &MetricsError{
+			Problems: []MetricsProblem{{Reason: "metrics cannot be constructed from a nil value"}},
+		}
+	}
+
+	return validateMetricsType(typ)
+}
+
+func validateMetricsType(typ reflect.Type) error {
+	e := &MetricsError{Type: typ}
+	typ = metricsElemType(typ)
+
+	if typ.Kind() != reflect.Struct {
+		e.add("", "measures can only be constructed from struct types but "+typ.String()+" was given")
+	} else {
+		e.validate(typ, "", map[reflect.Type]bool{})
+	}
+
+	if len(e.Problems) != 0 {
+		return e
+	}
+
+	return nil
+}
+
+func (e *MetricsError) validate(typ reflect.Type, path string, seen map[reflect.Type]bool) {
+	for i, n := 0, typ.NumField(); i != n; i++ {
+		field := typ.Field(i)
+		fpath := concat(path, field.Name)
+		kind := field.Type.Kind()
+
+		if err := checkStructTag(field.Tag); err != "" {
+			e.add(fpath, "malformed struct tag `"+string(field.Tag)+"`: "+err)
+		}
+
+		if tag, ok := field.Tag.Lookup("tag"); ok {
+			switch {
+			case len(tag) == 0:
+				e.add(fpath, "empty 'tag' struct tag")
+			case isExpandedKind(kind):
+				// the tag names the key or index of the elements
+			case makeTagFunc(structField{typ: field.Type}, tag) == nil:
+				e.add(fpath, "unsupported value type for tag "+strconv.Quote(tag)+": "+field.Type.String())
+			}
+		}
+
+		metric, hasMetric := field.Tag.Lookup("metric")
+		mtype, hasType := field.Tag.Lookup("type")
+
+		if hasType && !isFieldTypeName(mtype) {
+			e.add(fpath, "unsupported metric type "+strconv.Quote(mtype))
+		}
+
+		if source, ok := field.Tag.Lookup("source"); ok {
+			switch {
+			case source != "cumulative":
+				e.add(fpath, "unsupported metric source "+strconv.Quote(source))
+			case mtype != "counter":
+				e.add(fpath, "cumulative metrics must be counters")
+			}
+		}
+
+		switch {
+		case kind == reflect.Struct:
+			e.validate(field.Type, fpath, seen)
+
+		case isExpandedKind(kind) && hasMetric:
+			e.validateElem(field.Type, fpath, metric, seen)
+
+		case hasMetric && len(metric) == 0:
+			e.add(fpath, "empty 'metric' struct tag")
+
+		case len(metric) != 0:
+			if makeFieldFunc(structField{typ: field.Type}, metric, Histogram) == nil {
+				e.add(fpath, "unsupported value type for metric "+strconv.Quote(metric)+": "+field.Type.String())
+			}
+
+		case hasType:
+			e.add(fpath, "'type' struct tag set on a field without a 'metric' struct tag")
+		}
+	}
+}
+
+// validateElem validates the elements of pointer, map, slice and array fields.
+func (e *MetricsError) validateElem(typ reflect.Type, path string, metric string, seen map[reflect.Type]bool) {
+	elem := typ.Elem()
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		if elem.Kind() != reflect.Struct {
+			e.add(path, "unsupported pointer type for metric "+strconv.Quote(metric)+": "+typ.String())
+			return
+		}
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			e.add(path, "unsupported key type for metric "+strconv.Quote(metric)+": "+typ.String())
+			return
+		}
+	}
+
+	if elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct {
+		elem = elem.Elem()
+	}
+
+	switch {
+	case elem.Kind() == reflect.Struct:
+		// Pointers may lead to recursive types, which are valid as long as
+		// their structs are.
+		if !seen[elem] {
+			seen[elem] = true
+			e.validate(elem, path, seen)
+		}
+
+	case len(metric) == 0:
+		e.add(path, "empty 'metric' struct tag")
+
+	case makeFieldFunc(structField{typ: elem}, metric, Histogram) == nil:
+		e.add(path, "unsupported element type for metric "+strconv.Quote(metric)+": "+typ.String())
+	}
+}
+
+// metricsElemType returns the type of the struct values reported when metrics
+// of type typ are given to MakeMeasures, looking through pointers, slices and
+// arrays.
+func metricsElemType(typ reflect.Type) reflect.Type {
+	for {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			typ = typ.Elem()
+		default:
+			return typ
+		}
+	}
+}
+
+func (e *MetricsError) add(path string, reason string) {
+	e.Problems = append(e.Problems, MetricsProblem{Path: path, Reason: reason})
+}
+
+func isFieldTypeName(mtype string) bool {
+	switch mtype {
+	case "counter", "gauge", "histogram", "distribution", "set":
+		return true
+	}
+	return false
+}
+
+// checkStructTag returns a description of the syntax error in tag, or an empty
+// string if the tag follows the conventional format. Only tags using one of the
+// keys of the stats package are checked, the syntax errors would otherwise make
+// reflect.StructTag.Get silently ignore them.
+func checkStructTag(tag reflect.StructTag) string {
+	s := string(tag)
+
+	for _, key := range [...]string{"metric:", "tag:", "type:", "source:"} {
+		if strings.Contains(s, key) {
+			return checkStructTagSyntax(s)
+		}
+	}
+
+	return ""
+}
+
+func checkStructTagSyntax(s string) string {
+
+	for {
+		s = strings.TrimLeft(s, " ")
+
+		if len(s) == 0 {
+			return ""
+		}
+
+		i := 0
+		for i < len(s) && s[i] > ' ' && s[i] != ':' && s[i] != '"' && s[i] != 0x7f {
+			i++
+		}
+
+		if i == 0 || i+1 >= len(s) || s[i] != ':' || s[i+1] != '"' {
+			return "bad syntax for struct tag pair"
+		}
+
+		s = s[i+1:]
+		i = 1
+
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+
+		if i >= len(s) {
+			return "unterminated struct tag value"
+		}
+
+		if _, err := strconv.Unquote(s[:i+1]); err != nil {
+			return "bad syntax for struct tag value"
+		}
+
+		s = s[i+1:]
+	}
+}
@@ -0,0 +1,163 @@
+package stats_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sniperkit/stats"
+	"github.com/sniperkit/stats/tests"
+)
+
+type validMetrics struct {
+	request struct {
+		count int           `metric:"count" type:"counter"`
+		rtt   time.Duration `metric:"rtt"   type:"histogram"`
+		users uint64        `metric:"users" type:"set"`
+		code  int           `tag:"code"`
+	} `metric:"request"`
+
+	shards []struct {
+		count int `metric:"count" type:"counter"`
+	} `metric:"shard" tag:"shard"`
+
+	next *validMetrics `metric:"next"`
+
+	host string `tag:"host"`
+}
+
+type invalidMetrics struct {
+	request struct {
+		count  int         `metric:"count" type:"counter"`
+		size   int         `metric:"size"  type:"histgram"`
+		values []chan int  `metric:"values"`
+		byID   map[int]int `metric:"by.id"`
+		level  *int        `metric:"level"`
+		err    error       `tag:"error"`
+	} `metric:"request"`
+
+	level float64 `type:"gauge"`
+	name  string  `tag:""`
+}
+
+func TestValidateMetrics(t *testing.T) {
+	for _, v := range []interface{}{
+		validMetrics{},
+		&validMetrics{},
+		[]validMetrics{},
+		(*[2]validMetrics)(nil),
+	} {
+		if err := stats.ValidateMetrics(v); err != nil {
+			t.Errorf("%T: unexpected error: %s", v, err)
+		}
+	}
+
+	err := stats.ValidateMetrics(&invalidMetrics{})
+
+	if err == nil {
+		t.Fatal("no error returned for an invalid metrics type")
+	}
+
+	e, ok := err.(*stats.MetricsError)
+
+	if !ok {
+		t.Fatalf("bad error type: %T", err)
+	}
+
+	paths := []string{}
+
+	for _, p := range e.Problems {
+		paths = append(paths, p.Path)
+	}
+
+	expect := []string{
+		"request.size",
+		"request.values",
+		"request.byID",
+		"request.level",
+		"request.err",
+		"level",
+		"name",
+	}
+
+	if !reflect.DeepEqual(paths, expect) {
+		t.Error("bad problems:")
+		t.Logf("expected: %v", expect)
+		t.Logf("found:    %v", paths)
+		t.Log(err)
+	}
+}
+
+func TestValidateMetricsMalformedStructTag(t *testing.T) {
+	// The type is constructed dynamically because go vet rejects malformed
+	// struct tags in source code.
+	typ := reflect.StructOf([]reflect.StructField{
+		{Name: "Count", Type: reflect.TypeOf(0), Tag: `metric:"count" type:"counter"`},
+		{Name: "Operation", Type: reflect.TypeOf(""), Tag: `tag:"operation'`},
+	})
+
+	err := stats.ValidateMetrics(reflect.New(typ).Interface())
+
+	if err == nil {
+		t.Fatal("no error returned for a malformed struct tag")
+	}
+
+	if p := err.(*stats.MetricsError).Problems; len(p) != 1 || p[0].Path != "Operation" {
+		t.Error("bad problems:", p)
+	}
+}
+
+func TestValidateMetricsNotStruct(t *testing.T) {
+	for _, v := range []interface{}{nil, 42, []string{}} {
+		if err := stats.ValidateMetrics(v); err == nil {
+			t.Errorf("%T: no error returned for a type that isn't a struct", v)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestEngineRegisterType(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h)
+
+	if err := eng.RegisterType(&invalidMetrics{}); err == nil {
+		t.Error("no error returned when registering an invalid metrics type")
+	}
+
+	eng.MustRegisterType(&validMetrics{})
+
+	m := validMetrics{host: "localhost"}
+	m.request.count = 1
+	eng.Report(m)
+
+	if n := len(h.Measures()); n != 1 {
+		t.Error("bad number of measures:", n)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRegisterType did not panic on an invalid metrics type")
+		}
+	}()
+
+	eng.MustRegisterType(invalidMetrics{})
+}
+
+func TestValidateMetricsSource(t *testing.T) {
+	var m struct {
+		a int `metric:"a" type:"counter" source:"cumulative"`
+		b int `metric:"b" type:"gauge"   source:"cumulative"`
+		c int `metric:"c" type:"counter" source:"total"`
+	}
+
+	err := stats.ValidateMetrics(&m)
+
+	if err == nil {
+		t.Fatal("no error returned for invalid metric sources")
+	}
+
+	if p := err.(*stats.MetricsError).Problems; len(p) != 2 || p[0].Path != "b" || p[1].Path != "c" {
+		t.Error("bad problems:", p)
+	}
+}
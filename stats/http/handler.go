@@ -32,12 +32,35 @@ func NewHandlerWith(eng *stats.Engine, h http.Handler) http.Handler {
 	}
 }
 
+// NewHandlerWithTags wraps h to produce metrics on eng for every request
+// received and every response sent.
+//
+// The context of each request is seeded with the tags returned by calling tags
+// on the request, they are set on the metrics produced by the handler, and are
+// inherited by the metrics that the program produces with the context-aware
+// methods of the stats package (like stats.IncrContext) while serving the
+// request.
+func NewHandlerWithTags(eng *stats.Engine, h http.Handler, tags func(*http.Request) []stats.Tag) http.Handler {
+	return &handler{
+		handler: h,
+		eng:     eng,
+		tags:    tags,
+	}
+}
+
 type handler struct {
 	handler http.Handler
 	eng     *stats.Engine
+	tags    func(*http.Request) []stats.Tag
 }
 
 func (h *handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if h.tags != nil {
+		if tags := h.tags(req); len(tags) != 0 {
+			req = req.WithContext(stats.ContextWithTags(req.Context(), tags...))
+		}
+	}
+
 	m := &metrics{}
 
 	w := &responseWriter{
@@ -130,7 +153,7 @@ func (w *responseWriter) complete() {
 	}
 
 	w.metrics.observeResponse(res, "write", w.bytes, now.Sub(w.start))
-	w.eng.ReportAt(w.start, w.metrics)
+	w.eng.ReportAtContext(w.req.Context(), w.start, w.metrics)
 
 	stats.Log.Entry.DebugWithFields(logger.Fields{
 		"w.metrics": w.metrics,
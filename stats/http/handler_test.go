@@ -53,6 +53,49 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestHandlerWithTags(t *testing.T) {
+	h := &statstest.Handler{}
+	e := stats.NewEngine("", h)
+
+	tags := func(req *http.Request) []stats.Tag {
+		return []stats.Tag{stats.T("tenant", req.Header.Get("X-Tenant"))}
+	}
+
+	server := httptest.NewServer(NewHandlerWithTags(e, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		e.IncrContext(req.Context(), "calls")
+		res.WriteHeader(http.StatusOK)
+	}), tags))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("X-Tenant", "A")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	measures := h.Measures()
+
+	if len(measures) < 2 {
+		t.Fatal("not enough measures reported by http handler:", measures)
+	}
+
+	for _, m := range measures {
+		found := false
+		for _, tag := range m.Tags {
+			if tag == stats.T("tenant", "A") {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("measure is missing the tag seeded in the request context:", m)
+		}
+	}
+}
+
 func TestHandlerHijack(t *testing.T) {
 	h := &statstest.Handler{}
 	e := stats.NewEngine("", h)
@@ -8,9 +8,16 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/sniperkit/stats"
 	"github.com/sniperkit/stats/collector/iostats"
 )
 
+func TestMetricsAreValid(t *testing.T) {
+	if err := stats.ValidateMetrics(&metrics{}); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestResponseStatusBucket(t *testing.T) {
 	tests := []struct {
 		status int
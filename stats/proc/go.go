@@ -22,6 +22,9 @@ func init() {
 }
 
 // GoMetrics is a metric collector that reports metrics from the Go runtime.
+//
+// Counters report the change since the previous call to Collect, the first call
+// reports the totals since the program started.
 type GoMetrics struct {
 	engine  *stats.Engine
 	version string `tag:"version"`
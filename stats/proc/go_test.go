@@ -36,3 +36,32 @@ func TestGoMetrics(t *testing.T) {
 		time.Sleep(10 * time.Millisecond)
 	}
 }
+
+func TestGoMetricsFirstCollect(t *testing.T) {
+	h := &statstest.Handler{}
+	e := stats.NewEngine("", h)
+
+	runtime.GC()
+	NewGoMetricsWith(e).Collect()
+
+	counters := map[string]uint64{}
+
+	for _, m := range h.Measures() {
+		for _, f := range m.Fields {
+			if f.Type() == stats.Counter {
+				counters[m.Name+":"+f.Name] += f.Value.Uint()
+			}
+		}
+	}
+
+	// The first collect reports the totals since the program started.
+	for _, name := range []string{
+		"go.memstats:total_alloc.bytes",
+		"go.memstats:mallocs.count",
+		"go.memstats:gc.count",
+	} {
+		if counters[name] == 0 {
+			t.Errorf("%s: the first collect must report a non-zero total", name)
+		}
+	}
+}
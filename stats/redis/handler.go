@@ -2,6 +2,7 @@ package redisstats
 
 import (
 	"bufio"
+	"context"
 	"reflect"
 	"time"
 
@@ -27,14 +28,42 @@ func NewHandlerWith(engine *stats.Engine, h redis.Handler) redis.Handler {
 	}
 }
 
+// NewHandlerWithTags wraps h to produce metrics on eng for every request
+// received and every response sent.
+//
+// The context of each request is seeded with the tags returned by calling tags
+// on the request, so they are set on the metrics produced by the handler and
+// inherited by the metrics that h produces with the context-aware methods of
+// the stats package.
+func NewHandlerWithTags(engine *stats.Engine, h redis.Handler, tags func(*redis.Request) []stats.Tag) redis.Handler {
+	return &handler{
+		handler: h,
+		engine:  engine,
+		tags:    tags,
+	}
+}
+
 type handler struct {
 	handler redis.Handler
 	engine  *stats.Engine
+	tags    func(*redis.Request) []stats.Tag
 }
 
 // ServeRedis implements the redis.Handler interface.
 // It records a metric for each request.
 func (h *handler) ServeRedis(res redis.ResponseWriter, req *redis.Request) {
+	if h.tags != nil {
+		if tags := h.tags(req); len(tags) != 0 {
+			ctx := req.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			r := *req
+			r.Context = stats.ContextWithTags(ctx, tags...)
+			req = &r
+		}
+	}
+
 	w := &responseWriter{base: res, count: 1, start: time.Now()}
 	w.cmd = make([]commandMetrics, len(req.Cmds))
 	w.req.request.count = 1
@@ -66,8 +95,8 @@ func (h *handler) ServeRedis(res redis.ResponseWriter, req *redis.Request) {
 		}
 	}
 
-	h.engine.ReportAt(w.start, &w.req)
-	h.engine.ReportAt(w.start, &w.cmd)
+	h.engine.ReportAtContext(req.Context, w.start, &w.req)
+	h.engine.ReportAtContext(req.Context, w.start, &w.cmd)
 }
 
 type responseWriter struct {
@@ -39,6 +39,38 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestHandlerWithTags(t *testing.T) {
+	statsHandler := &statstest.Handler{}
+	e := stats.NewEngine("", statsHandler)
+
+	h := NewHandlerWithTags(e, redis.HandlerFunc(func(res redis.ResponseWriter, req *redis.Request) {
+		e.IncrContext(req.Context, "calls")
+		res.Write("OK")
+	}), func(req *redis.Request) []stats.Tag {
+		return []stats.Tag{stats.T("tenant", "A")}
+	})
+
+	h.ServeRedis(&testResponseWriter{},
+		redis.NewRequest("127.0.0.1:6379", "GET", redis.List("foo")))
+
+	measures := statsHandler.Measures()
+	if len(measures) < 2 {
+		t.Fatal("not enough measures were produced:", measures)
+	}
+
+	for _, m := range measures {
+		found := false
+		for _, tag := range m.Tags {
+			if tag == stats.T("tenant", "A") {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("measure is missing the tag seeded in the request context:", m)
+		}
+	}
+}
+
 type testRedisHandler struct{}
 
 func (*testRedisHandler) ServeRedis(res redis.ResponseWriter, req *redis.Request) {
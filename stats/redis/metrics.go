@@ -24,7 +24,7 @@ type commandMetrics struct {
 
 	error struct {
 		count     int    `metric:"count" type:"counter"`
-		operation string `tag:"operation'`
+		operation string `tag:"operation"`
 		errtype   string `tag:"type"`
 	} `metric:"redis.error"`
 
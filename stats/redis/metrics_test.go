@@ -11,3 +11,11 @@ func validateMeasure(t *testing.T, found stats.Measure, expect stats.Measure) {
 	if !reflect.DeepEqual(found, expect) {
 	}
 }
+
+func TestMetricsAreValid(t *testing.T) {
+	for _, v := range []interface{}{&requestMetrics{}, &commandMetrics{}} {
+		if err := stats.ValidateMetrics(v); err != nil {
+			t.Error(err)
+		}
+	}
+}